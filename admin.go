@@ -0,0 +1,380 @@
+package main
+
+import (
+	"advanced-lb/balancer"
+	"advanced-lb/features"
+	"advanced-lb/health"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type qLearningState struct {
+	qTable     map[string]float64
+	qTableB    map[string]float64
+	counts     map[string]int64
+	epsilon    float64
+	gamma      float64
+	maxQValue  float64
+	lastQDelta float64
+}
+
+var savedQState map[string]*qLearningState
+
+func exportQLearningState(ql *balancer.QLearning) *qLearningState {
+	st := &qLearningState{
+		qTable:  make(map[string]float64),
+		qTableB: make(map[string]float64),
+		counts:  make(map[string]int64),
+	}
+	ql.ExportState(&st.qTable, &st.qTableB, &st.counts, &st.epsilon, &st.gamma, &st.maxQValue, &st.lastQDelta)
+	return st
+}
+
+func (st *qLearningState) restore(ql *balancer.QLearning) {
+	ql.ImportState(st.qTable, st.qTableB, st.counts, st.epsilon, st.gamma, st.maxQValue, st.lastQDelta)
+}
+
+func algorithmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	algorithm := r.URL.Query().Get("algorithm")
+	if algorithm == "" {
+		var body struct {
+			Algorithm string `json:"algorithm"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+			algorithm = body.Algorithm
+		}
+	}
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	mu.RLock()
+	cfg := *currentCfg
+	learners := balancer.QLearners(globalLB)
+	mu.RUnlock()
+	wasQL := len(learners) > 0
+
+	cfg.Algorithm = algorithm
+	if err := validateConfig(&cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if wasQL {
+		savedQState = make(map[string]*qLearningState)
+		for cluster, ql := range learners {
+			savedQState[cluster] = exportQLearningState(ql)
+		}
+	}
+
+	applyConfig(&cfg)
+
+	mu.RLock()
+	if !wasQL && savedQState != nil {
+		restored := false
+		for cluster, ql := range balancer.QLearners(globalLB) {
+			if state, ok := savedQState[cluster]; ok {
+				state.restore(ql)
+				restored = true
+			}
+		}
+		if restored {
+			log.Println("Q-Learning state restored after algorithm switch")
+		}
+	}
+	mu.RUnlock()
+
+	log.Printf("Switched balancing algorithm to %s", algorithm)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Algorithm switched to " + algorithm))
+}
+
+func backendHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/admin/backends/")
+	if !strings.HasSuffix(rest, "/history") {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := url.PathUnescape(strings.TrimSuffix(rest, "/history"))
+	if err != nil || id == "" {
+		http.Error(w, "Missing or invalid backend id", http.StatusBadRequest)
+		return
+	}
+
+	if u := r.URL.Query().Get("url"); u != "" {
+		id = u
+	}
+	history, ok := health.History(backendID(id))
+	if !ok {
+		http.Error(w, "No health history for backend", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+func backendID(id string) string {
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			if b.URL.Host == id || b.URL.String() == id {
+				return b.URL.String()
+			}
+		}
+	}
+	return id
+}
+
+func healthBackendsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type backendStatus struct {
+		Backend             string            `json:"backend"`
+		Labels              map[string]string `json:"labels,omitempty"`
+		Alive               bool              `json:"alive"`
+		Draining            bool              `json:"draining"`
+		Ejected             bool              `json:"ejected"`
+		Warming             bool              `json:"warming"`
+		LastCheck           *time.Time        `json:"last_check,omitempty"`
+		ConsecutiveFailures int               `json:"consecutive_failures"`
+		CircuitBreaker      string            `json:"circuit_breaker"`
+		ActiveConnections   int64             `json:"active_connections"`
+		SinceTransitionSec  *float64          `json:"since_transition_s,omitempty"`
+	}
+
+	selector := make(map[string]string)
+	for _, l := range r.URL.Query()["label"] {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			http.Error(w, "label must be key=value", http.StatusBadRequest)
+			return
+		}
+		selector[parts[0]] = parts[1]
+	}
+
+	seen := make(map[string]bool)
+	statuses := []backendStatus{}
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			key := b.URL.String()
+			if seen[key] || !b.MatchLabels(selector) {
+				continue
+			}
+			seen[key] = true
+
+			st := backendStatus{
+				Backend:           key,
+				Labels:            b.Labels,
+				Alive:             b.IsAlive(),
+				Draining:          b.IsDraining(),
+				Ejected:           b.IsEjected(),
+				Warming:           b.IsWarming(),
+				CircuitBreaker:    b.CircuitBreaker.State(),
+				ActiveConnections: atomic.LoadInt64(&b.ActiveConnections),
+			}
+			if h, ok := health.CurrentStatus(b.URL.String()); ok {
+				st.LastCheck = &h.LastCheck
+				st.ConsecutiveFailures = h.ConsecutiveFailures
+				if !h.LastTransition.IsZero() {
+					since := time.Since(h.LastTransition).Seconds()
+					st.SinceTransitionSec = &since
+				}
+			}
+			statuses = append(statuses, st)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func qLearningStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type learnerStats struct {
+		QTable     map[string]float64 `json:"q_table"`
+		Counts     map[string]int64   `json:"counts"`
+		Epsilon    float64            `json:"epsilon"`
+		Gamma      float64            `json:"gamma"`
+		MaxQValue  float64            `json:"max_q_value"`
+		LastQDelta float64            `json:"last_q_delta"`
+	}
+
+	out := make(map[string]learnerStats)
+	for namespace, ql := range qLearners() {
+		st := exportQLearningState(ql)
+		if namespace == "" {
+			namespace = "default"
+		}
+		out[namespace] = learnerStats{
+			QTable:     st.qTable,
+			Counts:     st.counts,
+			Epsilon:    st.epsilon,
+			Gamma:      st.gamma,
+			MaxQValue:  st.maxQValue,
+			LastQDelta: st.lastQDelta,
+		}
+	}
+	if len(out) == 0 {
+		http.Error(w, "No Q-learning balancer is active", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func qLearningResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	learners := qLearners()
+	if len(learners) == 0 {
+		http.Error(w, "No Q-learning balancer is active", http.StatusNotFound)
+		return
+	}
+	for _, ql := range learners {
+		ql.Reset()
+	}
+
+	log.Printf("Q-learning state reset for %d balancers", len(learners))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Q-learning state reset"))
+}
+
+func qLearningForgetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.URL.Query().Get("backend")
+	u, err := url.Parse(target)
+	if target == "" || err != nil {
+		http.Error(w, "Missing or invalid backend parameter", http.StatusBadRequest)
+		return
+	}
+
+	learners := qLearners()
+	if len(learners) == 0 {
+		http.Error(w, "No Q-learning balancer is active", http.StatusNotFound)
+		return
+	}
+	for _, ql := range learners {
+		ql.Forget(u)
+	}
+
+	log.Printf("Q-learning forgot learned values for %s", u)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Backend forgotten"))
+}
+
+func weightHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.URL.Query().Get("backend")
+	u, err := url.Parse(target)
+	if target == "" || err != nil {
+		http.Error(w, "Missing or invalid backend parameter", http.StatusBadRequest)
+		return
+	}
+	weight, err := strconv.Atoi(r.URL.Query().Get("weight"))
+	if err != nil || weight < 0 {
+		http.Error(w, "Missing or invalid weight parameter", http.StatusBadRequest)
+		return
+	}
+
+	found := false
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			if b.URL.String() == u.String() {
+				found = true
+				lb.SetWeight(u, weight)
+				break
+			}
+		}
+	}
+	if !found {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+
+	if weight == 0 {
+		log.Printf("Backend %s moved to standby", u)
+	} else {
+		log.Printf("Backend %s weight set to %d", u, weight)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Weight updated"))
+}
+
+func heatmapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	window := durationOr(q.Get("window"), 15*time.Minute)
+	resolution := features.HeatmapResolution(durationOr(q.Get("resolution"), time.Minute))
+	if window <= 0 || resolution > window {
+		http.Error(w, "window and resolution must be positive, with resolution <= window", http.StatusBadRequest)
+		return
+	}
+	only := q.Get("backend")
+
+	now := time.Now()
+	current := make(map[string]bool)
+	series := make(map[string][]features.HeatmapColumn)
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			key := b.URL.String()
+			current[key] = true
+			if only != "" && key != only {
+				continue
+			}
+			if _, ok := series[key]; ok {
+				continue
+			}
+			series[key] = features.BackendHeatmap(key, now, window, resolution)
+		}
+	}
+	features.PruneBackendHeatmaps(current)
+	if only != "" && !current[only] {
+		http.Error(w, "Unknown backend", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bounds_ms":    features.HeatmapBoundsMs(),
+		"resolution_s": resolution.Seconds(),
+		"backends":     series,
+	})
+}
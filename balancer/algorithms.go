@@ -21,7 +21,7 @@ func NewRoundRobin(pool *ServerPool) *RoundRobin {
 }
 
 func (rr *RoundRobin) NextBackend(r *http.Request) *Backend {
-	backends := rr.pool.Backends
+	backends := rr.pool.Snapshot()
 	l := len(backends)
 	if l == 0 {
 		return nil
@@ -30,7 +30,7 @@ func (rr *RoundRobin) NextBackend(r *http.Request) *Backend {
 	start := atomic.AddUint64(&rr.pool.current, 1)
 	for i := 0; i < l; i++ {
 		idx := int((start + uint64(i)) % uint64(l))
-		if backends[idx].IsAlive() {
+		if rr.pool.IsSelectable(backends[idx]) {
 			return backends[idx]
 		}
 	}
@@ -38,11 +38,11 @@ func (rr *RoundRobin) NextBackend(r *http.Request) *Backend {
 }
 
 func (rr *RoundRobin) AddBackend(b *Backend) {
-	rr.pool.Backends = append(rr.pool.Backends, b)
+	rr.pool.AddBackend(b)
 }
 
 func (rr *RoundRobin) UpdateBackendStatus(u *url.URL, alive bool) {
-	for _, b := range rr.pool.Backends {
+	for _, b := range rr.pool.Snapshot() {
 		if b.URL.String() == u.String() {
 			b.SetAlive(alive)
 			break
@@ -50,8 +50,25 @@ func (rr *RoundRobin) UpdateBackendStatus(u *url.URL, alive bool) {
 	}
 }
 
+func (rr *RoundRobin) Drain(u *url.URL) {
+	for _, b := range rr.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (rr *RoundRobin) RemoveBackend(u *url.URL) {
+	rr.pool.RemoveBackend(u)
+}
+
+func (rr *RoundRobin) SetWeight(u *url.URL, weight int) {
+	rr.pool.SetWeight(u, weight)
+}
+
 func (rr *RoundRobin) GetBackends() []*Backend {
-	return rr.pool.Backends
+	return rr.pool.Snapshot()
 }
 
 func (rr *RoundRobin) OnRequestCompletion(u *url.URL, duration time.Duration, err error) {
@@ -71,8 +88,8 @@ func (lc *LeastConnections) NextBackend(r *http.Request) *Backend {
 	var best *Backend
 	var min int64 = -1
 
-	for _, b := range lc.pool.Backends {
-		if !b.IsAlive() {
+	for _, b := range lc.pool.Snapshot() {
+		if !lc.pool.IsSelectable(b) {
 			continue
 		}
 		conn := atomic.LoadInt64(&b.ActiveConnections)
@@ -85,11 +102,11 @@ func (lc *LeastConnections) NextBackend(r *http.Request) *Backend {
 }
 
 func (lc *LeastConnections) AddBackend(b *Backend) {
-	lc.pool.Backends = append(lc.pool.Backends, b)
+	lc.pool.AddBackend(b)
 }
 
 func (lc *LeastConnections) UpdateBackendStatus(u *url.URL, alive bool) {
-	for _, b := range lc.pool.Backends {
+	for _, b := range lc.pool.Snapshot() {
 		if b.URL.String() == u.String() {
 			b.SetAlive(alive)
 			break
@@ -97,39 +114,65 @@ func (lc *LeastConnections) UpdateBackendStatus(u *url.URL, alive bool) {
 	}
 }
 
+func (lc *LeastConnections) Drain(u *url.URL) {
+	for _, b := range lc.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (lc *LeastConnections) RemoveBackend(u *url.URL) {
+	lc.pool.RemoveBackend(u)
+}
+
+func (lc *LeastConnections) SetWeight(u *url.URL, weight int) {
+	lc.pool.SetWeight(u, weight)
+}
+
 func (lc *LeastConnections) GetBackends() []*Backend {
-	return lc.pool.Backends
+	return lc.pool.Snapshot()
 }
 
 func (lc *LeastConnections) OnRequestCompletion(u *url.URL, duration time.Duration, err error) {
 }
 
 type WeightedRoundRobin struct {
-	pool    *ServerPool
-	mu      sync.RWMutex
-	indices []int
+	pool     *ServerPool
+	mu       sync.RWMutex
+	indices  []int
+	backends []*Backend
 }
 
 func NewWeightedRoundRobin(pool *ServerPool) *WeightedRoundRobin {
 	wrr := &WeightedRoundRobin{
-		pool:    pool,
-		indices: make([]int, 0),
+		pool: pool,
 	}
-	for i, b := range pool.Backends {
-		w := b.Weight
+	wrr.rebuild()
+	return wrr
+}
+
+func (wrr *WeightedRoundRobin) rebuild() {
+	backends := wrr.pool.Snapshot()
+	indices := make([]int, 0)
+	for i, b := range backends {
+		w := b.Weight()
 		if w <= 0 {
 			w = 1
 		}
 		for j := 0; j < w; j++ {
-			wrr.indices = append(wrr.indices, i)
+			indices = append(indices, i)
 		}
 	}
-	return wrr
+	wrr.indices = indices
+	wrr.backends = backends
 }
 
 func (wrr *WeightedRoundRobin) NextBackend(r *http.Request) *Backend {
 	wrr.mu.RLock()
 	indices := wrr.indices
+	backends := wrr.backends
 	wrr.mu.RUnlock()
 
 	l := len(indices)
@@ -141,9 +184,9 @@ func (wrr *WeightedRoundRobin) NextBackend(r *http.Request) *Backend {
 	for i := 0; i < l; i++ {
 		idxVal := int((start + uint64(i)) % uint64(l))
 		backendIdx := indices[idxVal]
-		if backendIdx < len(wrr.pool.Backends) {
-			b := wrr.pool.Backends[backendIdx]
-			if b.IsAlive() {
+		if backendIdx < len(backends) {
+			b := backends[backendIdx]
+			if wrr.pool.IsSelectable(b) {
 				return b
 			}
 		}
@@ -152,21 +195,28 @@ func (wrr *WeightedRoundRobin) NextBackend(r *http.Request) *Backend {
 }
 
 func (wrr *WeightedRoundRobin) AddBackend(b *Backend) {
-	wrr.pool.Backends = append(wrr.pool.Backends, b)
 	wrr.mu.Lock()
 	defer wrr.mu.Unlock()
-	idx := len(wrr.pool.Backends) - 1
-	w := b.Weight
-	if w <= 0 {
-		w = 1
-	}
-	for j := 0; j < w; j++ {
-		wrr.indices = append(wrr.indices, idx)
-	}
+	wrr.pool.AddBackend(b)
+	wrr.rebuild()
+}
+
+func (wrr *WeightedRoundRobin) RemoveBackend(u *url.URL) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+	wrr.pool.RemoveBackend(u)
+	wrr.rebuild()
+}
+
+func (wrr *WeightedRoundRobin) SetWeight(u *url.URL, weight int) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+	wrr.pool.SetWeight(u, weight)
+	wrr.rebuild()
 }
 
 func (wrr *WeightedRoundRobin) UpdateBackendStatus(u *url.URL, alive bool) {
-	for _, b := range wrr.pool.Backends {
+	for _, b := range wrr.pool.Snapshot() {
 		if b.URL.String() == u.String() {
 			b.SetAlive(alive)
 			break
@@ -174,8 +224,19 @@ func (wrr *WeightedRoundRobin) UpdateBackendStatus(u *url.URL, alive bool) {
 	}
 }
 
+func (wrr *WeightedRoundRobin) Drain(u *url.URL) {
+	for _, b := range wrr.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
 func (wrr *WeightedRoundRobin) GetBackends() []*Backend {
-	return wrr.pool.Backends
+	wrr.mu.RLock()
+	defer wrr.mu.RUnlock()
+	return wrr.pool.Snapshot()
 }
 
 func (wrr *WeightedRoundRobin) OnRequestCompletion(u *url.URL, d time.Duration, e error) {}
@@ -189,7 +250,7 @@ func NewIPHash(pool *ServerPool) *IPHash {
 }
 
 func (iph *IPHash) NextBackend(r *http.Request) *Backend {
-	backends := iph.pool.Backends
+	backends := iph.pool.Snapshot()
 	if len(backends) == 0 {
 		return nil
 	}
@@ -204,7 +265,7 @@ func (iph *IPHash) NextBackend(r *http.Request) *Backend {
 
 	for i := 0; i < len(backends); i++ {
 		idx := (startIdx + i) % len(backends)
-		if backends[idx].IsAlive() {
+		if iph.pool.IsSelectable(backends[idx]) {
 			return backends[idx]
 		}
 	}
@@ -212,11 +273,11 @@ func (iph *IPHash) NextBackend(r *http.Request) *Backend {
 }
 
 func (iph *IPHash) AddBackend(b *Backend) {
-	iph.pool.Backends = append(iph.pool.Backends, b)
+	iph.pool.AddBackend(b)
 }
 
 func (iph *IPHash) UpdateBackendStatus(u *url.URL, alive bool) {
-	for _, b := range iph.pool.Backends {
+	for _, b := range iph.pool.Snapshot() {
 		if b.URL.String() == u.String() {
 			b.SetAlive(alive)
 			break
@@ -224,16 +285,189 @@ func (iph *IPHash) UpdateBackendStatus(u *url.URL, alive bool) {
 	}
 }
 
+func (iph *IPHash) Drain(u *url.URL) {
+	for _, b := range iph.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (iph *IPHash) RemoveBackend(u *url.URL) {
+	iph.pool.RemoveBackend(u)
+}
+
+func (iph *IPHash) SetWeight(u *url.URL, weight int) {
+	iph.pool.SetWeight(u, weight)
+}
+
 func (iph *IPHash) GetBackends() []*Backend {
-	return iph.pool.Backends
+	return iph.pool.Snapshot()
 }
 
 func (iph *IPHash) OnRequestCompletion(u *url.URL, d time.Duration, e error) {}
 
+type URIHash struct {
+	pool         *ServerPool
+	includeQuery bool
+}
+
+func NewURIHash(pool *ServerPool, includeQuery bool) *URIHash {
+	return &URIHash{pool: pool, includeQuery: includeQuery}
+}
+
+func (uh *URIHash) NextBackend(r *http.Request) *Backend {
+	backends := uh.pool.Snapshot()
+	if len(backends) == 0 {
+		return nil
+	}
+
+	key := r.URL.Path
+	if uh.includeQuery && r.URL.RawQuery != "" {
+		key += "?" + r.URL.RawQuery
+	}
+
+	checksum := crc32.ChecksumIEEE([]byte(key))
+	startIdx := int(checksum % uint32(len(backends)))
+
+	for i := 0; i < len(backends); i++ {
+		idx := (startIdx + i) % len(backends)
+		if uh.pool.IsSelectable(backends[idx]) {
+			return backends[idx]
+		}
+	}
+	return nil
+}
+
+func (uh *URIHash) AddBackend(b *Backend) {
+	uh.pool.AddBackend(b)
+}
+
+func (uh *URIHash) UpdateBackendStatus(u *url.URL, alive bool) {
+	for _, b := range uh.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetAlive(alive)
+			break
+		}
+	}
+}
+
+func (uh *URIHash) Drain(u *url.URL) {
+	for _, b := range uh.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (uh *URIHash) RemoveBackend(u *url.URL) {
+	uh.pool.RemoveBackend(u)
+}
+
+func (uh *URIHash) SetWeight(u *url.URL, weight int) {
+	uh.pool.SetWeight(u, weight)
+}
+
+func (uh *URIHash) GetBackends() []*Backend {
+	return uh.pool.Snapshot()
+}
+
+func (uh *URIHash) OnRequestCompletion(u *url.URL, d time.Duration, e error) {}
+
+type KeyHash struct {
+	pool   *ServerPool
+	source string
+	key    string
+}
+
+func NewKeyHash(pool *ServerPool, source, key string) *KeyHash {
+	return &KeyHash{pool: pool, source: source, key: key}
+}
+
+func (kh *KeyHash) hashKey(r *http.Request) string {
+	switch kh.source {
+	case "header":
+		if v := r.Header.Get(kh.key); v != "" {
+			return v
+		}
+	case "cookie":
+		if c, err := r.Cookie(kh.key); err == nil && c.Value != "" {
+			return c.Value
+		}
+	case "query":
+		if v := r.URL.Query().Get(kh.key); v != "" {
+			return v
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return ip
+}
+
+func (kh *KeyHash) NextBackend(r *http.Request) *Backend {
+	backends := kh.pool.Snapshot()
+	if len(backends) == 0 {
+		return nil
+	}
+
+	checksum := crc32.ChecksumIEEE([]byte(kh.hashKey(r)))
+	startIdx := int(checksum % uint32(len(backends)))
+
+	for i := 0; i < len(backends); i++ {
+		idx := (startIdx + i) % len(backends)
+		if kh.pool.IsSelectable(backends[idx]) {
+			return backends[idx]
+		}
+	}
+	return nil
+}
+
+func (kh *KeyHash) AddBackend(b *Backend) {
+	kh.pool.AddBackend(b)
+}
+
+func (kh *KeyHash) UpdateBackendStatus(u *url.URL, alive bool) {
+	for _, b := range kh.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetAlive(alive)
+			break
+		}
+	}
+}
+
+func (kh *KeyHash) Drain(u *url.URL) {
+	for _, b := range kh.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (kh *KeyHash) RemoveBackend(u *url.URL) {
+	kh.pool.RemoveBackend(u)
+}
+
+func (kh *KeyHash) SetWeight(u *url.URL, weight int) {
+	kh.pool.SetWeight(u, weight)
+}
+
+func (kh *KeyHash) GetBackends() []*Backend {
+	return kh.pool.Snapshot()
+}
+
+func (kh *KeyHash) OnRequestCompletion(u *url.URL, d time.Duration, e error) {}
+
 type LeastResponseTime struct {
-	pool  *ServerPool
-	stats map[string]int64
-	mux   sync.RWMutex
+	pool      *ServerPool
+	stats     map[string]int64
+	mux       sync.RWMutex
+	retention retention
 }
 
 func NewLeastResponseTime(pool *ServerPool) *LeastResponseTime {
@@ -250,8 +484,8 @@ func (lrt *LeastResponseTime) NextBackend(r *http.Request) *Backend {
 	var best *Backend
 	var minTime int64 = -1
 
-	for _, b := range lrt.pool.Backends {
-		if !b.IsAlive() {
+	for _, b := range lrt.pool.Snapshot() {
+		if !lrt.pool.IsSelectable(b) {
 			continue
 		}
 		t := lrt.stats[b.URL.String()]
@@ -267,11 +501,11 @@ func (lrt *LeastResponseTime) NextBackend(r *http.Request) *Backend {
 }
 
 func (lrt *LeastResponseTime) AddBackend(b *Backend) {
-	lrt.pool.Backends = append(lrt.pool.Backends, b)
+	lrt.pool.AddBackend(b)
 }
 
 func (lrt *LeastResponseTime) UpdateBackendStatus(u *url.URL, alive bool) {
-	for _, b := range lrt.pool.Backends {
+	for _, b := range lrt.pool.Snapshot() {
 		if b.URL.String() == u.String() {
 			b.SetAlive(alive)
 			break
@@ -279,8 +513,25 @@ func (lrt *LeastResponseTime) UpdateBackendStatus(u *url.URL, alive bool) {
 	}
 }
 
+func (lrt *LeastResponseTime) Drain(u *url.URL) {
+	for _, b := range lrt.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (lrt *LeastResponseTime) RemoveBackend(u *url.URL) {
+	lrt.pool.RemoveBackend(u)
+}
+
+func (lrt *LeastResponseTime) SetWeight(u *url.URL, weight int) {
+	lrt.pool.SetWeight(u, weight)
+}
+
 func (lrt *LeastResponseTime) GetBackends() []*Backend {
-	return lrt.pool.Backends
+	return lrt.pool.Snapshot()
 }
 
 func (lrt *LeastResponseTime) OnRequestCompletion(u *url.URL, d time.Duration, e error) {
@@ -293,4 +544,14 @@ func (lrt *LeastResponseTime) OnRequestCompletion(u *url.URL, d time.Duration, e
 	} else {
 		lrt.stats[u.String()] = (old + int64(d)) / 2
 	}
+
+	if now := time.Now(); lrt.retention.due(now) {
+		tracked := make([]string, 0, len(lrt.stats))
+		for k := range lrt.stats {
+			tracked = append(tracked, k)
+		}
+		for _, k := range lrt.retention.expired(lrt.pool, tracked, now) {
+			delete(lrt.stats, k)
+		}
+	}
 }
@@ -2,10 +2,16 @@ package balancer
 
 import (
 	"advanced-lb/features"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,10 +20,23 @@ type Backend struct {
 	Alive             bool
 	mux               sync.RWMutex
 	ReverseProxy      *httputil.ReverseProxy
-	Weight            int
+	weight            int64
 	ActiveConnections int64
+	MaxConnections    int64
+	Zone              string
+	Cluster           string
+	Labels            map[string]string
 	Stats             BackendStats
 	CircuitBreaker    *features.CircuitBreaker
+	SlowStart         time.Duration
+	HealthRise        int
+	HealthFall        int
+	InitialDelay      time.Duration
+	recoveredAt       time.Time
+	draining          bool
+	ejectedUntil      time.Time
+	createdAt         time.Time
+	warming           bool
 }
 
 type BackendStats struct {
@@ -28,6 +47,9 @@ type BackendStats struct {
 
 func (b *Backend) SetAlive(alive bool) {
 	b.mux.Lock()
+	if alive && !b.Alive {
+		b.recoveredAt = time.Now()
+	}
 	b.Alive = alive
 	b.mux.Unlock()
 }
@@ -38,39 +60,290 @@ func (b *Backend) IsAlive() bool {
 	return b.Alive && b.CircuitBreaker.Allow()
 }
 
+func (b *Backend) SetDraining(draining bool) {
+	b.mux.Lock()
+	b.draining = draining
+	b.mux.Unlock()
+}
+
+func (b *Backend) IsDraining() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.draining
+}
+
+func (b *Backend) Eject(d time.Duration) {
+	b.mux.Lock()
+	b.ejectedUntil = time.Now().Add(d)
+	b.mux.Unlock()
+}
+
+func (b *Backend) IsEjected() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return time.Now().Before(b.ejectedUntil)
+}
+
+func (b *Backend) SetWarming(warming bool) {
+	b.mux.Lock()
+	b.warming = warming
+	b.mux.Unlock()
+}
+
+func (b *Backend) IsWarming() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.warming
+}
+
+func (b *Backend) InInitialDelay() bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.warming && time.Since(b.createdAt) < b.InitialDelay
+}
+
+func (b *Backend) IsSaturated() bool {
+	return b.MaxConnections > 0 && atomic.LoadInt64(&b.ActiveConnections) >= b.MaxConnections
+}
+
+func (b *Backend) SlowStartFactor() float64 {
+	if b.SlowStart <= 0 {
+		return 1
+	}
+
+	b.mux.RLock()
+	recovered := b.recoveredAt
+	if b.ejectedUntil.After(recovered) && time.Now().After(b.ejectedUntil) {
+		recovered = b.ejectedUntil
+	}
+	b.mux.RUnlock()
+
+	if cbRecovered := b.CircuitBreaker.RecoveredAt(); cbRecovered.After(recovered) {
+		recovered = cbRecovered
+	}
+	if recovered.IsZero() {
+		return 1
+	}
+
+	elapsed := time.Since(recovered)
+	if elapsed >= b.SlowStart {
+		return 1
+	}
+	return float64(elapsed) / float64(b.SlowStart)
+}
+
 type ServerPool struct {
 	Backends []*Backend
+	Zone     string
 	current  uint64
+	mu       sync.RWMutex
+}
+
+func (p *ServerPool) Snapshot() []*Backend {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.Backends
+}
+
+func (p *ServerPool) AddBackend(b *Backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	backends := make([]*Backend, 0, len(p.Backends)+1)
+	backends = append(backends, p.Backends...)
+	p.Backends = append(backends, b)
+}
+
+func (p *ServerPool) RemoveBackend(u *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	backends := make([]*Backend, 0, len(p.Backends))
+	for _, b := range p.Backends {
+		if b.URL.String() != u.String() {
+			backends = append(backends, b)
+		}
+	}
+	p.Backends = backends
+}
+
+func (p *ServerPool) SetWeight(u *url.URL, weight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.Backends {
+		if b.URL.String() == u.String() {
+			b.SetWeight(weight)
+			break
+		}
+	}
+}
+
+func (b *Backend) Weight() int {
+	return int(atomic.LoadInt64(&b.weight))
+}
+
+func (b *Backend) SetWeight(weight int) {
+	atomic.StoreInt64(&b.weight, int64(weight))
+}
+
+func (b *Backend) IsStandby() bool {
+	return b.Weight() == 0
+}
+
+func (b *Backend) isAvailable() bool {
+	return !b.IsDraining() && !b.IsSaturated() && !b.IsEjected() && !b.IsWarming() && b.IsAlive()
+}
+
+func (p *ServerPool) isLocal(b *Backend) bool {
+	return p.Zone == "" || b.Zone == "" || b.Zone == p.Zone
+}
+
+func (p *ServerPool) IsSelectable(b *Backend) bool {
+	if !b.isAvailable() {
+		return false
+	}
+
+	if b.IsStandby() {
+		for _, other := range p.Snapshot() {
+			if !other.IsStandby() && other.isAvailable() {
+				return false
+			}
+		}
+	}
+
+	if !p.isLocal(b) {
+		for _, other := range p.Snapshot() {
+			if other != b && p.isLocal(other) && other.isAvailable() {
+				return false
+			}
+		}
+	}
+
+	factor := b.SlowStartFactor()
+	if factor >= 1 || rand.Float64() < factor {
+		return true
+	}
+
+	for _, other := range p.Snapshot() {
+		if other != b && other.isAvailable() && other.SlowStartFactor() >= 1 {
+			return false
+		}
+	}
+	return true
 }
 
 type LoadBalancer interface {
 	NextBackend(r *http.Request) *Backend
 	AddBackend(b *Backend)
+	RemoveBackend(u *url.URL)
+	SetWeight(u *url.URL, weight int)
 	UpdateBackendStatus(u *url.URL, alive bool)
+	Drain(u *url.URL)
 	GetBackends() []*Backend
 	OnRequestCompletion(u *url.URL, duration time.Duration, err error)
 }
 
-func NewBackend(u *url.URL, weight int, cbThreshold int, cbTimeout time.Duration) *Backend {
+type DirectorOptions struct {
+	Scheme      string
+	PathPrefix  string
+	StripPrefix string
+	HostHeader  string
+}
+
+func (b *Backend) SetDirector(opts DirectorOptions) {
+	base := b.ReverseProxy.Director
+	b.ReverseProxy.Director = func(req *http.Request) {
+		if opts.StripPrefix != "" && strings.HasPrefix(req.URL.Path, opts.StripPrefix) {
+			req.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, opts.StripPrefix), "/")
+			req.URL.RawPath = ""
+		}
+
+		base(req)
+
+		if opts.Scheme != "" {
+			req.URL.Scheme = opts.Scheme
+		}
+		if opts.PathPrefix != "" {
+			req.URL.Path = strings.TrimSuffix(opts.PathPrefix, "/") + "/" + strings.TrimPrefix(req.URL.Path, "/")
+			req.URL.RawPath = ""
+		}
+		switch opts.HostHeader {
+		case "":
+		case "backend":
+			req.Host = req.URL.Host
+		default:
+			req.Host = opts.HostHeader
+		}
+	}
+}
+
+func (b *Backend) AddRequestHook(hook func(*http.Request)) {
+	base := b.ReverseProxy.Director
+	b.ReverseProxy.Director = func(req *http.Request) {
+		base(req)
+		hook(req)
+	}
+}
+
+type RequestCompleter interface {
+	OnRequestCompletionFor(r *http.Request, u *url.URL, duration time.Duration, status int, err error)
+}
+
+func CompleteRequest(lb LoadBalancer, r *http.Request, u *url.URL, duration time.Duration, status int, err error) {
+	if rc, ok := lb.(RequestCompleter); ok {
+		rc.OnRequestCompletionFor(r, u, duration, status, err)
+		return
+	}
+	lb.OnRequestCompletion(u, duration, err)
+}
+
+type TransportOptions struct {
+	DialTimeout         time.Duration
+	ResponseTimeout     time.Duration
+	KeepAlive           time.Duration
+	IdleConnTimeout     time.Duration
+	MaxIdleConnsPerHost int
+	DisableKeepAlives   bool
+}
+
+func NewBackend(u *url.URL, weight int, cbThreshold int, cbTimeout time.Duration, opts TransportOptions) *Backend {
 	b := &Backend{
 		URL:            u,
 		Alive:          true,
-		Weight:         weight,
+		weight:         int64(weight),
 		CircuitBreaker: features.NewCircuitBreaker(cbThreshold, cbTimeout),
+		createdAt:      time.Now(),
 	}
 
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = 90 * time.Second
+	}
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = 10
+	}
 	transport := &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
-		DisableKeepAlives:   false,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		ResponseHeaderTimeout: opts.ResponseTimeout,
+		DisableKeepAlives:     opts.DisableKeepAlives,
+		DialContext: negativeCachingDialer(&net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: opts.KeepAlive,
+		}),
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(u)
 	proxy.Transport = transport
 
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, ErrResponseTooLarge) {
+			log.Printf("Response from %s exceeds the maximum response size", u)
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("Bad Gateway"))
+			return
+		}
 		b.CircuitBreaker.RecordFailure()
+		kind := features.RecordUpstreamError(u.String(), err)
+		log.Printf("Upstream error from %s (%s): %v", u, kind, err)
 		w.WriteHeader(http.StatusBadGateway)
 		w.Write([]byte("Bad Gateway"))
 	}
@@ -81,7 +354,7 @@ func NewBackend(u *url.URL, weight int, cbThreshold int, cbTimeout time.Duration
 		} else {
 			b.CircuitBreaker.RecordSuccess()
 		}
-		return nil
+		return limitResponse(resp)
 	}
 
 	b.ReverseProxy = proxy
@@ -0,0 +1,231 @@
+package balancertest
+
+import (
+	"advanced-lb/balancer"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ConformanceOptions struct {
+	Backends    int
+	Requests    int
+	Concurrency int
+	Tolerance   float64
+}
+
+func NewTestPool(n int) *balancer.ServerPool {
+	pool := &balancer.ServerPool{Backends: make([]*balancer.Backend, 0, n)}
+	for i := 0; i < n; i++ {
+		u, _ := url.Parse(fmt.Sprintf("http://backend-%d.test", i))
+		pool.Backends = append(pool.Backends, balancer.NewBackend(u, 1, 3, 10*time.Second, balancer.TransportOptions{}))
+	}
+	return pool
+}
+
+func testRequest(i int) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/item/%d", i), nil)
+	r.RemoteAddr = fmt.Sprintf("10.%d.%d.%d:40000", (i>>16)&0xff, (i>>8)&0xff, i&0xff)
+	return r
+}
+
+func RunConformance(t *testing.T, newLB func(*balancer.ServerPool) balancer.LoadBalancer, opts ConformanceOptions) {
+	if opts.Backends <= 1 {
+		opts.Backends = 3
+	}
+	if opts.Requests <= 0 {
+		opts.Requests = 3000
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+
+	if opts.Tolerance > 0 {
+		t.Run("Distribution", func(t *testing.T) {
+			CheckDistribution(t, newLB, opts.Backends, opts.Requests, opts.Tolerance)
+		})
+	}
+	t.Run("DeadBackendAvoidance", func(t *testing.T) {
+		CheckDeadBackendAvoidance(t, newLB, opts.Backends, opts.Requests)
+	})
+	t.Run("DrainedBackendAvoidance", func(t *testing.T) {
+		CheckDrainedBackendAvoidance(t, newLB, opts.Backends, opts.Requests)
+	})
+	t.Run("RemovedBackendAvoidance", func(t *testing.T) {
+		CheckRemovedBackendAvoidance(t, newLB, opts.Backends, opts.Requests)
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		CheckConcurrency(t, newLB, opts.Backends, opts.Requests, opts.Concurrency)
+	})
+}
+
+func CheckDistribution(t testing.TB, newLB func(*balancer.ServerPool) balancer.LoadBalancer, backends, requests int, tolerance float64) {
+	t.Helper()
+	pool := NewTestPool(backends)
+	lb := newLB(pool)
+
+	counts := make(map[string]int)
+	for i := 0; i < requests; i++ {
+		b := lb.NextBackend(testRequest(i))
+		if b == nil {
+			t.Fatalf("request %d: no backend selected with all backends alive", i)
+		}
+		counts[b.URL.String()]++
+		lb.OnRequestCompletion(b.URL, 10*time.Millisecond, nil)
+	}
+
+	totalWeight := 0
+	for _, b := range pool.Backends {
+		totalWeight += b.Weight()
+	}
+	for _, b := range pool.Backends {
+		expected := float64(b.Weight()) / float64(totalWeight)
+		actual := float64(counts[b.URL.String()]) / float64(requests)
+		if math.Abs(actual-expected) > tolerance {
+			t.Errorf("backend %s received %.3f of traffic, expected %.3f ± %.3f", b.URL, actual, expected, tolerance)
+		}
+	}
+}
+
+func CheckDeadBackendAvoidance(t testing.TB, newLB func(*balancer.ServerPool) balancer.LoadBalancer, backends, requests int) {
+	t.Helper()
+	pool := NewTestPool(backends)
+	lb := newLB(pool)
+
+	dead := pool.Backends[0]
+	lb.UpdateBackendStatus(dead.URL, false)
+
+	for i := 0; i < requests; i++ {
+		b := lb.NextBackend(testRequest(i))
+		if b == nil {
+			t.Fatalf("request %d: no backend selected with %d backends alive", i, backends-1)
+		}
+		if b == dead {
+			t.Fatalf("request %d: dead backend %s was selected", i, dead.URL)
+		}
+		lb.OnRequestCompletion(b.URL, 10*time.Millisecond, nil)
+	}
+}
+
+func CheckDrainedBackendAvoidance(t testing.TB, newLB func(*balancer.ServerPool) balancer.LoadBalancer, backends, requests int) {
+	t.Helper()
+	pool := NewTestPool(backends)
+	lb := newLB(pool)
+
+	drained := pool.Backends[0]
+	lb.Drain(drained.URL)
+
+	for i := 0; i < requests; i++ {
+		b := lb.NextBackend(testRequest(i))
+		if b == nil {
+			t.Fatalf("request %d: no backend selected with %d backends available", i, backends-1)
+		}
+		if b == drained {
+			t.Fatalf("request %d: draining backend %s was selected", i, drained.URL)
+		}
+		lb.OnRequestCompletion(b.URL, 10*time.Millisecond, nil)
+	}
+}
+
+func CheckRemovedBackendAvoidance(t testing.TB, newLB func(*balancer.ServerPool) balancer.LoadBalancer, backends, requests int) {
+	t.Helper()
+	pool := NewTestPool(backends)
+	lb := newLB(pool)
+
+	removed := pool.Backends[0]
+	lb.RemoveBackend(removed.URL)
+	if n := len(lb.GetBackends()); n != backends-1 {
+		t.Fatalf("expected %d backends after removal, got %d", backends-1, n)
+	}
+
+	for i := 0; i < requests; i++ {
+		b := lb.NextBackend(testRequest(i))
+		if b == nil {
+			t.Fatalf("request %d: no backend selected with %d backends remaining", i, backends-1)
+		}
+		if b.URL.String() == removed.URL.String() {
+			t.Fatalf("request %d: removed backend %s was selected", i, removed.URL)
+		}
+		lb.OnRequestCompletion(b.URL, 10*time.Millisecond, nil)
+	}
+}
+
+func CheckConcurrency(t testing.TB, newLB func(*balancer.ServerPool) balancer.LoadBalancer, backends, requests, concurrency int) {
+	t.Helper()
+	pool := NewTestPool(backends)
+	lb := newLB(pool)
+
+	var wg sync.WaitGroup
+	for g := 0; g < concurrency; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < requests/concurrency; i++ {
+				b := lb.NextBackend(testRequest(g*requests + i))
+				if b == nil {
+					continue
+				}
+				var err error
+				if i%10 == 0 {
+					err = fmt.Errorf("simulated failure")
+				}
+				lb.OnRequestCompletion(b.URL, time.Duration(i%50)*time.Millisecond, err)
+			}
+		}(g)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < requests/concurrency; i++ {
+			b := pool.Snapshot()[i%backends]
+			lb.UpdateBackendStatus(b.URL, i%2 == 0)
+		}
+	}()
+
+	extraURL, _ := url.Parse("http://backend-extra.test")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < requests/concurrency; i++ {
+			if i%2 == 0 {
+				lb.AddBackend(balancer.NewBackend(extraURL, 1, 3, 10*time.Second, balancer.TransportOptions{}))
+			} else {
+				lb.RemoveBackend(extraURL)
+			}
+		}
+	}()
+
+	wg.Wait()
+	for _, b := range lb.GetBackends() {
+		lb.UpdateBackendStatus(b.URL, true)
+	}
+	if lb.NextBackend(testRequest(0)) == nil {
+		t.Fatalf("no backend selected after concurrent load with all backends alive")
+	}
+}
+
+func BenchmarkNextBackend(b *testing.B, newLB func(*balancer.ServerPool) balancer.LoadBalancer, backends int) {
+	pool := NewTestPool(backends)
+	lb := newLB(pool)
+	reqs := make([]*http.Request, 1024)
+	for i := range reqs {
+		reqs[i] = testRequest(i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if peer := lb.NextBackend(reqs[i%len(reqs)]); peer != nil {
+				lb.OnRequestCompletion(peer.URL, time.Millisecond, nil)
+			}
+			i++
+		}
+	})
+}
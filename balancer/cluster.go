@@ -0,0 +1,258 @@
+package balancer
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultOverprovisioning = 1.4
+
+type Cluster struct {
+	Name   string
+	Weight int
+}
+
+type clusterMember struct {
+	name   string
+	weight int
+	pool   *ServerPool
+	lb     LoadBalancer
+}
+
+type ClusterShare struct {
+	Weight    int     `json:"weight"`
+	Healthy   int     `json:"healthy"`
+	Total     int     `json:"total"`
+	SharePct  float64 `json:"share_pct"`
+	Failover  bool    `json:"failover"`
+	effective float64
+}
+
+type ClusterBalancer struct {
+	mu            sync.RWMutex
+	members       []*clusterMember
+	zone          string
+	overprovision float64
+	newLB         func(pool *ServerPool) LoadBalancer
+}
+
+func NewClusterBalancer(clusters []Cluster, backends []*Backend, zone string, overprovision float64, newLB func(pool *ServerPool) LoadBalancer) *ClusterBalancer {
+	if overprovision <= 0 {
+		overprovision = defaultOverprovisioning
+	}
+	cb := &ClusterBalancer{zone: zone, overprovision: overprovision, newLB: newLB}
+	byCluster := make(map[string][]*Backend)
+	for _, b := range backends {
+		byCluster[b.Cluster] = append(byCluster[b.Cluster], b)
+	}
+	for _, c := range clusters {
+		cb.members = append(cb.members, cb.newMember(c.Name, c.Weight, byCluster[c.Name]))
+		delete(byCluster, c.Name)
+	}
+	for name, members := range byCluster {
+		cb.members = append(cb.members, cb.newMember(name, 1, members))
+	}
+	return cb
+}
+
+func (cb *ClusterBalancer) newMember(name string, weight int, backends []*Backend) *clusterMember {
+	pool := &ServerPool{Backends: backends, Zone: cb.zone}
+	return &clusterMember{name: name, weight: weight, pool: pool, lb: cb.newLB(pool)}
+}
+
+func (cb *ClusterBalancer) member(name string) *clusterMember {
+	for _, m := range cb.members {
+		if m.name == name {
+			return m
+		}
+	}
+	m := cb.newMember(name, 1, nil)
+	cb.members = append(cb.members, m)
+	return m
+}
+
+func (cb *ClusterBalancer) memberFor(u *url.URL) *clusterMember {
+	for _, m := range cb.members {
+		for _, b := range m.pool.Snapshot() {
+			if b.URL.String() == u.String() {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+func healthy(b *Backend) bool {
+	return b.IsAlive() && !b.IsDraining() && !b.IsEjected() && !b.IsWarming()
+}
+
+func (cb *ClusterBalancer) shares() ([]*clusterMember, map[string]*ClusterShare) {
+	cb.mu.RLock()
+	members := append([]*clusterMember{}, cb.members...)
+	cb.mu.RUnlock()
+
+	out := make(map[string]*ClusterShare, len(members))
+	var total float64
+	for _, m := range members {
+		s := &ClusterShare{Weight: m.weight}
+		for _, b := range m.pool.Snapshot() {
+			s.Total++
+			if healthy(b) {
+				s.Healthy++
+			}
+		}
+		if s.Total > 0 {
+			ratio := float64(s.Healthy) / float64(s.Total)
+			s.effective = float64(m.weight) * math.Min(1, cb.overprovision*ratio)
+		}
+		total += s.effective
+		out[m.name] = s
+	}
+
+	if total == 0 {
+		for _, m := range members {
+			if s := out[m.name]; s.Healthy > 0 {
+				s.effective = float64(s.Healthy)
+				total += s.effective
+			}
+		}
+	}
+	for _, m := range members {
+		s := out[m.name]
+		if total > 0 {
+			s.SharePct = math.Round(s.effective/total*1000) / 10
+		}
+		if m.weight > 0 && s.effective < float64(m.weight) {
+			s.Failover = true
+		}
+	}
+	return members, out
+}
+
+func (cb *ClusterBalancer) NextBackend(r *http.Request) *Backend {
+	members, shares := cb.shares()
+	sort.SliceStable(members, func(i, j int) bool {
+		return shares[members[i].name].effective > shares[members[j].name].effective
+	})
+
+	var total float64
+	for _, m := range members {
+		total += shares[m.name].effective
+	}
+	if total > 0 {
+		pick := rand.Float64() * total
+		for i, m := range members {
+			pick -= shares[m.name].effective
+			if pick < 0 {
+				members[0], members[i] = members[i], members[0]
+				break
+			}
+		}
+	}
+
+	for _, m := range members {
+		if b := m.lb.NextBackend(r); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+func (cb *ClusterBalancer) AddBackend(b *Backend) {
+	cb.mu.Lock()
+	m := cb.member(b.Cluster)
+	cb.mu.Unlock()
+	m.lb.AddBackend(b)
+}
+
+func (cb *ClusterBalancer) RemoveBackend(u *url.URL) {
+	cb.mu.RLock()
+	m := cb.memberFor(u)
+	cb.mu.RUnlock()
+	if m != nil {
+		m.lb.RemoveBackend(u)
+	}
+}
+
+func (cb *ClusterBalancer) SetWeight(u *url.URL, weight int) {
+	cb.mu.RLock()
+	m := cb.memberFor(u)
+	cb.mu.RUnlock()
+	if m != nil {
+		m.lb.SetWeight(u, weight)
+	}
+}
+
+func (cb *ClusterBalancer) UpdateBackendStatus(u *url.URL, alive bool) {
+	cb.mu.RLock()
+	m := cb.memberFor(u)
+	cb.mu.RUnlock()
+	if m != nil {
+		m.lb.UpdateBackendStatus(u, alive)
+	}
+}
+
+func (cb *ClusterBalancer) Drain(u *url.URL) {
+	cb.mu.RLock()
+	m := cb.memberFor(u)
+	cb.mu.RUnlock()
+	if m != nil {
+		m.lb.Drain(u)
+	}
+}
+
+func (cb *ClusterBalancer) GetBackends() []*Backend {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	var backends []*Backend
+	for _, m := range cb.members {
+		backends = append(backends, m.lb.GetBackends()...)
+	}
+	return backends
+}
+
+func (cb *ClusterBalancer) OnRequestCompletion(u *url.URL, duration time.Duration, err error) {
+	cb.mu.RLock()
+	m := cb.memberFor(u)
+	cb.mu.RUnlock()
+	if m != nil {
+		m.lb.OnRequestCompletion(u, duration, err)
+	}
+}
+
+func (cb *ClusterBalancer) OnRequestCompletionFor(r *http.Request, u *url.URL, duration time.Duration, status int, err error) {
+	cb.mu.RLock()
+	m := cb.memberFor(u)
+	cb.mu.RUnlock()
+	if m != nil {
+		CompleteRequest(m.lb, r, u, duration, status, err)
+	}
+}
+
+func (cb *ClusterBalancer) Snapshot() map[string]*ClusterShare {
+	_, shares := cb.shares()
+	return shares
+}
+
+func (cb *ClusterBalancer) Learners() map[string]LoadBalancer {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	learners := make(map[string]LoadBalancer, len(cb.members))
+	for _, m := range cb.members {
+		learners[m.name] = m.lb
+	}
+	return learners
+}
+
+func AsClusterBalancer(lb LoadBalancer) (*ClusterBalancer, bool) {
+	if g, ok := lb.(*Guarded); ok {
+		lb = g.Unwrap()
+	}
+	cb, ok := lb.(*ClusterBalancer)
+	return cb, ok
+}
@@ -0,0 +1,28 @@
+package balancer
+
+import "testing"
+
+func TestQLearnersWalksClusters(t *testing.T) {
+	pool := testPool(4)
+	for i, b := range pool.Backends {
+		b.Cluster = []string{"eu", "us"}[i%2]
+	}
+	build := func(p *ServerPool) LoadBalancer {
+		return NewQLearning(p, 0.1, 0.3, 0.9)
+	}
+	clusters := []Cluster{{Name: "eu", Weight: 1}, {Name: "us", Weight: 1}}
+	lb := NewGuarded(NewClusterBalancer(clusters, pool.Backends, "", 0, build), "q-learning", "", 0)
+
+	learners := QLearners(lb)
+	if len(learners) != 2 || learners["eu"] == nil || learners["us"] == nil {
+		t.Fatalf("learners = %v, want one per cluster", learners)
+	}
+	if learners["eu"] == learners["us"] {
+		t.Fatal("clusters share a learner")
+	}
+
+	single := QLearners(NewGuarded(build(testPool(2)), "q-learning", "", 0))
+	if len(single) != 1 || single[""] == nil {
+		t.Fatalf("learners = %v, want the unclustered learner under \"\"", single)
+	}
+}
@@ -0,0 +1,42 @@
+package balancer_test
+
+import (
+	"advanced-lb/balancer"
+	"advanced-lb/balancer/balancertest"
+	"testing"
+)
+
+var distributionTolerance = map[string]float64{
+	"round-robin":          0.01,
+	"weighted-round-robin": 0.01,
+	"ip-hash":              0.1,
+	"uri-hash":             0.1,
+	"hash":                 0.1,
+}
+
+func TestConformance(t *testing.T) {
+	for _, name := range balancer.Algorithms() {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			balancertest.RunConformance(t, func(pool *balancer.ServerPool) balancer.LoadBalancer {
+				lb, err := balancer.New(name, pool, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return lb
+			}, balancertest.ConformanceOptions{Tolerance: distributionTolerance[name]})
+		})
+	}
+}
+
+func BenchmarkNextBackend(b *testing.B) {
+	for _, name := range balancer.Algorithms() {
+		name := name
+		b.Run(name, func(b *testing.B) {
+			balancertest.BenchmarkNextBackend(b, func(pool *balancer.ServerPool) balancer.LoadBalancer {
+				lb, _ := balancer.New(name, pool, nil)
+				return lb
+			}, 8)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package balancer
+
+import (
+	"advanced-lb/features"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const decisionCooldown = 10 * time.Second
+
+type Guarded struct {
+	LoadBalancer
+	name          string
+	zone          string
+	budget        time.Duration
+	fallbackUntil int64
+	current       uint64
+}
+
+func NewGuarded(inner LoadBalancer, name, zone string, budget time.Duration) *Guarded {
+	return &Guarded{
+		LoadBalancer: inner,
+		name:         name,
+		zone:         zone,
+		budget:       budget,
+	}
+}
+
+func (g *Guarded) NextBackend(r *http.Request) *Backend {
+	if until := atomic.LoadInt64(&g.fallbackUntil); until != 0 {
+		if time.Now().UnixNano() < until {
+			features.RecordDecisionFallback(g.name)
+			return g.roundRobin()
+		}
+		if atomic.CompareAndSwapInt64(&g.fallbackUntil, until, 0) {
+			log.Printf("Decision budget cooldown over, resuming %s", g.name)
+		}
+	}
+
+	start := time.Now()
+	b := g.LoadBalancer.NextBackend(r)
+	elapsed := time.Since(start)
+	features.RecordDecision(g.name, elapsed)
+
+	if g.budget > 0 && elapsed > g.budget {
+		deadline := time.Now().Add(decisionCooldown).UnixNano()
+		if atomic.CompareAndSwapInt64(&g.fallbackUntil, 0, deadline) {
+			log.Printf("Algorithm %s took %v to decide (budget %v), falling back to round-robin for %v",
+				g.name, elapsed, g.budget, decisionCooldown)
+		}
+	}
+	return b
+}
+
+func (g *Guarded) roundRobin() *Backend {
+	pool := &ServerPool{Backends: g.LoadBalancer.GetBackends(), Zone: g.zone}
+	backends := pool.Snapshot()
+	if len(backends) == 0 {
+		return nil
+	}
+	next := atomic.AddUint64(&g.current, 1)
+	for i := 0; i < len(backends); i++ {
+		b := backends[(int(next)+i)%len(backends)]
+		if pool.IsSelectable(b) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (g *Guarded) OnRequestCompletionFor(r *http.Request, u *url.URL, duration time.Duration, status int, err error) {
+	CompleteRequest(g.LoadBalancer, r, u, duration, status, err)
+}
+
+func (g *Guarded) Unwrap() LoadBalancer {
+	return g.LoadBalancer
+}
+
+func AsQLearning(lb LoadBalancer) (*QLearning, bool) {
+	if g, ok := lb.(*Guarded); ok {
+		lb = g.Unwrap()
+	}
+	ql, ok := lb.(*QLearning)
+	return ql, ok
+}
+
+func QLearners(lb LoadBalancer) map[string]*QLearning {
+	learners := make(map[string]*QLearning)
+	if ql, ok := AsQLearning(lb); ok {
+		learners[""] = ql
+	} else if cb, ok := AsClusterBalancer(lb); ok {
+		for name, member := range cb.Learners() {
+			if ql, ok := AsQLearning(member); ok {
+				learners[name] = ql
+			}
+		}
+	}
+	return learners
+}
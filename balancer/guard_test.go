@@ -0,0 +1,25 @@
+package balancer
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGuardedFallbackSkipsUnselectableBackends(t *testing.T) {
+	pool := testPool(3)
+	local, standby, remote := pool.Backends[0], pool.Backends[1], pool.Backends[2]
+	local.Zone, standby.Zone, remote.Zone = "eu", "eu", "us"
+	standby.SetWeight(0)
+	pool.Zone = "eu"
+
+	g := NewGuarded(NewRoundRobin(pool), "round-robin", "eu", time.Nanosecond)
+	g.fallbackUntil = time.Now().Add(time.Hour).UnixNano()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < 30; i++ {
+		if b := g.NextBackend(r); b != local {
+			t.Fatalf("fallback picked %v, want only the local non-standby backend", b.URL)
+		}
+	}
+}
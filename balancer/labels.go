@@ -0,0 +1,23 @@
+package balancer
+
+func (b *Backend) MatchLabels(selector map[string]string) bool {
+	for k, v := range selector {
+		if b.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func SelectByLabels(backends []*Backend, selector map[string]string) []*Backend {
+	if len(selector) == 0 {
+		return backends
+	}
+	selected := make([]*Backend, 0, len(backends))
+	for _, b := range backends {
+		if b.MatchLabels(selector) {
+			selected = append(selected, b)
+		}
+	}
+	return selected
+}
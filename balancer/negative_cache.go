@@ -0,0 +1,95 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var negativeCache = &dialFailures{entries: make(map[string]dialFailure)}
+
+type dialFailure struct {
+	err     error
+	expires time.Time
+}
+
+type dialFailures struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]dialFailure
+}
+
+func SetNegativeCacheTTL(ttl time.Duration) {
+	negativeCache.mu.Lock()
+	negativeCache.ttl = ttl
+	if ttl <= 0 {
+		negativeCache.entries = make(map[string]dialFailure)
+	}
+	negativeCache.mu.Unlock()
+}
+
+func ClearDialFailure(u *url.URL) {
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	negativeCache.mu.Lock()
+	delete(negativeCache.entries, addr)
+	negativeCache.mu.Unlock()
+}
+
+func (c *dialFailures) lookup(addr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.entries[addr]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(f.expires) {
+		delete(c.entries, addr)
+		return nil
+	}
+	return f.err
+}
+
+func (c *dialFailures) record(addr string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 {
+		return
+	}
+	c.entries[addr] = dialFailure{err: err, expires: time.Now().Add(c.ttl)}
+}
+
+func isHardDialFailure(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+func negativeCachingDialer(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if err := negativeCache.lookup(addr); err != nil {
+			return nil, fmt.Errorf("recent dial failure (negative cache): %w", err)
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil && ctx.Err() == nil && isHardDialFailure(err) {
+			negativeCache.record(addr, err)
+		}
+		return conn, err
+	}
+}
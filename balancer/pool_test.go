@@ -0,0 +1,118 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolSnapshotIsCopyOnWrite(t *testing.T) {
+	pool := testPool(3)
+	snap := pool.Snapshot()
+	first := snap[0]
+
+	u, _ := url.Parse("http://backend-new.test")
+	pool.AddBackend(NewBackend(u, 1, 3, 10*time.Second, TransportOptions{}))
+	pool.RemoveBackend(first.URL)
+
+	if len(snap) != 3 || snap[0] != first {
+		t.Fatalf("snapshot changed after add/remove: %v", snap)
+	}
+	if got := len(pool.Snapshot()); got != 3 {
+		t.Fatalf("pool has %d backends, want 3", got)
+	}
+}
+
+func TestWRRFollowsSetWeight(t *testing.T) {
+	pool := testPool(2)
+	wrr := NewWeightedRoundRobin(pool)
+	wrr.SetWeight(pool.Backends[1].URL, 3)
+
+	counts := make(map[*Backend]int)
+	r := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < 400; i++ {
+		counts[wrr.NextBackend(r)]++
+	}
+	if counts[pool.Backends[0]] != 100 || counts[pool.Backends[1]] != 300 {
+		t.Fatalf("distribution = %d/%d, want 100/300", counts[pool.Backends[0]], counts[pool.Backends[1]])
+	}
+}
+
+func TestSetWeightWhileServing(t *testing.T) {
+	pool := testPool(2)
+	wrr := NewWeightedRoundRobin(pool)
+	stable := pool.Backends[0]
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/", nil)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if wrr.NextBackend(r) == nil {
+					t.Error("NextBackend returned nil while a backend was available")
+					return
+				}
+				for _, b := range wrr.GetBackends() {
+					_ = b.Weight()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		wrr.SetWeight(stable.URL, 1+i%4)
+		stable.SetWeight(1 + i%2)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestWRRAddRemoveWhileServing(t *testing.T) {
+	pool := testPool(2)
+	wrr := NewWeightedRoundRobin(pool)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/", nil)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if wrr.NextBackend(r) == nil {
+					t.Error("NextBackend returned nil while a backend was available")
+					return
+				}
+				wrr.GetBackends()
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		u, _ := url.Parse(fmt.Sprintf("http://extra-%d.test", i%5))
+		wrr.AddBackend(NewBackend(u, 1+i%3, 3, 10*time.Second, TransportOptions{}))
+		wrr.RemoveBackend(u)
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := len(wrr.GetBackends()); got != 2 {
+		t.Fatalf("pool has %d backends after mutation, want 2", got)
+	}
+}
@@ -1,12 +1,15 @@
 package balancer
 
 import (
-	"encoding/json"
+	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,30 +24,386 @@ type QLearning struct {
 	maxQValue  float64
 	lastQDelta float64
 	cachedMaxQ float64
+	state      []string
+	pathDepth  int
+	timeBucket int
+	reward     RewardConfig
+	doubleQ    bool
+	qTableB    sync.Map
+	sarsa      bool
+	pending    map[string][]sarsaTransition
+	sarsaSwept time.Time
+	warmup     int64
+	served     int64
+	loadWeight float64
+	errWeight  float64
+	errorRates sync.Map
+	initialEps float64
+	window     [convergenceWindow][2]float64
+	windowLen  int
+	windowPos  int
+	retention  retention
+}
+
+const convergenceWindow = 100
+
+const (
+	sarsaMaxPending = 64
+	sarsaPendingTTL = time.Minute
+)
+
+type sarsaTransition struct {
+	key    string
+	reward float64
+	at     time.Time
+}
+
+type ConvergenceStats struct {
+	Epsilon       float64          `json:"epsilon"`
+	MeanAbsDelta  float64          `json:"mean_abs_q_delta"`
+	RewardAverage float64          `json:"reward_moving_average"`
+	Visits        map[string]int64 `json:"visits"`
+	TopShare      float64          `json:"top_backend_share"`
+	Collapsed     bool             `json:"collapsed"`
+}
+
+type RewardConfig struct {
+	Base              float64
+	LatencyWeight     float64
+	ServerError       float64
+	ClientErrorWeight float64
+	ConnectionWeight  float64
+	Floor             float64
+}
+
+func DefaultRewardConfig() RewardConfig {
+	return RewardConfig{
+		Base:          100.0,
+		LatencyWeight: 0.1,
+		ServerError:   -50.0,
+		Floor:         -50.0,
+	}
 }
 
 func NewQLearning(pool *ServerPool, epsilon, alpha, gamma float64) *QLearning {
 	return &QLearning{
-		pool:    pool,
-		epsilon: epsilon,
-		alpha:   alpha,
-		gamma:   gamma,
+		pool:       pool,
+		epsilon:    epsilon,
+		initialEps: epsilon,
+		alpha:      alpha,
+		gamma:      gamma,
+		reward:     DefaultRewardConfig(),
+		pending:    make(map[string][]sarsaTransition),
 	}
 }
 
-func (ql *QLearning) NextBackend(r *http.Request) *Backend {
+func clearMap(m *sync.Map, match func(key string) bool) {
+	m.Range(func(key, _ interface{}) bool {
+		if match(key.(string)) {
+			m.Delete(key)
+		}
+		return true
+	})
+}
+
+func (ql *QLearning) Reset() {
+	ql.mux.Lock()
+	defer ql.mux.Unlock()
+
+	all := func(string) bool { return true }
+	clearMap(&ql.qTable, all)
+	clearMap(&ql.qTableB, all)
+	clearMap(&ql.counts, all)
+	clearMap(&ql.errorRates, all)
+	ql.pending = make(map[string][]sarsaTransition)
+	ql.epsilon = ql.initialEps
+	ql.maxQValue = 0
+	ql.lastQDelta = 0
+	ql.cachedMaxQ = 0
+	ql.windowLen = 0
+	ql.windowPos = 0
+	atomic.StoreInt64(&ql.served, 0)
+}
+
+func (ql *QLearning) Convergence() ConvergenceStats {
 	ql.mux.RLock()
 	defer ql.mux.RUnlock()
 
-	backends := ql.pool.Backends
+	stats := ConvergenceStats{
+		Epsilon: ql.epsilon,
+		Visits:  make(map[string]int64),
+	}
+	for i := 0; i < ql.windowLen; i++ {
+		stats.MeanAbsDelta += ql.window[i][0]
+		stats.RewardAverage += ql.window[i][1]
+	}
+	if ql.windowLen > 0 {
+		stats.MeanAbsDelta /= float64(ql.windowLen)
+		stats.RewardAverage /= float64(ql.windowLen)
+	}
+
+	var total, top int64
+	ql.counts.Range(func(key, value interface{}) bool {
+		backend := key.(string)
+		if i := strings.LastIndex(backend, "|"); i >= 0 {
+			backend = backend[i+1:]
+		}
+		stats.Visits[backend] += value.(int64)
+		total += value.(int64)
+		return true
+	})
+	for _, v := range stats.Visits {
+		if v > top {
+			top = v
+		}
+	}
+	if total > 0 {
+		stats.TopShare = float64(top) / float64(total)
+	}
+	stats.Collapsed = len(ql.pool.Snapshot()) > 1 && total >= convergenceWindow && stats.TopShare > 0.95
+	return stats
+}
+
+func (ql *QLearning) Forget(u *url.URL) {
+	ql.mux.Lock()
+	defer ql.mux.Unlock()
+
+	ql.forget(u.String())
+}
+
+func (ql *QLearning) forget(target string) {
+	match := func(key string) bool {
+		return key == target || strings.HasSuffix(key, "|"+target)
+	}
+	clearMap(&ql.qTable, match)
+	clearMap(&ql.qTableB, match)
+	clearMap(&ql.counts, match)
+	clearMap(&ql.errorRates, match)
+	for state, transitions := range ql.pending {
+		kept := transitions[:0]
+		for _, t := range transitions {
+			if !match(t.key) {
+				kept = append(kept, t)
+			}
+		}
+		ql.pending[state] = kept
+	}
+
+	ql.cachedMaxQ = 0
+	ql.qTable.Range(func(_, value interface{}) bool {
+		if v := value.(float64); v > ql.cachedMaxQ {
+			ql.cachedMaxQ = v
+		}
+		return true
+	})
+}
+
+func (ql *QLearning) sweep() {
+	now := time.Now()
+	if !ql.retention.due(now) {
+		return
+	}
+
+	var tracked []string
+	collect := func(key, _ interface{}) bool {
+		backend := key.(string)
+		if i := strings.LastIndex(backend, "|"); i >= 0 {
+			backend = backend[i+1:]
+		}
+		tracked = append(tracked, backend)
+		return true
+	}
+	ql.qTable.Range(collect)
+	ql.qTableB.Range(collect)
+	ql.errorRates.Range(collect)
+
+	expired := ql.retention.expired(ql.pool, tracked, now)
+	if len(expired) == 0 {
+		return
+	}
+	ql.mux.Lock()
+	defer ql.mux.Unlock()
+	for _, u := range expired {
+		ql.forget(u)
+	}
+	log.Printf("Q-Learning evicted state for %d removed backend(s)", len(expired))
+}
+
+func (ql *QLearning) SetReward(reward RewardConfig) {
+	ql.mux.Lock()
+	ql.reward = reward
+	ql.mux.Unlock()
+}
+
+func (ql *QLearning) SetLoadPenalty(perConnection, errorRate float64) {
+	ql.mux.Lock()
+	ql.loadWeight = perConnection
+	ql.errWeight = errorRate
+	ql.mux.Unlock()
+}
+
+func (ql *QLearning) liveScore(b *Backend, qVal float64) float64 {
+	score := qVal - ql.loadWeight*float64(atomic.LoadInt64(&b.ActiveConnections))
+	if val, ok := ql.errorRates.Load(b.URL.String()); ok {
+		score -= ql.errWeight * val.(float64)
+	}
+	return score
+}
+
+func (ql *QLearning) SetWarmup(requests int) {
+	atomic.StoreInt64(&ql.warmup, int64(requests))
+}
+
+func (ql *QLearning) skipWarmup() {
+	atomic.StoreInt64(&ql.served, atomic.LoadInt64(&ql.warmup))
+}
+
+func (ql *QLearning) SetDoubleQ(enabled bool) {
+	ql.mux.Lock()
+	ql.doubleQ = enabled
+	ql.mux.Unlock()
+}
+
+func (ql *QLearning) SetSARSA(enabled bool) {
+	ql.mux.Lock()
+	ql.sarsa = enabled
+	ql.mux.Unlock()
+}
+
+func loadQ(table *sync.Map, key string) float64 {
+	if val, exists := table.Load(key); exists {
+		return val.(float64)
+	}
+	return 0
+}
+
+func (ql *QLearning) qValue(key string) float64 {
+	if ql.doubleQ {
+		return (loadQ(&ql.qTable, key) + loadQ(&ql.qTableB, key)) / 2
+	}
+	return loadQ(&ql.qTable, key)
+}
+
+func (ql *QLearning) doubleTarget(state string, primary, secondary *sync.Map) float64 {
+	bestKey := ""
+	bestQ := 0.0
+	for _, b := range ql.pool.Snapshot() {
+		key := qKey(state, b.URL.String())
+		if q := loadQ(primary, key); bestKey == "" || q > bestQ {
+			bestKey = key
+			bestQ = q
+		}
+	}
+	if bestKey == "" {
+		return 0
+	}
+	return loadQ(secondary, bestKey)
+}
+
+func (ql *QLearning) computeReward(u *url.URL, duration time.Duration, status int, err error) float64 {
+	rc := ql.reward
+	if err != nil {
+		return rc.ServerError
+	}
+
+	reward := rc.Base - rc.LatencyWeight*float64(duration.Milliseconds())
+	if status >= 400 && status < 500 {
+		reward -= rc.ClientErrorWeight
+	}
+	if rc.ConnectionWeight != 0 {
+		for _, b := range ql.pool.Snapshot() {
+			if b.URL.String() == u.String() {
+				reward -= rc.ConnectionWeight * float64(atomic.LoadInt64(&b.ActiveConnections))
+				break
+			}
+		}
+	}
+
+	if reward < rc.Floor {
+		reward = rc.Floor
+	}
+	return reward
+}
+
+func NewContextualQLearning(pool *ServerPool, epsilon, alpha, gamma float64, state []string, pathDepth, timeBuckets int) *QLearning {
+	ql := NewQLearning(pool, epsilon, alpha, gamma)
+	ql.state = state
+	ql.pathDepth = pathDepth
+	if ql.pathDepth <= 0 {
+		ql.pathDepth = 1
+	}
+	ql.timeBucket = timeBuckets
+	if ql.timeBucket <= 0 || ql.timeBucket > 24 {
+		ql.timeBucket = 4
+	}
+	return ql
+}
+
+func (ql *QLearning) stateKey(r *http.Request) string {
+	if len(ql.state) == 0 || r == nil {
+		return ""
+	}
+
+	parts := make([]string, 0, len(ql.state))
+	for _, feature := range ql.state {
+		switch feature {
+		case "path_prefix":
+			segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", ql.pathDepth+1)
+			if len(segments) > ql.pathDepth {
+				segments = segments[:ql.pathDepth]
+			}
+			parts = append(parts, "/"+strings.Join(segments, "/"))
+		case "method":
+			parts = append(parts, r.Method)
+		case "time_of_day":
+			parts = append(parts, "tod"+strconv.Itoa(time.Now().Hour()*ql.timeBucket/24))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func qKey(state, urlStr string) string {
+	if state == "" {
+		return urlStr
+	}
+	return state + "|" + urlStr
+}
+
+func (ql *QLearning) NextBackend(r *http.Request) *Backend {
+	ql.mux.RLock()
+	state := ql.stateKey(r)
+	b := ql.choose(state)
+	sarsa := ql.sarsa
+	ql.mux.RUnlock()
+
+	if b != nil && sarsa {
+		ql.mux.Lock()
+		ql.learnSARSA(state, b.URL.String())
+		ql.mux.Unlock()
+	}
+	return b
+}
+
+func (ql *QLearning) choose(state string) *Backend {
+	backends := ql.pool.Snapshot()
 	if len(backends) == 0 {
 		return nil
 	}
 
+	if n := atomic.AddInt64(&ql.served, 1); n <= atomic.LoadInt64(&ql.warmup) {
+		for i := 0; i < len(backends); i++ {
+			b := backends[(int(n)+i)%len(backends)]
+			if ql.pool.IsSelectable(b) {
+				return b
+			}
+		}
+		return nil
+	}
+
 	if rand.Float64() < ql.epsilon {
 		aliveBackends := make([]*Backend, 0)
 		for _, b := range backends {
-			if b.IsAlive() {
+			if ql.pool.IsSelectable(b) {
 				aliveBackends = append(aliveBackends, b)
 			}
 		}
@@ -58,14 +417,11 @@ func (ql *QLearning) NextBackend(r *http.Request) *Backend {
 	var maxQ float64 = -1e9
 
 	for _, b := range backends {
-		if !b.IsAlive() {
+		if !ql.pool.IsSelectable(b) {
 			continue
 		}
 
-		qVal := 0.0
-		if val, exists := ql.qTable.Load(b.URL.String()); exists {
-			qVal = val.(float64)
-		}
+		qVal := ql.liveScore(b, ql.qValue(qKey(state, b.URL.String())))
 
 		if bestBackend == nil || qVal > maxQ {
 			maxQ = qVal
@@ -75,13 +431,10 @@ func (ql *QLearning) NextBackend(r *http.Request) *Backend {
 
 	if bestBackend == nil {
 		for _, b := range backends {
-			if b.IsAlive() {
+			if b.IsAlive() && !b.IsDraining() && !b.IsSaturated() {
 				return b
 			}
 		}
-		if len(backends) > 0 {
-			return backends[0]
-		}
 		return nil
 	}
 
@@ -89,31 +442,129 @@ func (ql *QLearning) NextBackend(r *http.Request) *Backend {
 }
 
 func (ql *QLearning) OnRequestCompletion(u *url.URL, duration time.Duration, err error) {
+	ql.update("", u, duration, 0, err)
+	ql.sweep()
+}
+
+func (ql *QLearning) OnRequestCompletionFor(r *http.Request, u *url.URL, duration time.Duration, status int, err error) {
+	ql.update(ql.stateKey(r), u, duration, status, err)
+	ql.sweep()
+}
+
+func (ql *QLearning) update(state string, u *url.URL, duration time.Duration, status int, err error) {
 	ql.mux.Lock()
 	defer ql.mux.Unlock()
 
-	urlStr := u.String()
-	var reward float64
+	urlStr := qKey(state, u.String())
+	reward := ql.computeReward(u, duration, status, err)
 
+	failed := 0.0
 	if err != nil {
-		reward = -50.0
-	} else {
-		ms := float64(duration.Milliseconds())
-		reward = 100.0 - ms/10.0
+		failed = 1
+	}
+	errRate := failed
+	if val, ok := ql.errorRates.Load(u.String()); ok {
+		errRate = 0.9*val.(float64) + 0.1*failed
+	}
+	ql.errorRates.Store(u.String(), errRate)
+
+	if ql.sarsa {
+		now := time.Now()
+		pending := append(ql.pending[state], sarsaTransition{key: urlStr, reward: reward, at: now})
+		if n := len(pending) - sarsaMaxPending; n > 0 {
+			ql.bootstrapSARSA(state, pending[:n])
+			pending = append([]sarsaTransition{}, pending[n:]...)
+		}
+		ql.pending[state] = pending
+		ql.expireSARSA(now)
+		return
+	}
 
-		if reward < -50.0 {
-			reward = -50.0
+	table, target := &ql.qTable, ql.cachedMaxQ
+	if ql.doubleQ {
+		if rand.Intn(2) == 0 {
+			target = ql.doubleTarget(state, &ql.qTable, &ql.qTableB)
+		} else {
+			table = &ql.qTableB
+			target = ql.doubleTarget(state, &ql.qTableB, &ql.qTable)
+		}
+	}
+	ql.learn(table, urlStr, reward, target)
+}
+
+func (ql *QLearning) learnSARSA(state, next string) {
+	transitions := ql.pending[state]
+	if len(transitions) == 0 {
+		return
+	}
+	delete(ql.pending, state)
+
+	nextKey := qKey(state, next)
+	for _, t := range transitions {
+		table, target := &ql.qTable, loadQ(&ql.qTable, nextKey)
+		if ql.doubleQ {
+			if rand.Intn(2) == 0 {
+				target = loadQ(&ql.qTableB, nextKey)
+			} else {
+				table, target = &ql.qTableB, loadQ(&ql.qTable, nextKey)
+			}
 		}
+		ql.learn(table, t.key, t.reward, target)
 	}
+}
 
-	oldQ := 0.0
-	if val, exists := ql.qTable.Load(urlStr); exists {
-		oldQ = val.(float64)
+func (ql *QLearning) expireSARSA(now time.Time) {
+	if now.Sub(ql.sarsaSwept) < sarsaPendingTTL {
+		return
 	}
+	ql.sarsaSwept = now
 
-	newQ := (1-ql.alpha)*oldQ + ql.alpha*(reward+ql.gamma*ql.cachedMaxQ)
+	for state, transitions := range ql.pending {
+		n := 0
+		for n < len(transitions) && now.Sub(transitions[n].at) >= sarsaPendingTTL {
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		ql.bootstrapSARSA(state, transitions[:n])
+		if n == len(transitions) {
+			delete(ql.pending, state)
+		} else {
+			ql.pending[state] = append([]sarsaTransition{}, transitions[n:]...)
+		}
+	}
+}
 
-	ql.qTable.Store(urlStr, newQ)
+func (ql *QLearning) bootstrapSARSA(state string, transitions []sarsaTransition) {
+	for _, t := range transitions {
+		table, target := &ql.qTable, ql.greedyValue(state, &ql.qTable)
+		if ql.doubleQ {
+			if rand.Intn(2) == 0 {
+				target = ql.doubleTarget(state, &ql.qTable, &ql.qTableB)
+			} else {
+				table, target = &ql.qTableB, ql.doubleTarget(state, &ql.qTableB, &ql.qTable)
+			}
+		}
+		ql.learn(table, t.key, t.reward, target)
+	}
+}
+
+func (ql *QLearning) greedyValue(state string, table *sync.Map) float64 {
+	best, found := 0.0, false
+	for _, b := range ql.pool.Snapshot() {
+		if q := loadQ(table, qKey(state, b.URL.String())); !found || q > best {
+			best, found = q, true
+		}
+	}
+	return best
+}
+
+func (ql *QLearning) learn(table *sync.Map, urlStr string, reward, target float64) {
+	oldQ := loadQ(table, urlStr)
+	newQ := (1-ql.alpha)*oldQ + ql.alpha*(reward+ql.gamma*target)
+
+	table.Store(urlStr, newQ)
 
 	qDelta := newQ - oldQ
 	if qDelta < 0 {
@@ -121,6 +572,12 @@ func (ql *QLearning) OnRequestCompletion(u *url.URL, duration time.Duration, err
 	}
 	ql.lastQDelta = qDelta
 
+	ql.window[ql.windowPos] = [2]float64{qDelta, reward}
+	ql.windowPos = (ql.windowPos + 1) % convergenceWindow
+	if ql.windowLen < convergenceWindow {
+		ql.windowLen++
+	}
+
 	if newQ > ql.maxQValue {
 		ql.maxQValue = newQ
 	}
@@ -150,11 +607,11 @@ func (ql *QLearning) OnRequestCompletion(u *url.URL, duration time.Duration, err
 }
 
 func (ql *QLearning) AddBackend(b *Backend) {
-	ql.pool.Backends = append(ql.pool.Backends, b)
+	ql.pool.AddBackend(b)
 }
 
 func (ql *QLearning) UpdateBackendStatus(u *url.URL, alive bool) {
-	for _, b := range ql.pool.Backends {
+	for _, b := range ql.pool.Snapshot() {
 		if b.URL.String() == u.String() {
 			b.SetAlive(alive)
 			break
@@ -162,7 +619,44 @@ func (ql *QLearning) UpdateBackendStatus(u *url.URL, alive bool) {
 	}
 }
 
-func (ql *QLearning) Persist(path string) error {
+func (ql *QLearning) Drain(u *url.URL) {
+	for _, b := range ql.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (ql *QLearning) Persist(path string, keep int) error {
+	data, err := ql.MarshalState()
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(path, data, keep)
+}
+
+func (ql *QLearning) Load(path string) error {
+	var firstErr error
+	for _, candidate := range snapshotPaths(path) {
+		data, err := os.ReadFile(candidate)
+		if err == nil {
+			err = ql.UnmarshalState(data)
+		}
+		if err == nil {
+			if candidate != path {
+				log.Printf("Q-table %s unusable (%v), restored from %s", path, firstErr, candidate)
+			}
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (ql *QLearning) MarshalState() ([]byte, error) {
 	ql.mux.RLock()
 	defer ql.mux.RUnlock()
 
@@ -178,41 +672,37 @@ func (ql *QLearning) Persist(path string) error {
 		return true
 	})
 
+	qTableBMap := make(map[string]float64)
+	ql.qTableB.Range(func(key, value interface{}) bool {
+		qTableBMap[key.(string)] = value.(float64)
+		return true
+	})
+
 	data := make(map[string]interface{})
 	data["qTable"] = qTableMap
+	data["qTableB"] = qTableBMap
 	data["counts"] = countsMap
 	data["epsilon"] = ql.epsilon
 	data["gamma"] = ql.gamma
 	data["maxQValue"] = ql.maxQValue
 	data["lastQDelta"] = ql.lastQDelta
 
-	file, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(data)
+	return sealState(data)
 }
 
-func (ql *QLearning) Load(path string) error {
-	ql.mux.Lock()
-	defer ql.mux.Unlock()
-
-	file, err := os.Open(path)
+func (ql *QLearning) UnmarshalState(raw []byte) error {
+	data, err := openState(raw)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	var data map[string]interface{}
-	if err := json.NewDecoder(file).Decode(&data); err != nil {
-		return err
-	}
+	ql.mux.Lock()
+	defer ql.mux.Unlock()
 
 	if qTable, ok := data["qTable"].(map[string]interface{}); ok {
+		if len(qTable) > 0 {
+			ql.skipWarmup()
+		}
 		for k, v := range qTable {
 			if val, ok := v.(float64); ok {
 				ql.qTable.Store(k, val)
@@ -220,6 +710,14 @@ func (ql *QLearning) Load(path string) error {
 		}
 	}
 
+	if qTableB, ok := data["qTableB"].(map[string]interface{}); ok {
+		for k, v := range qTableB {
+			if val, ok := v.(float64); ok {
+				ql.qTableB.Store(k, val)
+			}
+		}
+	}
+
 	if counts, ok := data["counts"].(map[string]interface{}); ok {
 		for k, v := range counts {
 			if val, ok := v.(float64); ok {
@@ -247,6 +745,14 @@ func (ql *QLearning) Load(path string) error {
 	return nil
 }
 
+func (ql *QLearning) RemoveBackend(u *url.URL) {
+	ql.pool.RemoveBackend(u)
+}
+
+func (ql *QLearning) SetWeight(u *url.URL, weight int) {
+	ql.pool.SetWeight(u, weight)
+}
+
 func (ql *QLearning) GetBackends() []*Backend {
-	return ql.pool.Backends
+	return ql.pool.Snapshot()
 }
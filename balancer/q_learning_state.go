@@ -1,6 +1,120 @@
 package balancer
 
-func (ql *QLearning) ExportState(qTable *map[string]float64, counts *map[string]int64, epsilon, gamma, maxQValue, lastQDelta *float64) {
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const stateSchemaVersion = 2
+
+type stateEnvelope struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	State    json.RawMessage `json:"state"`
+}
+
+func sealState(state map[string]interface{}) ([]byte, error) {
+	body, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	return json.MarshalIndent(stateEnvelope{
+		Version:  stateSchemaVersion,
+		Checksum: hex.EncodeToString(sum[:]),
+		State:    body,
+	}, "", "  ")
+}
+
+func openState(raw []byte) (map[string]interface{}, error) {
+	var env stateEnvelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, err
+	}
+
+	body := raw
+	switch {
+	case env.Version == 0:
+		// Unversioned files predate the envelope and carry the state at the top level.
+	case env.Version > stateSchemaVersion:
+		return nil, fmt.Errorf("unsupported Q-table schema version %d", env.Version)
+	default:
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, env.State); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(compact.Bytes())
+		if hex.EncodeToString(sum[:]) != env.Checksum {
+			return nil, fmt.Errorf("Q-table checksum mismatch")
+		}
+		body = compact.Bytes()
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func writeSnapshot(path string, data []byte, keep int) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+
+	if keep > 0 {
+		for i := keep - 1; i >= 1; i-- {
+			os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1))
+		}
+		os.Rename(path, path+".1")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func snapshotPaths(path string) []string {
+	paths := []string{path}
+	for i := 1; ; i++ {
+		candidate := path + "." + strconv.Itoa(i)
+		if _, err := os.Stat(candidate); err != nil {
+			return paths
+		}
+		paths = append(paths, candidate)
+	}
+}
+
+func (ql *QLearning) ExportState(qTable, qTableB *map[string]float64, counts *map[string]int64, epsilon, gamma, maxQValue, lastQDelta *float64) {
 	ql.mux.RLock()
 	defer ql.mux.RUnlock()
 
@@ -9,6 +123,11 @@ func (ql *QLearning) ExportState(qTable *map[string]float64, counts *map[string]
 		return true
 	})
 
+	ql.qTableB.Range(func(key, value interface{}) bool {
+		(*qTableB)[key.(string)] = value.(float64)
+		return true
+	})
+
 	ql.counts.Range(func(key, value interface{}) bool {
 		(*counts)[key.(string)] = value.(int64)
 		return true
@@ -20,10 +139,13 @@ func (ql *QLearning) ExportState(qTable *map[string]float64, counts *map[string]
 	*lastQDelta = ql.lastQDelta
 }
 
-func (ql *QLearning) ImportState(qTable map[string]float64, counts map[string]int64, epsilon, gamma, maxQValue, lastQDelta float64) {
+func (ql *QLearning) ImportState(qTable, qTableB map[string]float64, counts map[string]int64, epsilon, gamma, maxQValue, lastQDelta float64) {
 	ql.mux.Lock()
 	defer ql.mux.Unlock()
 
+	if len(qTable) > 0 {
+		ql.skipWarmup()
+	}
 	for k, v := range qTable {
 		ql.qTable.Store(k, v)
 		if v > ql.cachedMaxQ {
@@ -31,6 +153,10 @@ func (ql *QLearning) ImportState(qTable map[string]float64, counts map[string]in
 		}
 	}
 
+	for k, v := range qTableB {
+		ql.qTableB.Store(k, v)
+	}
+
 	for k, v := range counts {
 		ql.counts.Store(k, v)
 	}
@@ -0,0 +1,104 @@
+package balancer
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func doubleQLearning() *QLearning {
+	ql := NewQLearning(testPool(3), 0.1, 0.3, 0.9)
+	ql.SetDoubleQ(true)
+	for i, b := range ql.pool.Backends {
+		ql.qTable.Store(b.URL.String(), float64(10*i))
+		ql.qTableB.Store(b.URL.String(), float64(100+i))
+	}
+	return ql
+}
+
+func TestSnapshotRoundTripKeepsBothTables(t *testing.T) {
+	src := doubleQLearning()
+	data, err := src.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewQLearning(testPool(3), 0.1, 0.3, 0.9)
+	if err := dst.UnmarshalState(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range src.pool.Backends {
+		key := b.URL.String()
+		if got, want := loadQ(&dst.qTableB, key), loadQ(&src.qTableB, key); got != want {
+			t.Errorf("qTableB[%s] = %v, want %v", key, got, want)
+		}
+		if got, want := loadQ(&dst.qTable, key), loadQ(&src.qTable, key); got != want {
+			t.Errorf("qTable[%s] = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestSnapshotRejectsTamperedState(t *testing.T) {
+	data, err := doubleQLearning().MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte("101"), []byte("999"), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatal("test snapshot does not contain the expected value")
+	}
+	if err := NewQLearning(testPool(3), 0.1, 0.3, 0.9).UnmarshalState(tampered); err == nil {
+		t.Fatal("tampered snapshot was accepted")
+	}
+}
+
+func TestExportImportKeepsBothTables(t *testing.T) {
+	src := doubleQLearning()
+	qTable, qTableB := make(map[string]float64), make(map[string]float64)
+	counts := make(map[string]int64)
+	var epsilon, gamma, maxQ, delta float64
+	src.ExportState(&qTable, &qTableB, &counts, &epsilon, &gamma, &maxQ, &delta)
+	if len(qTableB) != 3 {
+		t.Fatalf("exported %d qTableB entries, want 3", len(qTableB))
+	}
+
+	dst := NewQLearning(testPool(3), 0.1, 0.3, 0.9)
+	dst.ImportState(qTable, qTableB, counts, epsilon, gamma, maxQ, delta)
+	for key, want := range qTableB {
+		if got := loadQ(&dst.qTableB, key); got != want {
+			t.Errorf("qTableB[%s] = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestSnapshotWhileLearning(t *testing.T) {
+	ql := doubleQLearning()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				b := ql.pool.Backends[i%len(ql.pool.Backends)]
+				ql.OnRequestCompletion(b.URL, time.Duration(i)*time.Millisecond, nil)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			data, err := ql.MarshalState()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := NewQLearning(testPool(3), 0.1, 0.3, 0.9).UnmarshalState(data); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
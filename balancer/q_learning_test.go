@@ -0,0 +1,79 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func testPool(n int) *ServerPool {
+	pool := &ServerPool{}
+	for i := 0; i < n; i++ {
+		u, _ := url.Parse(fmt.Sprintf("http://backend-%d.test", i))
+		pool.Backends = append(pool.Backends, NewBackend(u, 1, 3, 10*time.Second, TransportOptions{}))
+	}
+	return pool
+}
+
+func TestSARSABootstrapsFromNextAction(t *testing.T) {
+	pool := testPool(2)
+	ql := NewQLearning(pool, 0, 1, 0.5)
+	ql.SetSARSA(true)
+	first, second := pool.Backends[0].URL, pool.Backends[1].URL
+	ql.qTable.Store(second.String(), 40.0)
+
+	ql.OnRequestCompletion(first, 0, nil)
+	if q := loadQ(&ql.qTable, first.String()); q != 0 {
+		t.Fatalf("Q(first) updated before the next action was chosen: %v", q)
+	}
+
+	next := ql.NextBackend(httptest.NewRequest("GET", "/", nil))
+	if next == nil || next.URL.String() != second.String() {
+		t.Fatalf("expected greedy pick of %s, got %v", second, next)
+	}
+	if q, want := loadQ(&ql.qTable, first.String()), 100+0.5*40; q != want {
+		t.Fatalf("Q(first) = %v, want %v (reward + gamma * Q(next action))", q, want)
+	}
+	if len(ql.pending) != 0 {
+		t.Fatalf("pending transitions left after update: %v", ql.pending)
+	}
+}
+
+func TestSARSAPendingQueueIsBounded(t *testing.T) {
+	pool := testPool(2)
+	ql := NewQLearning(pool, 0, 1, 0.5)
+	ql.SetSARSA(true)
+	first := pool.Backends[0].URL
+
+	for i := 0; i < 10*sarsaMaxPending; i++ {
+		ql.OnRequestCompletion(first, 0, nil)
+	}
+	if n := len(ql.pending[""]); n > sarsaMaxPending {
+		t.Fatalf("%d pending transitions, want at most %d", n, sarsaMaxPending)
+	}
+	if q := loadQ(&ql.qTable, first.String()); q == 0 {
+		t.Fatal("overflowing transitions were dropped instead of learned")
+	}
+}
+
+func TestSARSAStaleTransitionsAreLearned(t *testing.T) {
+	pool := testPool(2)
+	ql := NewQLearning(pool, 0, 1, 0.5)
+	ql.SetSARSA(true)
+	first, second := pool.Backends[0].URL, pool.Backends[1].URL
+	ql.pending["idle"] = []sarsaTransition{{
+		key:    qKey("idle", first.String()),
+		reward: 100,
+		at:     time.Now().Add(-2 * sarsaPendingTTL),
+	}}
+
+	ql.OnRequestCompletion(second, 0, nil)
+	if _, ok := ql.pending["idle"]; ok {
+		t.Fatal("stale transitions of an idle state are still queued")
+	}
+	if q := loadQ(&ql.qTable, qKey("idle", first.String())); q != 100 {
+		t.Fatalf("Q(idle, first) = %v, want 100", q)
+	}
+}
@@ -0,0 +1,172 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+type Factory func(pool *ServerPool, options map[string]interface{}) LoadBalancer
+
+var (
+	registry   = make(map[string]Factory)
+	registryMu sync.RWMutex
+)
+
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+func IsRegistered(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
+
+func Algorithms() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func New(name string, pool *ServerPool, options map[string]interface{}) (LoadBalancer, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown algorithm: %s", name)
+	}
+	return factory(pool, options), nil
+}
+
+func FloatOption(options map[string]interface{}, key string, def float64) float64 {
+	switch v := options[key].(type) {
+	case float64:
+		if v != 0 {
+			return v
+		}
+	case int:
+		if v != 0 {
+			return float64(v)
+		}
+	}
+	return def
+}
+
+func BoolOption(options map[string]interface{}, key string) bool {
+	v, _ := options[key].(bool)
+	return v
+}
+
+func StringOption(options map[string]interface{}, key, def string) string {
+	if v, ok := options[key].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func StringsOption(options map[string]interface{}, key string) []string {
+	switch v := options[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func OptionalFloat(options map[string]interface{}, key string, def float64) float64 {
+	switch v := options[key].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	}
+	return def
+}
+
+func IntOption(options map[string]interface{}, key string, def int) int {
+	switch v := options[key].(type) {
+	case int:
+		if v != 0 {
+			return v
+		}
+	case float64:
+		if v != 0 {
+			return int(v)
+		}
+	}
+	return def
+}
+
+func init() {
+	Register("round-robin", func(pool *ServerPool, _ map[string]interface{}) LoadBalancer {
+		return NewRoundRobin(pool)
+	})
+	Register("least-connections", func(pool *ServerPool, _ map[string]interface{}) LoadBalancer {
+		return NewLeastConnections(pool)
+	})
+	Register("weighted-round-robin", func(pool *ServerPool, _ map[string]interface{}) LoadBalancer {
+		return NewWeightedRoundRobin(pool)
+	})
+	Register("ip-hash", func(pool *ServerPool, _ map[string]interface{}) LoadBalancer {
+		return NewIPHash(pool)
+	})
+	Register("least-response-time", func(pool *ServerPool, _ map[string]interface{}) LoadBalancer {
+		return NewLeastResponseTime(pool)
+	})
+	Register("uri-hash", func(pool *ServerPool, options map[string]interface{}) LoadBalancer {
+		return NewURIHash(pool, BoolOption(options, "include_query"))
+	})
+	Register("hash", func(pool *ServerPool, options map[string]interface{}) LoadBalancer {
+		return NewKeyHash(pool, StringOption(options, "hash_source", "ip"), StringOption(options, "hash_key", ""))
+	})
+	Register("ucb", func(pool *ServerPool, options map[string]interface{}) LoadBalancer {
+		return NewUCB(pool, FloatOption(options, "ucb_exploration", math.Sqrt2))
+	})
+	Register("thompson", func(pool *ServerPool, _ map[string]interface{}) LoadBalancer {
+		return NewThompson(pool)
+	})
+	Register("q-learning", func(pool *ServerPool, options map[string]interface{}) LoadBalancer {
+		ql := NewContextualQLearning(pool,
+			FloatOption(options, "epsilon", 0.01),
+			FloatOption(options, "alpha", 0.3),
+			FloatOption(options, "gamma", 0.95),
+			StringsOption(options, "state"),
+			IntOption(options, "path_depth", 1),
+			IntOption(options, "time_buckets", 4),
+		)
+		defaults := DefaultRewardConfig()
+		ql.SetReward(RewardConfig{
+			Base:              OptionalFloat(options, "reward_base", defaults.Base),
+			LatencyWeight:     OptionalFloat(options, "reward_latency_weight", defaults.LatencyWeight),
+			ServerError:       OptionalFloat(options, "reward_server_error", defaults.ServerError),
+			ClientErrorWeight: OptionalFloat(options, "reward_client_error_penalty", defaults.ClientErrorWeight),
+			ConnectionWeight:  OptionalFloat(options, "reward_connection_penalty", defaults.ConnectionWeight),
+			Floor:             OptionalFloat(options, "reward_floor", defaults.Floor),
+		})
+		ql.SetDoubleQ(BoolOption(options, "double_q"))
+		ql.SetSARSA(StringOption(options, "mode", "q-learning") == "sarsa")
+		ql.SetWarmup(IntOption(options, "warmup_requests", 0))
+		ql.SetLoadPenalty(
+			OptionalFloat(options, "load_penalty", 0),
+			OptionalFloat(options, "error_rate_penalty", 0),
+		)
+		return ql
+	})
+}
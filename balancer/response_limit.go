@@ -0,0 +1,59 @@
+package balancer
+
+import (
+	"advanced-lb/features"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+var ErrResponseTooLarge = errors.New("response exceeds maximum size")
+
+type responseLimitKey struct{}
+
+func WithMaxResponseBytes(r *http.Request, limit int64) *http.Request {
+	if limit <= 0 {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), responseLimitKey{}, limit))
+}
+
+func maxResponseBytes(r *http.Request) int64 {
+	if r == nil {
+		return 0
+	}
+	limit, _ := r.Context().Value(responseLimitKey{}).(int64)
+	return limit
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		features.RecordResponseTooLarge()
+		return n + int(l.remaining), ErrResponseTooLarge
+	}
+	return n, err
+}
+
+func limitResponse(resp *http.Response) error {
+	limit := maxResponseBytes(resp.Request)
+	if limit <= 0 {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		features.RecordResponseTooLarge()
+		return ErrResponseTooLarge
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit}
+	return nil
+}
@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultStateRetention = 10 * time.Minute
+
+var stateRetention = struct {
+	sync.RWMutex
+	ttl time.Duration
+}{ttl: defaultStateRetention}
+
+func SetStateRetention(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultStateRetention
+	}
+	stateRetention.Lock()
+	stateRetention.ttl = ttl
+	stateRetention.Unlock()
+}
+
+func retentionTTL() time.Duration {
+	stateRetention.RLock()
+	defer stateRetention.RUnlock()
+	return stateRetention.ttl
+}
+
+type retention struct {
+	mu        sync.Mutex
+	missing   map[string]time.Time
+	lastSweep time.Time
+}
+
+func (r *retention) due(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	interval := retentionTTL() / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if now.Sub(r.lastSweep) < interval {
+		return false
+	}
+	r.lastSweep = now
+	return true
+}
+
+func (r *retention) expired(pool *ServerPool, tracked []string, now time.Time) []string {
+	live := make(map[string]bool)
+	for _, b := range pool.Snapshot() {
+		live[b.URL.String()] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.missing == nil {
+		r.missing = make(map[string]time.Time)
+	}
+	ttl := retentionTTL()
+	seen := make(map[string]bool)
+	var evict []string
+	for _, u := range tracked {
+		if live[u] || seen[u] {
+			continue
+		}
+		seen[u] = true
+		since, ok := r.missing[u]
+		if !ok {
+			r.missing[u] = now
+			continue
+		}
+		if now.Sub(since) >= ttl {
+			evict = append(evict, u)
+			delete(r.missing, u)
+		}
+	}
+	for u := range r.missing {
+		if !seen[u] {
+			delete(r.missing, u)
+		}
+	}
+	return evict
+}
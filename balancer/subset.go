@@ -0,0 +1,39 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+func subsetScore(id string, b *Backend) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h.Write([]byte{0})
+	h.Write([]byte(b.URL.String()))
+	return h.Sum64()
+}
+
+func Subset(backends []*Backend, id string, size int) []*Backend {
+	if size <= 0 || size >= len(backends) {
+		return backends
+	}
+
+	ranked := make([]*Backend, len(backends))
+	copy(ranked, backends)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return subsetScore(id, ranked[i]) > subsetScore(id, ranked[j])
+	})
+
+	selected := make(map[*Backend]bool, size)
+	for _, b := range ranked[:size] {
+		selected[b] = true
+	}
+
+	subset := make([]*Backend, 0, size)
+	for _, b := range backends {
+		if selected[b] {
+			subset = append(subset, b)
+		}
+	}
+	return subset
+}
@@ -0,0 +1,157 @@
+package balancer
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type thompsonArm struct {
+	successes float64
+	failures  float64
+	n         float64
+	meanMs    float64
+	m2        float64
+}
+
+type Thompson struct {
+	pool *ServerPool
+	arms map[string]*thompsonArm
+	mux  sync.RWMutex
+}
+
+func NewThompson(pool *ServerPool) *Thompson {
+	return &Thompson{
+		pool: pool,
+		arms: make(map[string]*thompsonArm),
+	}
+}
+
+func sampleGamma(shape float64) float64 {
+	if shape < 1 {
+		return sampleGamma(shape+1) * math.Pow(rand.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+func sampleBeta(a, b float64) float64 {
+	x := sampleGamma(a)
+	y := sampleGamma(b)
+	return x / (x + y)
+}
+
+func (arm *thompsonArm) sample() float64 {
+	success := sampleBeta(arm.successes+1, arm.failures+1)
+
+	latency := 0.0
+	if arm.n > 0 {
+		variance := arm.meanMs * arm.meanMs
+		if arm.n > 1 {
+			variance = arm.m2 / (arm.n - 1)
+		}
+		latency = arm.meanMs + rand.NormFloat64()*math.Sqrt(variance/arm.n)
+		if latency < 0 {
+			latency = 0
+		}
+	}
+	return success / (1 + latency/100.0)
+}
+
+func (t *Thompson) NextBackend(r *http.Request) *Backend {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	var best *Backend
+	bestScore := -1.0
+
+	for _, b := range t.pool.Snapshot() {
+		if !t.pool.IsSelectable(b) {
+			continue
+		}
+		arm, ok := t.arms[b.URL.String()]
+		if !ok {
+			arm = &thompsonArm{}
+		}
+		if score := arm.sample(); score > bestScore {
+			bestScore = score
+			best = b
+		}
+	}
+	return best
+}
+
+func (t *Thompson) OnRequestCompletion(u *url.URL, d time.Duration, err error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	arm, ok := t.arms[u.String()]
+	if !ok {
+		arm = &thompsonArm{}
+		t.arms[u.String()] = arm
+	}
+
+	if err != nil {
+		arm.failures++
+		return
+	}
+	arm.successes++
+
+	ms := float64(d) / float64(time.Millisecond)
+	arm.n++
+	delta := ms - arm.meanMs
+	arm.meanMs += delta / arm.n
+	arm.m2 += delta * (ms - arm.meanMs)
+}
+
+func (t *Thompson) AddBackend(b *Backend) {
+	t.pool.AddBackend(b)
+}
+
+func (t *Thompson) UpdateBackendStatus(u *url.URL, alive bool) {
+	for _, b := range t.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetAlive(alive)
+			break
+		}
+	}
+}
+
+func (t *Thompson) Drain(u *url.URL) {
+	for _, b := range t.pool.Snapshot() {
+		if b.URL.String() == u.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (t *Thompson) RemoveBackend(u *url.URL) {
+	t.pool.RemoveBackend(u)
+	t.mux.Lock()
+	delete(t.arms, u.String())
+	t.mux.Unlock()
+}
+
+func (t *Thompson) SetWeight(u *url.URL, weight int) {
+	t.pool.SetWeight(u, weight)
+}
+
+func (t *Thompson) GetBackends() []*Backend {
+	return t.pool.Snapshot()
+}
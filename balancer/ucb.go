@@ -0,0 +1,113 @@
+package balancer
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type ucbArm struct {
+	pulls  float64
+	reward float64
+}
+
+type UCB struct {
+	pool        *ServerPool
+	arms        map[string]*ucbArm
+	total       float64
+	exploration float64
+	mux         sync.RWMutex
+}
+
+func NewUCB(pool *ServerPool, exploration float64) *UCB {
+	return &UCB{
+		pool:        pool,
+		arms:        make(map[string]*ucbArm),
+		exploration: exploration,
+	}
+}
+
+func latencyReward(d time.Duration, err error) float64 {
+	if err != nil {
+		return 0
+	}
+	return 1 / (1 + float64(d.Milliseconds())/100.0)
+}
+
+func (u *UCB) NextBackend(r *http.Request) *Backend {
+	u.mux.RLock()
+	defer u.mux.RUnlock()
+
+	var best *Backend
+	bestScore := math.Inf(-1)
+	logTotal := math.Log(u.total + 1)
+
+	for _, b := range u.pool.Snapshot() {
+		if !u.pool.IsSelectable(b) {
+			continue
+		}
+		arm, ok := u.arms[b.URL.String()]
+		if !ok || arm.pulls == 0 {
+			return b
+		}
+		score := arm.reward/arm.pulls + u.exploration*math.Sqrt(logTotal/arm.pulls)
+		if score > bestScore {
+			bestScore = score
+			best = b
+		}
+	}
+	return best
+}
+
+func (u *UCB) OnRequestCompletion(target *url.URL, d time.Duration, err error) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	arm, ok := u.arms[target.String()]
+	if !ok {
+		arm = &ucbArm{}
+		u.arms[target.String()] = arm
+	}
+	arm.pulls++
+	arm.reward += latencyReward(d, err)
+	u.total++
+}
+
+func (u *UCB) AddBackend(b *Backend) {
+	u.pool.AddBackend(b)
+}
+
+func (u *UCB) UpdateBackendStatus(target *url.URL, alive bool) {
+	for _, b := range u.pool.Snapshot() {
+		if b.URL.String() == target.String() {
+			b.SetAlive(alive)
+			break
+		}
+	}
+}
+
+func (u *UCB) Drain(target *url.URL) {
+	for _, b := range u.pool.Snapshot() {
+		if b.URL.String() == target.String() {
+			b.SetDraining(true)
+			break
+		}
+	}
+}
+
+func (u *UCB) RemoveBackend(target *url.URL) {
+	u.pool.RemoveBackend(target)
+	u.mux.Lock()
+	delete(u.arms, target.String())
+	u.mux.Unlock()
+}
+
+func (u *UCB) SetWeight(target *url.URL, weight int) {
+	u.pool.SetWeight(target, weight)
+}
+
+func (u *UCB) GetBackends() []*Backend {
+	return u.pool.Snapshot()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const defaultBodyPeekBytes = 64 * 1024
+
+type bodyMatcher struct {
+	jsonField  string
+	soapAction string
+	equals     string
+	maxBytes   int64
+}
+
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+type bufferedBody struct {
+	data []byte
+	ok   bool
+}
+
+func newBodyMatcher(rc RouteConfig) *bodyMatcher {
+	if rc.Body.JSONField == "" && rc.Body.SOAPAction == "" {
+		return nil
+	}
+	maxBytes := rc.Body.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultBodyPeekBytes
+	}
+	return &bodyMatcher{
+		jsonField:  rc.Body.JSONField,
+		soapAction: rc.Body.SOAPAction,
+		equals:     rc.Body.Equals,
+		maxBytes:   maxBytes,
+	}
+}
+
+func (m *bodyMatcher) String() string {
+	if m.soapAction != "" {
+		return "soap_action=" + m.soapAction
+	}
+	return m.jsonField + "=" + m.equals
+}
+
+func peekBody(r *http.Request, limit int64) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body = peekedBody{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || int64(len(data)) > limit {
+		return nil, false
+	}
+	return data, true
+}
+
+func bufferBody(r *http.Request, limit int64) *bufferedBody {
+	if limit <= 0 {
+		return nil
+	}
+	data, ok := peekBody(r, limit)
+	return &bufferedBody{data: data, ok: ok}
+}
+
+func (m *bodyMatcher) needsBody(r *http.Request) bool {
+	return m.soapAction == "" || headerSOAPAction(r) == ""
+}
+
+func (m *bodyMatcher) match(r *http.Request, body *bufferedBody) bool {
+	if m.soapAction != "" {
+		if action := headerSOAPAction(r); action != "" {
+			return soapActionMatches(action, m.soapAction)
+		}
+	}
+	if body == nil || !body.ok || int64(len(body.data)) > m.maxBytes {
+		return false
+	}
+	if m.soapAction != "" {
+		return soapActionMatches(bodySOAPAction(body.data), m.soapAction)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body.data, &doc); err != nil {
+		return false
+	}
+	for _, part := range strings.Split(m.jsonField, ".") {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if doc, ok = obj[part]; !ok {
+			return false
+		}
+	}
+	return fmt.Sprint(doc) == m.equals
+}
+
+func headerSOAPAction(r *http.Request) string {
+	if action := strings.Trim(r.Header.Get("SOAPAction"), `"`); action != "" {
+		return action
+	}
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		return params["action"]
+	}
+	return ""
+}
+
+func bodySOAPAction(body []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	inBody := false
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if inBody {
+			return start.Name.Local
+		}
+		inBody = start.Name.Local == "Body"
+	}
+}
+
+func soapActionMatches(action, want string) bool {
+	if action == want {
+		return true
+	}
+	if i := strings.LastIndexAny(action, "/#"); i >= 0 {
+		return action[i+1:] == want
+	}
+	return false
+}
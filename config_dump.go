@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "REDACTED"
+
+func defaultDuration(value *string, fallback time.Duration) {
+	d, err := time.ParseDuration(*value)
+	if err != nil || d <= 0 {
+		d = fallback
+	}
+	*value = d.String()
+}
+
+func defaultInt(value *int, fallback int) {
+	*value = intOr(*value, fallback)
+}
+
+func defaultBackendConfigs(cfg *Config, defs []BackendConfig) {
+	for i := range defs {
+		b := &defs[i]
+		if b.Weight == nil {
+			weight := 1
+			b.Weight = &weight
+		}
+		threshold, timeout := backendBreakerSettings(cfg, *b)
+		b.CircuitBreaker.Threshold = threshold
+		b.CircuitBreaker.Timeout = timeout.String()
+		b.URL = redactURL(b.URL)
+		redactUpstreamAuth(&b.UpstreamAuth)
+	}
+}
+
+func effectiveConfig(cfg *Config) (*Config, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	out := &Config{}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return nil, err
+	}
+
+	threshold, timeout := breakerSettings(out)
+	out.CircuitBreaker.Threshold = threshold
+	out.CircuitBreaker.Timeout = timeout.String()
+	defaultDuration(&out.HealthCheck, 10*time.Second)
+	defaultDuration(&out.ShutdownTimeout, 30*time.Second)
+	defaultDuration(&out.Transport.IdleTimeout, 90*time.Second)
+	defaultInt(&out.Transport.MaxIdlePerHost, 10)
+	defaultDuration(&out.Server.ReadTimeout, 15*time.Second)
+	defaultDuration(&out.Server.WriteTimeout, 15*time.Second)
+	defaultDuration(&out.Server.IdleTimeout, 60*time.Second)
+	defaultDuration(&out.Health.Timeout, 2*time.Second)
+	defaultInt(&out.Health.Rise, 2)
+	defaultInt(&out.Health.Fall, 3)
+	defaultInt(&out.Health.Concurrency, 10)
+	if out.Health.Jitter == nil {
+		jitter := 0.1
+		out.Health.Jitter = &jitter
+	}
+	defaultInt(&out.Health.Passive.ConsecutiveFailures, 5)
+	defaultDuration(&out.Health.OutlierDetection.Interval, 10*time.Second)
+	defaultDuration(&out.Health.OutlierDetection.BaseEjectionTime, 30*time.Second)
+	defaultDuration(&out.ConfigWatch.Debounce, 500*time.Millisecond)
+	defaultDuration(&out.SelfMonitor.Interval, 10*time.Second)
+	if out.SelfMonitor.WarnHeadroom <= 0 {
+		out.SelfMonitor.WarnHeadroom = 0.2
+	}
+	defaultDuration(&out.LoadShedding.Window, 5*time.Minute)
+	defaultInt(&out.LoadShedding.MinRequests, 20)
+	defaultDuration(&out.Mirror.Timeout, 5*time.Second)
+	defaultDuration(&out.Warmup.Timeout, 5*time.Second)
+	defaultInt(&out.Warmup.Concurrency, 4)
+	defaultDuration(&out.Blocklist.Interval, 5*time.Minute)
+	defaultDuration(&out.Blocklist.TarpitDelay, 10*time.Second)
+	defaultDuration(&out.Idempotency.TTL, 24*time.Hour)
+	defaultInt(&out.Idempotency.MaxEntries, 10000)
+	if out.Idempotency.MaxBodySize <= 0 {
+		out.Idempotency.MaxBodySize = 1 << 20
+	}
+	if out.RateLimiter.Persist && out.RateLimiter.PersistPath == "" {
+		out.RateLimiter.PersistPath = limiterStatePath
+	}
+	defaultDuration(&out.Kubernetes.ResyncInterval, 30*time.Second)
+	defaultDuration(&out.DNSExport.Interval, 15*time.Second)
+	if out.MiddlewareRollout.MaxErrorRate <= 0 {
+		out.MiddlewareRollout.MaxErrorRate = 0.05
+	}
+	if out.ClusterFailover.Overprovisioning <= 0 {
+		out.ClusterFailover.Overprovisioning = 1.4
+	}
+
+	defaultBackendConfigs(out, out.Backends)
+	for i := range out.Clusters {
+		if out.Clusters[i].Weight == nil {
+			weight := 1
+			out.Clusters[i].Weight = &weight
+		}
+		defaultBackendConfigs(out, out.Clusters[i].Backends)
+	}
+	for i := range out.Routes {
+		defaultBackendConfigs(out, out.Routes[i].Backends)
+	}
+
+	redactConfig(out)
+	return out, nil
+}
+
+func redactString(value *string) {
+	if *value != "" {
+		*value = redacted
+	}
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.User == nil && u.RawQuery == "") {
+		return raw
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query[key] = []string{redacted}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+func redactUpstreamAuth(ua *UpstreamAuthConfig) {
+	redactString(&ua.Token)
+	redactString(&ua.Password)
+	redactString(&ua.JWT.Secret)
+}
+
+func redactConfig(cfg *Config) {
+	redactString(&cfg.Storage.Password)
+	redactString(&cfg.Storage.AccessKey)
+	redactString(&cfg.Storage.SecretKey)
+	redactString(&cfg.DNSExport.AccessKey)
+	redactString(&cfg.DNSExport.SecretKey)
+	for i := range cfg.Auth.APIKeys {
+		cfg.Auth.APIKeys[i] = redacted
+	}
+	for i := range cfg.Health.Webhooks {
+		redactString(&cfg.Health.Webhooks[i].URL)
+		redactString(&cfg.Health.Webhooks[i].RoutingKey)
+	}
+	cfg.Storage.Address = redactURL(cfg.Storage.Address)
+	cfg.Storage.Endpoint = redactURL(cfg.Storage.Endpoint)
+	cfg.DNSExport.Endpoint = redactURL(cfg.DNSExport.Endpoint)
+	for i, src := range cfg.Blocklist.Sources {
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			cfg.Blocklist.Sources[i] = redactURL(src)
+		}
+	}
+	cfg.Fallback.URL = redactURL(cfg.Fallback.URL)
+	cfg.Canary.URL = redactURL(cfg.Canary.URL)
+	cfg.Mirror.URL = redactURL(cfg.Mirror.URL)
+	cfg.ExtAuthz.URL = redactURL(cfg.ExtAuthz.URL)
+	for i := range cfg.Routes {
+		redactUpstreamAuth(&cfg.Routes[i].UpstreamAuth)
+	}
+	for i := range cfg.Steering.Peers {
+		cfg.Steering.Peers[i].URL = redactURL(cfg.Steering.Peers[i].URL)
+	}
+}
+
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			out[fmt.Sprint(k)] = jsonCompatible(val)
+		}
+		return out
+	case []interface{}:
+		for i := range t {
+			t[i] = jsonCompatible(t[i])
+		}
+	}
+	return v
+}
+
+func configDumpHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.RLock()
+	cfg, err := effectiveConfig(currentCfg)
+	mu.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "yaml" || strings.Contains(r.Header.Get("Accept"), "yaml") {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+		return
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(jsonCompatible(doc))
+}
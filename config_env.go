@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const envOverridePrefix = "GOADAPT_"
+
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+func expandEnv(doc interface{}) (interface{}, error) {
+	var missing []string
+	doc = expandEnvValue(doc, &missing)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("config references unset environment variable(s): %s", strings.Join(missing, ", "))
+	}
+	return doc, nil
+}
+
+func expandEnvValue(v interface{}, missing *[]string) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for k, item := range v {
+			v[k] = expandEnvValue(item, missing)
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = expandEnvValue(item, missing)
+		}
+	case []map[string]interface{}:
+		for _, item := range v {
+			expandEnvValue(item, missing)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = expandEnvValue(item, missing)
+		}
+	case string:
+		return expandEnvString(v, missing)
+	}
+	return v
+}
+
+func expandEnvString(s string, missing *[]string) interface{} {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	loc := envReference.FindStringSubmatchIndex(s)
+	whole := loc[0] == 0 && loc[1] == len(s) && loc[2] >= 0
+
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		m := envReference.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1]); ok {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		*missing = append(*missing, m[1])
+		return ""
+	})
+	if whole {
+		return envScalar(expanded)
+	}
+	return expanded
+}
+
+func envScalar(v string) interface{} {
+	switch v {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && strconv.FormatInt(n, 10) == v {
+		return n
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && strconv.FormatFloat(f, 'f', -1, 64) == v {
+		return f
+	}
+	return v
+}
+
+func applyEnvOverrides(cfg *Config) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := envOverridePrefix + strings.ToUpper(tag)
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Ptr {
+			if !isScalar(field.Type().Elem().Kind()) {
+				continue
+			}
+			field.Set(reflect.New(field.Type().Elem()))
+			field = field.Elem()
+		}
+		if !isScalar(field.Kind()) {
+			continue
+		}
+		if err := setScalar(field, raw); err != nil {
+			return fmt.Errorf("invalid %s=%q: %v", name, raw, err)
+		}
+	}
+	return nil
+}
+
+func isScalar(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func setScalar(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	}
+	return nil
+}
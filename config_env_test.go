@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func decodeTestConfig(t *testing.T, name, doc string) *Config {
+	data, err := decodeConfigDocument([]byte(doc), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	return &cfg
+}
+
+func TestExpandEnvIgnoresComments(t *testing.T) {
+	os.Unsetenv("GOADAPT_TEST_UNSET")
+	cfg := decodeTestConfig(t, "config.yaml", "# token: ${GOADAPT_TEST_UNSET}\nport: 8080 # ${GOADAPT_TEST_UNSET}\n")
+	if cfg.Port != 8080 {
+		t.Fatalf("port = %d, want 8080", cfg.Port)
+	}
+}
+
+func TestExpandEnvKeepsValueStructure(t *testing.T) {
+	secret := "p: a#ss\"w\nord"
+	os.Setenv("GOADAPT_TEST_SECRET", secret)
+	defer os.Unsetenv("GOADAPT_TEST_SECRET")
+
+	for name, doc := range map[string]string{
+		"config.yaml": "algorithm: ${GOADAPT_TEST_SECRET}\nport: 8080\n",
+		"config.json": `{"algorithm": "${GOADAPT_TEST_SECRET}", "port": 8080}`,
+	} {
+		cfg := decodeTestConfig(t, name, doc)
+		if cfg.Algorithm != secret || cfg.Port != 8080 {
+			t.Errorf("%s: algorithm = %q, port = %d", name, cfg.Algorithm, cfg.Port)
+		}
+	}
+}
+
+func TestExpandEnvScalarsAndEscapes(t *testing.T) {
+	os.Setenv("GOADAPT_TEST_PORT", "9090")
+	os.Setenv("GOADAPT_TEST_TOKEN", "0123")
+	defer os.Unsetenv("GOADAPT_TEST_PORT")
+	defer os.Unsetenv("GOADAPT_TEST_TOKEN")
+
+	cfg := decodeTestConfig(t, "config.yaml", `
+port: ${GOADAPT_TEST_PORT}
+algorithm: ${GOADAPT_TEST_TOKEN}
+zone: $${HOME}/x-${GOADAPT_TEST_MISSING:-dflt}
+`)
+	if cfg.Port != 9090 {
+		t.Errorf("port = %d, want 9090", cfg.Port)
+	}
+	if cfg.Algorithm != "0123" {
+		t.Errorf("algorithm = %q, want %q", cfg.Algorithm, "0123")
+	}
+	if cfg.Zone != "${HOME}/x-dflt" {
+		t.Errorf("zone = %q, want %q", cfg.Zone, "${HOME}/x-dflt")
+	}
+}
+
+func TestExpandEnvReportsUnsetVariables(t *testing.T) {
+	os.Unsetenv("GOADAPT_TEST_UNSET")
+	if _, err := decodeConfigDocument([]byte("algorithm: ${GOADAPT_TEST_UNSET}\n"), "config.yaml"); err == nil {
+		t.Fatal("unset variable without a default was accepted")
+	}
+}
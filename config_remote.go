@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const remoteWatchRetry = 5 * time.Second
+
+type remoteKV struct {
+	Key   string
+	Value []byte
+}
+
+type remoteSource struct {
+	kind   string
+	base   string
+	prefix string
+	token  string
+	client *http.Client
+}
+
+func parseRemoteSource(raw string) (*remoteSource, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+	scheme := "http"
+	if u.Query().Get("tls") == "true" {
+		scheme = "https"
+	}
+	rs := &remoteSource{
+		base:   scheme + "://" + u.Host,
+		prefix: u.Path,
+		client: &http.Client{},
+	}
+	switch u.Scheme {
+	case "etcd":
+		rs.kind = "etcd"
+	case "consul":
+		rs.kind = "consul"
+		rs.prefix = strings.TrimPrefix(u.Path, "/")
+		rs.token = os.Getenv("CONSUL_HTTP_TOKEN")
+	default:
+		return nil, false
+	}
+	return rs, true
+}
+
+func (rs *remoteSource) String() string {
+	return rs.kind + " prefix " + rs.prefix
+}
+
+func (rs *remoteSource) load() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	kvs, _, err := rs.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", rs, err)
+	}
+	if len(kvs) == 0 {
+		return nil, fmt.Errorf("%s: no configuration keys found", rs)
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+
+	merged := make(map[string]interface{})
+	for _, kv := range kvs {
+		if len(bytes.TrimSpace(kv.Value)) == 0 {
+			continue
+		}
+		data, err := decodeConfigDocument(kv.Value, kv.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %s: %v", rs, kv.Key, err)
+		}
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: key %s: %v", rs, kv.Key, err)
+		}
+		for k, v := range doc {
+			merged[k] = v
+		}
+	}
+	return yaml.Marshal(merged)
+}
+
+func (rs *remoteSource) list(ctx context.Context) ([]remoteKV, uint64, error) {
+	if rs.kind == "etcd" {
+		return rs.etcdRange(ctx)
+	}
+	return rs.consulList(ctx, 0)
+}
+
+func (rs *remoteSource) wait(ctx context.Context, index uint64) (uint64, error) {
+	if rs.kind == "etcd" {
+		return rs.etcdWatch(ctx, index)
+	}
+	_, next, err := rs.consulList(ctx, index)
+	return next, err
+}
+
+func (rs *remoteSource) consulList(ctx context.Context, index uint64) ([]remoteKV, uint64, error) {
+	q := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", "5m")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rs.base+"/v1/kv/"+rs.prefix+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if rs.token != "" {
+		req.Header.Set("X-Consul-Token", rs.token)
+	}
+	resp, err := rs.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, next, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned status %d", resp.StatusCode)
+	}
+	var entries []struct {
+		Key   string
+		Value []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+	kvs := make([]remoteKV, 0, len(entries))
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Key, "/") {
+			kvs = append(kvs, remoteKV{Key: e.Key, Value: e.Value})
+		}
+	}
+	return kvs, next, nil
+}
+
+func etcdPrefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+func (rs *remoteSource) etcdPost(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rs.base+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := rs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("etcd returned status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+func (h etcdHeader) revision() uint64 {
+	rev, _ := strconv.ParseUint(h.Revision, 10, 64)
+	return rev
+}
+
+func (rs *remoteSource) etcdRange(ctx context.Context) ([]remoteKV, uint64, error) {
+	resp, err := rs.etcdPost(ctx, "/v3/kv/range", map[string]interface{}{
+		"key":       []byte(rs.prefix),
+		"range_end": etcdPrefixEnd(rs.prefix),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Header etcdHeader `json:"header"`
+		KVs    []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, 0, err
+	}
+	kvs := make([]remoteKV, 0, len(out.KVs))
+	for _, kv := range out.KVs {
+		key, _ := base64.StdEncoding.DecodeString(kv.Key)
+		value, _ := base64.StdEncoding.DecodeString(kv.Value)
+		kvs = append(kvs, remoteKV{Key: string(key), Value: value})
+	}
+	return kvs, out.Header.revision(), nil
+}
+
+func (rs *remoteSource) etcdWatch(ctx context.Context, revision uint64) (uint64, error) {
+	resp, err := rs.etcdPost(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(rs.prefix),
+			"range_end":      etcdPrefixEnd(rs.prefix),
+			"start_revision": strconv.FormatUint(revision+1, 10),
+		},
+	})
+	if err != nil {
+		return revision, err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Header   etcdHeader        `json:"header"`
+				Canceled bool              `json:"canceled"`
+				Events   []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("etcd watch stream closed")
+			}
+			return revision, err
+		}
+		if msg.Result.Canceled {
+			return revision, fmt.Errorf("etcd watch canceled")
+		}
+		if len(msg.Result.Events) > 0 {
+			return msg.Result.Header.revision(), nil
+		}
+	}
+}
+
+type remoteConfigWatcher struct {
+	source   *remoteSource
+	debounce time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func newRemoteConfigWatcher(source *remoteSource, debounce time.Duration) *remoteConfigWatcher {
+	return &remoteConfigWatcher{
+		source:   source,
+		debounce: debounce,
+		done:     make(chan struct{}),
+	}
+}
+
+func (rw *remoteConfigWatcher) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	rw.cancel = cancel
+
+	_, index, err := rw.source.list(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	log.Printf("Watching %s for config changes", rw.source)
+
+	go func() {
+		defer close(rw.done)
+		for {
+			next, err := rw.source.wait(ctx, index)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("Config watch on %s failed, retrying in %v: %v", rw.source, remoteWatchRetry, err)
+				select {
+				case <-time.After(remoteWatchRetry):
+				case <-ctx.Done():
+					return
+				}
+				if _, next, err = rw.source.list(ctx); err != nil {
+					continue
+				}
+			}
+			if next == index {
+				continue
+			}
+			if next < index {
+				next = 0
+			}
+			index = next
+
+			select {
+			case <-time.After(rw.debounce):
+			case <-ctx.Done():
+				return
+			}
+			reloadWatchedConfig(rw.source.String())
+		}
+	}()
+	return nil
+}
+
+func (rw *remoteConfigWatcher) Stop() {
+	rw.cancel()
+	<-rw.done
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type configWatcher struct {
+	path     string
+	debounce time.Duration
+	last     []byte
+	watcher  *fsnotify.Watcher
+	done     chan struct{}
+}
+
+func newConfigWatcher(path string, debounce time.Duration) *configWatcher {
+	return &configWatcher{
+		path:     path,
+		debounce: debounce,
+		done:     make(chan struct{}),
+	}
+}
+
+func (cw *configWatcher) Start() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(filepath.Dir(cw.path)); err != nil {
+		w.Close()
+		return err
+	}
+	cw.watcher = w
+	cw.last, _ = os.ReadFile(cw.path)
+	log.Printf("Watching %s for config changes", cw.path)
+
+	go func() {
+		defer close(cw.done)
+		timer := time.NewTimer(cw.debounce)
+		timer.Stop()
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if cw.relevant(ev.Name) {
+					timer.Reset(cw.debounce)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Config watcher error: %v", err)
+			case <-timer.C:
+				cw.reload()
+			}
+		}
+	}()
+	return nil
+}
+
+func (cw *configWatcher) relevant(name string) bool {
+	base := filepath.Base(name)
+	return base == filepath.Base(cw.path) || base == "..data"
+}
+
+func (cw *configWatcher) reload() {
+	data, err := os.ReadFile(cw.path)
+	if err != nil || bytes.Equal(data, cw.last) {
+		return
+	}
+
+	cw.last = data
+	reloadWatchedConfig("file " + cw.path)
+}
+
+func reloadWatchedConfig(source string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	log.Printf("Config %s changed, reloading...", source)
+	newCfg, err := loadConfig(configPath)
+	if err == nil {
+		err = validateConfig(newCfg)
+	}
+	if err != nil {
+		setConfigError(err)
+		log.Printf("Config change rejected, keeping the previous configuration: %v", err)
+		return
+	}
+	setConfigError(nil)
+	applyConfig(newCfg)
+}
+
+func (cw *configWatcher) Stop() {
+	cw.watcher.Close()
+	<-cw.done
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const devCertValidity = 30 * 24 * time.Hour
+
+func devCertHosts() ([]string, []net.IP) {
+	names := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && host != "" && host != "localhost" {
+		names = append(names, host)
+	}
+	return names, []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+}
+
+func newDevTemplate(cn string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"GoAdapt dev"}, CommonName: cn},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(devCertValidity),
+	}, nil
+}
+
+func devTLSCertificate(caPath string) (tls.Certificate, error) {
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := newDevTemplate("localhost")
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf.DNSNames, leaf.IPAddresses = devCertHosts()
+	leaf.KeyUsage = x509.KeyUsageDigitalSignature
+	leaf.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	parent, parentKey := leaf, leafKey
+	var chain [][]byte
+	if caPath != "" {
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		ca, err := newDevTemplate("GoAdapt dev CA")
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		ca.IsCA = true
+		ca.BasicConstraintsValid = true
+		ca.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		parent, parentKey = ca, caKey
+		chain = append(chain, caDER)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, leaf, parent, &leafKey.PublicKey, parentKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: append([][]byte{der}, chain...),
+		PrivateKey:  leafKey,
+	}, nil
+}
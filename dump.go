@@ -0,0 +1,85 @@
+package main
+
+import (
+	"advanced-lb/balancer"
+	"advanced-lb/features"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+)
+
+type backendDump struct {
+	URL            string            `json:"url"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Alive          bool              `json:"alive"`
+	Draining       bool              `json:"draining,omitempty"`
+	Ejected        bool              `json:"ejected,omitempty"`
+	Weight         int               `json:"weight"`
+	Active         int64             `json:"active"`
+	CircuitBreaker string            `json:"breaker"`
+}
+
+type poolDump struct {
+	Name     string        `json:"name"`
+	Backends []backendDump `json:"backends"`
+}
+
+func dumpPool(name string, lb balancer.LoadBalancer) poolDump {
+	pool := poolDump{Name: name}
+	for _, b := range lb.GetBackends() {
+		pool.Backends = append(pool.Backends, backendDump{
+			URL:            b.URL.String(),
+			Labels:         b.Labels,
+			Alive:          b.IsAlive(),
+			Draining:       b.IsDraining(),
+			Ejected:        b.IsEjected(),
+			Weight:         b.Weight(),
+			Active:         atomic.LoadInt64(&b.ActiveConnections),
+			CircuitBreaker: b.CircuitBreaker.State(),
+		})
+	}
+	return pool
+}
+
+func dumpState() {
+	mu.RLock()
+	pools := []poolDump{dumpPool("default", globalLB)}
+	for _, rt := range routes {
+		pools = append(pools, dumpPool(rt.namespace(), rt.lb))
+	}
+	algorithm := currentCfg.Algorithm
+	limiter := rateLimiter
+	mu.RUnlock()
+
+	inflightMu.Lock()
+	inflightCount := len(inflight)
+	inflightMu.Unlock()
+
+	state := map[string]interface{}{
+		"algorithm": algorithm,
+		"pools":     pools,
+		"inflight":  inflightCount,
+		"self":      features.Headroom(),
+	}
+	if limiter != nil {
+		tokens, capacity := limiter.Level()
+		state["rate_limiter"] = map[string]float64{"tokens": tokens, "capacity": capacity}
+	}
+	learners := make(map[string]balancer.ConvergenceStats)
+	for namespace, ql := range qLearners() {
+		if namespace == "" {
+			namespace = "default"
+		}
+		learners[namespace] = ql.Convergence()
+	}
+	if len(learners) > 0 {
+		state["qlearning"] = learners
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("State dump failed: %v", err)
+		return
+	}
+	log.Printf("State dump: %s", data)
+}
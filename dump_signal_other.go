@@ -0,0 +1,5 @@
+//go:build windows || plan9
+
+package main
+
+func watchDumpSignal() {}
@@ -0,0 +1,65 @@
+package features
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type AuthConfig struct {
+	Header  string
+	APIKeys []string
+	Bypass  []string
+}
+
+func isBypassed(path string, rules []string) bool {
+	for _, rule := range rules {
+		if strings.HasSuffix(rule, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(rule, "*")) {
+				return true
+			}
+		} else if path == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func validKey(key string, keys []string) bool {
+	if key == "" {
+		return false
+	}
+	valid := false
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func AuthMiddleware(cfg AuthConfig) Middleware {
+	header := cfg.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isBypassed(r.URL.Path, cfg.Bypass) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := r.Header.Get(header)
+			if key == "" {
+				key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			}
+			if !validKey(key, cfg.APIKeys) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package features
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type BlocklistConfig struct {
+	Sources     []string
+	Interval    time.Duration
+	Action      string
+	TarpitDelay time.Duration
+}
+
+type ipRange struct {
+	start uint32
+	end   uint32
+}
+
+type blocklistSet struct {
+	v4      []ipRange
+	v6      []*net.IPNet
+	entries int
+}
+
+type sourceStatus struct {
+	Entries     int        `json:"entries"`
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+type Blocklist struct {
+	cfg     BlocklistConfig
+	client  *http.Client
+	mu      sync.RWMutex
+	set     *blocklistSet
+	nets    map[string][]*net.IPNet
+	status  map[string]*sourceStatus
+	matches uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewBlocklist(cfg BlocklistConfig) *Blocklist {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Minute
+	}
+	if cfg.Action == "" {
+		cfg.Action = "reject"
+	}
+	if cfg.TarpitDelay <= 0 {
+		cfg.TarpitDelay = 10 * time.Second
+	}
+	return &Blocklist{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+		set:    &blocklistSet{},
+		nets:   make(map[string][]*net.IPNet),
+		status: make(map[string]*sourceStatus),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (bl *Blocklist) Start() error {
+	bl.Refresh()
+	go func() {
+		defer close(bl.done)
+		ticker := time.NewTicker(bl.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bl.Refresh()
+			case <-bl.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (bl *Blocklist) Stop() {
+	close(bl.stop)
+	<-bl.done
+}
+
+func (bl *Blocklist) Refresh() {
+	for _, src := range bl.cfg.Sources {
+		nets, err := bl.fetch(src)
+
+		bl.mu.Lock()
+		st, ok := bl.status[src]
+		if !ok {
+			st = &sourceStatus{}
+			bl.status[src] = st
+		}
+		if err != nil {
+			st.LastError = err.Error()
+			log.Printf("Blocklist refresh from %s failed, keeping %d previous entries: %v", src, st.Entries, err)
+		} else {
+			bl.nets[src] = nets
+			st.Entries = len(nets)
+			now := time.Now()
+			st.LastRefresh = &now
+			st.LastError = ""
+		}
+		bl.mu.Unlock()
+	}
+
+	bl.mu.Lock()
+	var all []*net.IPNet
+	for _, nets := range bl.nets {
+		all = append(all, nets...)
+	}
+	bl.set = buildBlocklistSet(all)
+	bl.mu.Unlock()
+	log.Printf("Blocklist loaded: %d entries from %d source(s)", len(all), len(bl.cfg.Sources))
+}
+
+func (bl *Blocklist) fetch(src string) ([]*net.IPNet, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := bl.client.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+	return parseBlocklist(r)
+}
+
+func parseBlocklist(r io.Reader) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry := fields[0]
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				continue
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		if _, n, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets, scanner.Err()
+}
+
+func buildBlocklistSet(nets []*net.IPNet) *blocklistSet {
+	set := &blocklistSet{entries: len(nets)}
+	for _, n := range nets {
+		if ip4 := n.IP.To4(); ip4 != nil && len(n.Mask) == net.IPv6len {
+			if ones, _ := n.Mask.Size(); ones >= 96 {
+				n = &net.IPNet{IP: ip4, Mask: net.CIDRMask(ones-96, 32)}
+			} else {
+				log.Printf("Blocklist entry %s is shorter than /96 and only matches IPv6 clients", n)
+			}
+		}
+		if ip4 := n.IP.To4(); ip4 != nil && len(n.Mask) == net.IPv4len {
+			start := binary.BigEndian.Uint32(ip4)
+			end := start | ^binary.BigEndian.Uint32(n.Mask)
+			set.v4 = append(set.v4, ipRange{start, end})
+		} else {
+			set.v6 = append(set.v6, n)
+		}
+	}
+	sort.Slice(set.v4, func(i, j int) bool { return set.v4[i].start < set.v4[j].start })
+
+	merged := set.v4[:0]
+	for _, rg := range set.v4 {
+		if n := len(merged); n > 0 && (rg.start <= merged[n-1].end || rg.start == merged[n-1].end+1) {
+			if rg.end > merged[n-1].end {
+				merged[n-1].end = rg.end
+			}
+			continue
+		}
+		merged = append(merged, rg)
+	}
+	set.v4 = merged
+	return set
+}
+
+func (s *blocklistSet) contains(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		v := binary.BigEndian.Uint32(ip4)
+		i := sort.Search(len(s.v4), func(i int) bool { return s.v4[i].end >= v })
+		return i < len(s.v4) && s.v4[i].start <= v
+	}
+	for _, n := range s.v6 {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bl *Blocklist) Blocked(remoteAddr string) bool {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	bl.mu.RLock()
+	set := bl.set
+	bl.mu.RUnlock()
+	return set.contains(ip)
+}
+
+func (bl *Blocklist) Snapshot() interface{} {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	sources := make(map[string]sourceStatus, len(bl.status))
+	for src, st := range bl.status {
+		sources[src] = *st
+	}
+	return map[string]interface{}{
+		"entries": bl.set.entries,
+		"matches": atomic.LoadUint64(&bl.matches),
+		"action":  bl.cfg.Action,
+		"sources": sources,
+	}
+}
+
+func BlocklistMiddleware(bl *Blocklist) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !bl.Blocked(r.RemoteAddr) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			atomic.AddUint64(&bl.matches, 1)
+			atomic.AddUint64(&globalMetrics.Blocked, 1)
+
+			if bl.cfg.Action == "tarpit" {
+				timer := time.NewTimer(bl.cfg.TarpitDelay)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				}
+			}
+			RespondBlocked(w, r)
+		})
+	}
+}
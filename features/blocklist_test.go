@@ -0,0 +1,97 @@
+package features
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustParseBlocklist(t *testing.T, entries ...string) []*net.IPNet {
+	nets, err := parseBlocklist(strings.NewReader(strings.Join(entries, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nets
+}
+
+func TestBlocklistMergesRanges(t *testing.T) {
+	set := buildBlocklistSet(mustParseBlocklist(t,
+		"10.0.0.0/24",
+		"10.0.1.0/24 # adjacent",
+		"10.0.0.128/25 ; contained",
+		"192.168.1.10",
+		"192.168.1.11",
+		"255.255.255.0/24",
+		"255.255.255.255",
+		"2001:db8::/32",
+		"::ffff:172.16.0.0/108",
+		"::ffff:172.31.0.1",
+	))
+
+	if len(set.v4) != 4 {
+		t.Fatalf("merged into %d ranges, want 4: %v", len(set.v4), set.v4)
+	}
+	if set.entries != 10 {
+		t.Fatalf("entries = %d, want 10", set.entries)
+	}
+
+	cases := map[string]bool{
+		"10.0.0.1":        true,
+		"10.0.1.255":      true,
+		"10.0.2.0":        false,
+		"9.255.255.255":   false,
+		"192.168.1.10":    true,
+		"192.168.1.11":    true,
+		"192.168.1.12":    false,
+		"255.255.255.255": true,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"172.16.5.5":      true,
+		"172.31.0.1":      true,
+		"172.32.0.1":      false,
+	}
+	for ip, want := range cases {
+		if got := set.contains(net.ParseIP(ip)); got != want {
+			t.Errorf("contains(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestBlocklistRefreshWhileMatching(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(src, []byte("10.0.0.0/24\n10.0.1.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bl := NewBlocklist(BlocklistConfig{Sources: []string{src}, Interval: time.Hour})
+	bl.Refresh()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if !bl.Blocked("10.0.1.7:1234") {
+					t.Error("address in the blocklist was not blocked during refresh")
+					return
+				}
+				bl.Snapshot()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		bl.Refresh()
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,130 @@
+package features
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type CanaryController struct {
+	percent      float64
+	step         float64
+	max          float64
+	bakePeriod   time.Duration
+	maxErrorRate float64
+	maxLatency   time.Duration
+	requests     int64
+	errors       int64
+	totalLatency time.Duration
+	rolledBack   bool
+	started      bool
+	stopped      bool
+	stop         chan struct{}
+	done         chan struct{}
+	mu           sync.Mutex
+}
+
+func NewCanaryController(initial, step, max float64, bakePeriod time.Duration, maxErrorRate float64, maxLatency time.Duration) *CanaryController {
+	return &CanaryController{
+		percent:      initial,
+		step:         step,
+		max:          max,
+		bakePeriod:   bakePeriod,
+		maxErrorRate: maxErrorRate,
+		maxLatency:   maxLatency,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+func (c *CanaryController) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return nil
+	}
+	c.started = true
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.bakePeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.evaluate()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *CanaryController) Stop() {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return
+	}
+	c.stopped = true
+	started := c.started
+	close(c.stop)
+	c.mu.Unlock()
+	if started {
+		<-c.done
+	}
+}
+
+func (c *CanaryController) ShouldRoute() bool {
+	c.mu.Lock()
+	percent := c.percent
+	c.mu.Unlock()
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+func (c *CanaryController) Percent() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.percent
+}
+
+func (c *CanaryController) Record(duration time.Duration, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	c.totalLatency += duration
+	if failed {
+		c.errors++
+	}
+}
+
+func (c *CanaryController) evaluate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	requests, errors, totalLatency := c.requests, c.errors, c.totalLatency
+	c.requests, c.errors, c.totalLatency = 0, 0, 0
+
+	if c.rolledBack || requests == 0 {
+		return
+	}
+
+	errorRate := float64(errors) / float64(requests)
+	avgLatency := totalLatency / time.Duration(requests)
+
+	if errorRate > c.maxErrorRate || (c.maxLatency > 0 && avgLatency > c.maxLatency) {
+		c.percent = 0
+		c.rolledBack = true
+		log.Printf("Canary rolled back: error rate %.4f, avg latency %v", errorRate, avgLatency)
+		return
+	}
+
+	if c.percent < c.max {
+		c.percent += c.step
+		if c.percent > c.max {
+			c.percent = c.max
+		}
+		log.Printf("Canary promoted to %.1f%% (error rate %.4f, avg latency %v)", c.percent, errorRate, avgLatency)
+	}
+}
@@ -0,0 +1,274 @@
+package features
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+var defaultRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+type CaptureRule struct {
+	Host   string
+	Prefix string
+	Header string
+	Value  string
+}
+
+type CaptureConfig struct {
+	File          string
+	MaxBody       int64
+	Rules         []CaptureRule
+	RedactHeaders []string
+	RedactFields  []string
+	RedactRegexps []*regexp.Regexp
+}
+
+type CaptureRecord struct {
+	Time            time.Time           `json:"time"`
+	RequestID       string              `json:"request_id,omitempty"`
+	Method          string              `json:"method"`
+	Host            string              `json:"host"`
+	URI             string              `json:"uri"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	RequestBody     string              `json:"request_body,omitempty"`
+	RequestTrunc    bool                `json:"request_truncated,omitempty"`
+	Status          int                 `json:"status"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	ResponseBody    string              `json:"response_body,omitempty"`
+	ResponseTrunc   bool                `json:"response_truncated,omitempty"`
+	DurationMs      float64             `json:"duration_ms"`
+}
+
+type PayloadCapture struct {
+	cfg     CaptureConfig
+	headers map[string]bool
+	fields  []*regexp.Regexp
+	records chan CaptureRecord
+	mu      sync.RWMutex
+	closed  bool
+	out     io.WriteCloser
+	done    chan struct{}
+}
+
+func NewPayloadCapture(cfg CaptureConfig) (*PayloadCapture, error) {
+	if cfg.MaxBody <= 0 {
+		cfg.MaxBody = 4096
+	}
+	if len(cfg.RedactHeaders) == 0 {
+		cfg.RedactHeaders = defaultRedactHeaders
+	}
+
+	var out io.WriteCloser = os.Stderr
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	pc := &PayloadCapture{
+		cfg:     cfg,
+		headers: make(map[string]bool),
+		records: make(chan CaptureRecord, 256),
+		out:     out,
+		done:    make(chan struct{}),
+	}
+	for _, h := range cfg.RedactHeaders {
+		pc.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, f := range cfg.RedactFields {
+		pc.fields = append(pc.fields, regexp.MustCompile(`(?i)("`+regexp.QuoteMeta(f)+`"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`))
+	}
+	go pc.write()
+	return pc, nil
+}
+
+func (pc *PayloadCapture) write() {
+	defer close(pc.done)
+	enc := json.NewEncoder(pc.out)
+	enc.SetEscapeHTML(false)
+	for rec := range pc.records {
+		if err := enc.Encode(rec); err != nil {
+			log.Printf("Payload capture write failed: %v", err)
+		}
+	}
+}
+
+func (pc *PayloadCapture) enqueue(rec CaptureRecord) {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	if pc.closed {
+		return
+	}
+	select {
+	case pc.records <- rec:
+	default:
+		log.Printf("Payload capture queue full, dropping record for %s %s", rec.Method, rec.URI)
+	}
+}
+
+func (pc *PayloadCapture) Close() {
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return
+	}
+	pc.closed = true
+	close(pc.records)
+	pc.mu.Unlock()
+
+	<-pc.done
+	if pc.out != os.Stderr {
+		pc.out.Close()
+	}
+}
+
+func (pc *PayloadCapture) matches(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, rule := range pc.cfg.Rules {
+		if rule.Host != "" && !strings.EqualFold(rule.Host, host) {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, rule.Prefix) {
+			continue
+		}
+		if rule.Header != "" {
+			v := r.Header.Get(rule.Header)
+			if v == "" || rule.Value != "" && v != rule.Value {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (pc *PayloadCapture) redactHeaders(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for k, v := range h {
+		if pc.headers[http.CanonicalHeaderKey(k)] {
+			out[k] = []string{redacted}
+			continue
+		}
+		out[k] = append([]string{}, v...)
+	}
+	return out
+}
+
+func (pc *PayloadCapture) redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	for _, re := range pc.fields {
+		body = re.ReplaceAll(body, []byte(`${1}"`+redacted+`"`))
+	}
+	for _, re := range pc.cfg.RedactRegexps {
+		body = re.ReplaceAll(body, []byte(redacted))
+	}
+	return string(body)
+}
+
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if remaining := c.limit - int64(c.buf.Len()); remaining > 0 {
+		if int64(len(p)) > remaining {
+			c.buf.Write(p[:remaining])
+			c.truncated = true
+		} else {
+			c.buf.Write(p)
+		}
+	} else if len(p) > 0 {
+		c.truncated = true
+	}
+	return len(p), nil
+}
+
+type captureBody struct {
+	io.Reader
+	io.Closer
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   *cappedBuffer
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *captureWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func CaptureMiddleware(pc *PayloadCapture) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !pc.matches(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reqBody := &cappedBuffer{limit: pc.cfg.MaxBody}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = captureBody{io.TeeReader(r.Body, reqBody), r.Body}
+			}
+			reqHeaders := pc.redactHeaders(r.Header)
+			cw := &captureWriter{ResponseWriter: w, body: &cappedBuffer{limit: pc.cfg.MaxBody}}
+
+			start := time.Now()
+			next.ServeHTTP(cw, r)
+
+			rec := CaptureRecord{
+				Time:            start,
+				RequestID:       w.Header().Get("X-Request-ID"),
+				Method:          r.Method,
+				Host:            r.Host,
+				URI:             r.URL.RequestURI(),
+				RequestHeaders:  reqHeaders,
+				RequestBody:     pc.redactBody(reqBody.buf.Bytes()),
+				RequestTrunc:    reqBody.truncated,
+				Status:          cw.status,
+				ResponseHeaders: pc.redactHeaders(w.Header()),
+				ResponseBody:    pc.redactBody(cw.body.buf.Bytes()),
+				ResponseTrunc:   cw.body.truncated,
+				DurationMs:      float64(time.Since(start)) / float64(time.Millisecond),
+			}
+			pc.enqueue(rec)
+		})
+	}
+}
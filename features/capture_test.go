@@ -0,0 +1,36 @@
+package features
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCaptureAfterClose(t *testing.T) {
+	pc, err := NewPayloadCapture(CaptureConfig{
+		File:  filepath.Join(t.TempDir(), "capture.jsonl"),
+		Rules: []CaptureRule{{Prefix: "/"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := CaptureMiddleware(pc)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/capture", nil))
+			}
+		}()
+	}
+	pc.Close()
+	wg.Wait()
+	pc.Close()
+}
@@ -10,6 +10,7 @@ type CircuitBreaker struct {
 	threshold    int
 	timeout      time.Duration
 	lastFailedAt time.Time
+	recoveredAt  time.Time
 	mu           sync.RWMutex
 }
 
@@ -27,6 +28,7 @@ func (cb *CircuitBreaker) Allow() bool {
 	if cb.failures >= cb.threshold {
 		if time.Since(cb.lastFailedAt) > cb.timeout {
 			cb.failures = cb.threshold - 1
+			cb.recoveredAt = time.Now()
 			return true
 		}
 		return false
@@ -46,3 +48,22 @@ func (cb *CircuitBreaker) RecordFailure() {
 	cb.failures++
 	cb.lastFailedAt = time.Now()
 }
+
+func (cb *CircuitBreaker) RecoveredAt() time.Time {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.recoveredAt
+}
+
+func (cb *CircuitBreaker) State() string {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	switch {
+	case cb.failures < cb.threshold:
+		return "closed"
+	case time.Since(cb.lastFailedAt) > cb.timeout:
+		return "half-open"
+	}
+	return "open"
+}
@@ -0,0 +1,104 @@
+package features
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type connBucket struct {
+	limiter  *RateLimiter
+	lastSeen time.Time
+}
+
+type ConnLimitListener struct {
+	net.Listener
+	rate      float64
+	burst     float64
+	buckets   map[string]*connBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+func NewConnLimitListener(ln net.Listener, rate, burst float64) *ConnLimitListener {
+	return &ConnLimitListener{
+		Listener:  ln,
+		rate:      rate,
+		burst:     burst,
+		buckets:   make(map[string]*connBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *ConnLimitListener) allow(addr net.Addr) bool {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > time.Minute {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &connBucket{limiter: NewRateLimiter(l.burst, l.rate)}
+		l.buckets[ip] = b
+	}
+	b.lastSeen = now
+	return b.limiter.Allow()
+}
+
+func (l *ConnLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.allow(conn.RemoteAddr()) {
+			return conn, nil
+		}
+		atomic.AddUint64(&globalMetrics.ConnRejected, 1)
+		conn.Close()
+	}
+}
+
+func (l *ConnLimitListener) Buckets() map[string]BucketState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	states := make(map[string]BucketState, len(l.buckets))
+	for ip, b := range l.buckets {
+		if time.Since(b.lastSeen) <= time.Minute {
+			states[ip] = b.limiter.State()
+		}
+	}
+	return states
+}
+
+func (l *ConnLimitListener) RestoreBuckets(states map[string]BucketState) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	restored := 0
+	for ip, st := range states {
+		if time.Since(st.Updated) > time.Minute {
+			continue
+		}
+		b := &connBucket{limiter: NewRateLimiter(l.burst, l.rate), lastSeen: st.Updated}
+		b.limiter.Restore(st)
+		l.buckets[ip] = b
+		restored++
+	}
+	return restored
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package features
+
+import "time"
+
+func processCPUTime() time.Duration {
+	return 0
+}
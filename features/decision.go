@@ -0,0 +1,86 @@
+package features
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+var decisionBoundsUs = []int64{1, 5, 10, 50, 100, 500, 1000, 5000}
+
+type decisionHistogram struct {
+	count   uint64
+	totalUs int64
+	buckets [9]uint64
+}
+
+var (
+	decisionLatency   = make(map[string]*decisionHistogram)
+	decisionLatencyMu sync.Mutex
+	decisionFallbacks = make(map[string]uint64)
+)
+
+func RecordDecision(algorithm string, d time.Duration) {
+	us := d.Microseconds()
+	bin := len(decisionBoundsUs)
+	for i, bound := range decisionBoundsUs {
+		if us <= bound {
+			bin = i
+			break
+		}
+	}
+
+	decisionLatencyMu.Lock()
+	defer decisionLatencyMu.Unlock()
+	h, ok := decisionLatency[algorithm]
+	if !ok {
+		h = &decisionHistogram{}
+		decisionLatency[algorithm] = h
+	}
+	h.count++
+	h.totalUs += us
+	h.buckets[bin]++
+}
+
+func RecordDecisionFallback(algorithm string) {
+	decisionLatencyMu.Lock()
+	decisionFallbacks[algorithm]++
+	decisionLatencyMu.Unlock()
+}
+
+func decisionLatencyJSON() string {
+	type histogramJSON struct {
+		Count     uint64            `json:"count"`
+		AvgUs     int64             `json:"avg_us"`
+		Buckets   map[string]uint64 `json:"buckets"`
+		Fallbacks uint64            `json:"budget_fallbacks"`
+	}
+
+	decisionLatencyMu.Lock()
+	out := make(map[string]histogramJSON, len(decisionLatency))
+	for algorithm, h := range decisionLatency {
+		hj := histogramJSON{
+			Count:     h.count,
+			Buckets:   make(map[string]uint64, len(h.buckets)),
+			Fallbacks: decisionFallbacks[algorithm],
+		}
+		if h.count > 0 {
+			hj.AvgUs = h.totalUs / int64(h.count)
+		}
+		for i, n := range h.buckets {
+			label := "+Inf"
+			if i < len(decisionBoundsUs) {
+				label = "le_" + time.Duration(decisionBoundsUs[i]*int64(time.Microsecond)).String()
+			}
+			hj.Buckets[label] = n
+		}
+		out[algorithm] = hj
+	}
+	decisionLatencyMu.Unlock()
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
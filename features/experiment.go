@@ -0,0 +1,138 @@
+package features
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Variant struct {
+	Name    string
+	Percent int
+}
+
+type Experiment struct {
+	Name     string
+	Prefix   string
+	Source   string
+	Key      string
+	Variants []Variant
+}
+
+type variantStats struct {
+	Requests  uint64 `json:"requests"`
+	Errors    uint64 `json:"errors"`
+	latencyMs uint64
+	AvgMs     uint64 `json:"avg_latency_ms"`
+}
+
+var (
+	experimentStats   = make(map[string]map[string]*variantStats)
+	experimentStatsMu sync.Mutex
+)
+
+func (e Experiment) clientKey(r *http.Request) string {
+	switch e.Source {
+	case "header":
+		if v := r.Header.Get(e.Key); v != "" {
+			return v
+		}
+	case "cookie":
+		if c, err := r.Cookie(e.Key); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return ip
+}
+
+func (e Experiment) Assign(r *http.Request) string {
+	bucket := int(crc32.ChecksumIEEE([]byte(e.Name+":"+e.clientKey(r))) % 100)
+	cumulative := 0
+	for _, v := range e.Variants {
+		cumulative += v.Percent
+		if bucket < cumulative {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+func recordVariant(experiment, variant string, d time.Duration, status int) {
+	experimentStatsMu.Lock()
+	defer experimentStatsMu.Unlock()
+	variants, ok := experimentStats[experiment]
+	if !ok {
+		variants = make(map[string]*variantStats)
+		experimentStats[experiment] = variants
+	}
+	vs, ok := variants[variant]
+	if !ok {
+		vs = &variantStats{}
+		variants[variant] = vs
+	}
+	vs.Requests++
+	vs.latencyMs += uint64(d.Milliseconds())
+	if status >= 500 {
+		vs.Errors++
+	}
+}
+
+func experimentsJSON() string {
+	experimentStatsMu.Lock()
+	for _, variants := range experimentStats {
+		for _, vs := range variants {
+			if vs.Requests > 0 {
+				vs.AvgMs = vs.latencyMs / vs.Requests
+			}
+		}
+	}
+	data, err := json.Marshal(experimentStats)
+	experimentStatsMu.Unlock()
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+func ExperimentMiddleware(experiments []Experiment) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del("X-Experiment")
+
+			type assignment struct{ experiment, variant string }
+			var assigned []assignment
+			var labels []string
+			for _, e := range experiments {
+				if !strings.HasPrefix(r.URL.Path, e.Prefix) {
+					continue
+				}
+				if v := e.Assign(r); v != "" {
+					assigned = append(assigned, assignment{e.Name, v})
+					labels = append(labels, e.Name+"="+v)
+				}
+			}
+			if len(assigned) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Header.Set("X-Experiment", strings.Join(labels, ", "))
+
+			tw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(tw, r)
+			duration := time.Since(start)
+			for _, a := range assigned {
+				recordVariant(a.experiment, a.variant, duration, tw.status)
+			}
+		})
+	}
+}
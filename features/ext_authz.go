@@ -0,0 +1,194 @@
+package features
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ExtAuthzConfig struct {
+	URL             string
+	Timeout         time.Duration
+	Prefixes        []string
+	ForwardHeaders  []string
+	UpstreamHeaders []string
+	FailOpen        bool
+	CacheTTL        time.Duration
+	MaxCacheEntries int
+}
+
+type authzDecision struct {
+	allowed bool
+	status  int
+	body    []byte
+	headers http.Header
+	expires time.Time
+}
+
+type ExtAuthz struct {
+	cfg    ExtAuthzConfig
+	client *http.Client
+	cache  map[string]*authzDecision
+	mu     sync.Mutex
+}
+
+func NewExtAuthz(cfg ExtAuthzConfig) *ExtAuthz {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	if len(cfg.ForwardHeaders) == 0 {
+		cfg.ForwardHeaders = []string{"Authorization", "Cookie"}
+	}
+	if cfg.MaxCacheEntries <= 0 {
+		cfg.MaxCacheEntries = 10000
+	}
+	return &ExtAuthz{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		cache:  make(map[string]*authzDecision),
+	}
+}
+
+func (a *ExtAuthz) matches(path string) bool {
+	if len(a.cfg.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range a.cfg.Prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ExtAuthz) cacheKey(r *http.Request) string {
+	var sb strings.Builder
+	sb.WriteString(r.Method)
+	sb.WriteString(" ")
+	sb.WriteString(r.URL.RequestURI())
+	for _, h := range a.cfg.ForwardHeaders {
+		sb.WriteString("\n")
+		sb.WriteString(r.Header.Get(h))
+	}
+	return sb.String()
+}
+
+func (a *ExtAuthz) cached(key string) (*authzDecision, bool) {
+	if a.cfg.CacheTTL <= 0 {
+		return nil, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	d, ok := a.cache[key]
+	if !ok || time.Now().After(d.expires) {
+		return nil, false
+	}
+	return d, true
+}
+
+func (a *ExtAuthz) store(key string, d *authzDecision) {
+	if a.cfg.CacheTTL <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.cache) >= a.cfg.MaxCacheEntries {
+		now := time.Now()
+		for k, v := range a.cache {
+			if now.After(v.expires) {
+				delete(a.cache, k)
+			}
+		}
+		for k := range a.cache {
+			if len(a.cache) < a.cfg.MaxCacheEntries {
+				break
+			}
+			delete(a.cache, k)
+		}
+	}
+	d.expires = time.Now().Add(a.cfg.CacheTTL)
+	a.cache[key] = d
+}
+
+func (a *ExtAuthz) check(r *http.Request) (*authzDecision, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, a.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	for _, h := range a.cfg.ForwardHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	d := &authzDecision{
+		allowed: resp.StatusCode >= 200 && resp.StatusCode < 300,
+		status:  resp.StatusCode,
+		body:    body,
+		headers: make(http.Header),
+	}
+	for _, h := range a.cfg.UpstreamHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			d.headers.Set(h, v)
+		}
+	}
+	return d, nil
+}
+
+func (a *ExtAuthz) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.matches(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := a.cacheKey(r)
+		d, ok := a.cached(key)
+		if !ok {
+			var err error
+			d, err = a.check(r)
+			if err != nil {
+				log.Printf("External authorization failed for %s: %v", r.URL.Path, err)
+				if a.cfg.FailOpen {
+					next.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if d.allowed || d.status == http.StatusUnauthorized || d.status == http.StatusForbidden {
+				a.store(key, d)
+			}
+		}
+
+		if !d.allowed {
+			status := d.status
+			if status < 400 {
+				status = http.StatusForbidden
+			}
+			w.WriteHeader(status)
+			w.Write(d.body)
+			return
+		}
+
+		for h, v := range d.headers {
+			r.Header[h] = v
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,167 @@
+package features
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+var forwardedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+}
+
+type HeaderRule struct {
+	Prefix string
+	Allow  []string
+}
+
+type HeaderPolicy struct {
+	Strip          []string
+	TrustedProxies []*net.IPNet
+	Routes         []HeaderRule
+}
+
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			if ip := net.ParseIP(e); ip != nil && ip.To4() != nil {
+				e += "/32"
+			} else {
+				e += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func ipInNets(remoteAddr string, nets []*net.IPNet) bool {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func HeaderPolicyMiddleware(policy HeaderPolicy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, h := range policy.Strip {
+				r.Header.Del(h)
+			}
+
+			if !ipInNets(r.RemoteAddr, policy.TrustedProxies) {
+				for _, h := range forwardedHeaders {
+					r.Header.Del(h)
+				}
+			}
+
+			for _, rule := range policy.Routes {
+				if !strings.HasPrefix(r.URL.Path, rule.Prefix) {
+					continue
+				}
+				allowed := make(map[string]bool, len(rule.Allow))
+				for _, h := range rule.Allow {
+					allowed[http.CanonicalHeaderKey(h)] = true
+				}
+				for h := range r.Header {
+					if !allowed[h] {
+						r.Header.Del(h)
+					}
+				}
+				break
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type ResponseHeaderRule struct {
+	Prefix string
+	Strip  []string
+	Set    map[string]string
+}
+
+type ResponseHeaderPolicy struct {
+	Strip  []string
+	Set    map[string]string
+	Routes []ResponseHeaderRule
+}
+
+type headerScrubWriter struct {
+	http.ResponseWriter
+	strip       []string
+	set         map[string]string
+	wroteHeader bool
+}
+
+func (w *headerScrubWriter) scrub() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	for _, h := range w.strip {
+		w.Header().Del(h)
+	}
+	for h, v := range w.set {
+		w.Header().Set(h, v)
+	}
+}
+
+func (w *headerScrubWriter) WriteHeader(code int) {
+	w.scrub()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerScrubWriter) Write(b []byte) (int, error) {
+	w.scrub()
+	return w.ResponseWriter.Write(b)
+}
+
+func ResponseHeaderMiddleware(policy ResponseHeaderPolicy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &headerScrubWriter{
+				ResponseWriter: w,
+				strip:          append([]string{}, policy.Strip...),
+				set:            make(map[string]string, len(policy.Set)),
+			}
+			for h, v := range policy.Set {
+				sw.set[h] = v
+			}
+
+			for _, rule := range policy.Routes {
+				if !strings.HasPrefix(r.URL.Path, rule.Prefix) {
+					continue
+				}
+				sw.strip = append(sw.strip, rule.Strip...)
+				for h, v := range rule.Set {
+					sw.set[h] = v
+				}
+				break
+			}
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
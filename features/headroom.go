@@ -0,0 +1,121 @@
+package features
+
+import (
+	"encoding/json"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type HeadroomSnapshot struct {
+	CPUUtilization float64 `json:"cpu_utilization"`
+	Goroutines     int     `json:"goroutines"`
+	AvgOverheadUs  int64   `json:"avg_overhead_us"`
+	Headroom       float64 `json:"headroom"`
+	Saturated      bool    `json:"saturated"`
+}
+
+var (
+	overheadTotalNs  int64
+	overheadCount    int64
+	headroomSnapshot = HeadroomSnapshot{Headroom: 1}
+	headroomMu       sync.RWMutex
+)
+
+func RecordOverhead(d time.Duration) {
+	atomic.AddInt64(&overheadTotalNs, int64(d))
+	atomic.AddInt64(&overheadCount, 1)
+}
+
+type HeadroomEstimator struct {
+	interval    time.Duration
+	warnBelow   float64
+	maxOverhead time.Duration
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func NewHeadroomEstimator(interval time.Duration, warnBelow float64, maxOverhead time.Duration) *HeadroomEstimator {
+	return &HeadroomEstimator{
+		interval:    interval,
+		warnBelow:   warnBelow,
+		maxOverhead: maxOverhead,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (e *HeadroomEstimator) Start() error {
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		lastCPU := processCPUTime()
+		lastWall := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-e.stop:
+				return
+			}
+			cpu := processCPUTime()
+			now := time.Now()
+			capacity := float64(now.Sub(lastWall)) * float64(runtime.NumCPU())
+			util := 0.0
+			if capacity > 0 {
+				util = float64(cpu-lastCPU) / capacity
+			}
+			lastCPU, lastWall = cpu, now
+
+			total := atomic.SwapInt64(&overheadTotalNs, 0)
+			count := atomic.SwapInt64(&overheadCount, 0)
+			var avgOverhead time.Duration
+			if count > 0 {
+				avgOverhead = time.Duration(total / count)
+			}
+
+			snap := HeadroomSnapshot{
+				CPUUtilization: util,
+				Goroutines:     runtime.NumGoroutine(),
+				AvgOverheadUs:  avgOverhead.Microseconds(),
+				Headroom:       1 - util,
+			}
+			if snap.Headroom < 0 {
+				snap.Headroom = 0
+			}
+			snap.Saturated = snap.Headroom < e.warnBelow || (e.maxOverhead > 0 && avgOverhead > e.maxOverhead)
+
+			if snap.Saturated {
+				log.Printf("Load balancer saturation warning: headroom %.2f, cpu %.2f, goroutines %d, avg overhead %v",
+					snap.Headroom, util, snap.Goroutines, avgOverhead)
+			}
+
+			headroomMu.Lock()
+			headroomSnapshot = snap
+			headroomMu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (e *HeadroomEstimator) Stop() {
+	close(e.stop)
+	<-e.done
+}
+
+func Headroom() HeadroomSnapshot {
+	headroomMu.RLock()
+	defer headroomMu.RUnlock()
+	return headroomSnapshot
+}
+
+func headroomJSON() string {
+	data, err := json.Marshal(Headroom())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
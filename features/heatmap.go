@@ -0,0 +1,99 @@
+package features
+
+import (
+	"sync"
+	"time"
+)
+
+type HeatmapColumn struct {
+	Time     time.Time `json:"time"`
+	Requests uint64    `json:"requests"`
+	Errors   uint64    `json:"errors"`
+	Counts   []uint64  `json:"counts"`
+}
+
+var (
+	backendWindows   = make(map[string]*RollingStats)
+	backendWindowsMu sync.Mutex
+)
+
+func RecordBackendLatency(backend string, duration time.Duration, isError bool) {
+	backendWindowsMu.Lock()
+	rs, ok := backendWindows[backend]
+	if !ok {
+		rs = &RollingStats{}
+		backendWindows[backend] = rs
+	}
+	backendWindowsMu.Unlock()
+	rs.Record(time.Now(), duration, isError)
+}
+
+func HeatmapBoundsMs() []float64 {
+	return append([]float64{}, latencyBoundsMs...)
+}
+
+func BackendHeatmap(backend string, now time.Time, window, resolution time.Duration) []HeatmapColumn {
+	backendWindowsMu.Lock()
+	rs := backendWindows[backend]
+	backendWindowsMu.Unlock()
+	if rs == nil {
+		return nil
+	}
+	return rs.Heatmap(now, window, resolution)
+}
+
+func PruneBackendHeatmaps(keep map[string]bool) {
+	backendWindowsMu.Lock()
+	defer backendWindowsMu.Unlock()
+	for backend := range backendWindows {
+		if !keep[backend] {
+			delete(backendWindows, backend)
+		}
+	}
+}
+
+func HeatmapResolution(d time.Duration) time.Duration {
+	d = d.Truncate(windowBucketWidth)
+	if d < windowBucketWidth {
+		return windowBucketWidth
+	}
+	return d
+}
+
+func (rs *RollingStats) Heatmap(now time.Time, window, resolution time.Duration) []HeatmapColumn {
+	resolution = HeatmapResolution(resolution)
+	if window > windowBuckets*windowBucketWidth {
+		window = windowBuckets * windowBucketWidth
+	}
+	perColumn := int64(resolution / windowBucketWidth)
+	current := now.UnixNano() / int64(windowBucketWidth)
+	last := current - current%perColumn
+	first := last - (int64(window/resolution)-1)*perColumn
+	if first > last {
+		first = last
+	}
+
+	columns := make([]HeatmapColumn, (last-first)/perColumn+1)
+	for i := range columns {
+		columns[i] = HeatmapColumn{
+			Time:   time.Unix(0, (first+int64(i)*perColumn)*int64(windowBucketWidth)).UTC(),
+			Counts: make([]uint64, len(latencyBoundsMs)+1),
+		}
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for i := range rs.buckets {
+		b := &rs.buckets[i]
+		if b.slot < first || b.slot > current || b.requests == 0 {
+			continue
+		}
+		col := &columns[(b.slot-first)/perColumn]
+		col.Requests += b.requests
+		col.Errors += b.errors
+		for j, n := range b.latency {
+			col.Counts[j] += n
+		}
+	}
+	return columns
+}
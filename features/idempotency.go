@@ -0,0 +1,204 @@
+package features
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var defaultIdempotencyScope = []string{"Authorization", "X-API-Key"}
+
+type idempotentResponse struct {
+	key         string
+	fingerprint string
+	status      int
+	header      http.Header
+	body        []byte
+	pending     bool
+	expiresAt   time.Time
+}
+
+type IdempotencyCache struct {
+	ttl         time.Duration
+	maxEntries  int
+	maxBodySize int64
+	scope       []string
+	entries     map[string]*list.Element
+	order       *list.List
+	mu          sync.Mutex
+}
+
+func NewIdempotencyCache(ttl time.Duration, maxEntries int, maxBodySize int64) *IdempotencyCache {
+	return &IdempotencyCache{
+		ttl:         ttl,
+		maxEntries:  maxEntries,
+		maxBodySize: maxBodySize,
+		scope:       defaultIdempotencyScope,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+func (c *IdempotencyCache) SetScope(headers []string) {
+	if len(headers) > 0 {
+		c.scope = headers
+	}
+}
+
+func (c *IdempotencyCache) clientIdentity(r *http.Request) string {
+	h := sha256.New()
+	found := false
+	for _, name := range c.scope {
+		if v := r.Header.Get(name); v != "" {
+			io.WriteString(h, name+"="+v+"\n")
+			found = true
+		}
+	}
+	if !found {
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		io.WriteString(h, "ip="+ip)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *IdempotencyCache) begin(key, fingerprint string) (*idempotentResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*idempotentResponse)
+		if time.Now().Before(entry.expiresAt) {
+			return entry, false
+		}
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+
+	for c.order.Len() >= c.maxEntries {
+		oldest := c.order.Front()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*idempotentResponse).key)
+	}
+
+	entry := &idempotentResponse{key: key, fingerprint: fingerprint, pending: true, expiresAt: time.Now().Add(c.ttl)}
+	c.entries[key] = c.order.PushBack(entry)
+	return entry, true
+}
+
+func (c *IdempotencyCache) finish(entry *idempotentResponse, status int, header http.Header, body []byte, cacheable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !cacheable {
+		if el, ok := c.entries[entry.key]; ok && el.Value == entry {
+			c.order.Remove(el)
+			delete(c.entries, entry.key)
+		}
+		return
+	}
+	entry.status = status
+	entry.header = header
+	entry.body = body
+	entry.pending = false
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	if rec.header == nil {
+		rec.status = code
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.header == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if int64(rec.body.Len()+len(b)) > rec.limit {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func IdempotencyMiddleware(cache *IdempotencyCache) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idemKey := r.Header.Get("Idempotency-Key")
+			if idemKey == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			payload, err := io.ReadAll(io.LimitReader(r.Body, cache.maxBodySize+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(payload), r.Body), r.Body}
+			if err != nil || int64(len(payload)) > cache.maxBodySize {
+				next.ServeHTTP(w, r)
+				return
+			}
+			sum := sha256.Sum256(payload)
+
+			key := r.Method + " " + r.URL.Path + " " + cache.clientIdentity(r) + " " + idemKey
+			entry, fresh := cache.begin(key, hex.EncodeToString(sum[:]))
+			if !fresh {
+				cache.mu.Lock()
+				pending, status, header, body := entry.pending, entry.status, entry.header, entry.body
+				mismatch := entry.fingerprint != hex.EncodeToString(sum[:])
+				cache.mu.Unlock()
+
+				if mismatch {
+					http.Error(w, "Idempotency-Key was already used with a different request payload", http.StatusUnprocessableEntity)
+					return
+				}
+				if pending {
+					http.Error(w, "A request with this Idempotency-Key is already in progress", http.StatusConflict)
+					return
+				}
+				for h, vals := range header {
+					w.Header()[h] = vals
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(status)
+				w.Write(body)
+				return
+			}
+
+			rec := &idempotencyRecorder{ResponseWriter: w, limit: cache.maxBodySize}
+			next.ServeHTTP(rec, r)
+
+			if rec.header == nil {
+				rec.status = http.StatusOK
+				rec.header = w.Header().Clone()
+			}
+			cacheable := !rec.overflow && rec.status < 500
+			cache.finish(entry, rec.status, rec.header, rec.body.Bytes(), cacheable)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package features
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func idempotentHandler(calls *int64) http.Handler {
+	cache := NewIdempotencyCache(time.Minute, 100, 1<<20)
+	return IdempotencyMiddleware(cache)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(calls, 1)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(strings.Repeat("x", int(n))))
+	}))
+}
+
+func idempotentRequest(key, auth, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	r.Header.Set("Idempotency-Key", key)
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	return r
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	var calls int64
+	h := idempotentHandler(&calls)
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, idempotentRequest("k1", "Bearer a", `{"n":1}`))
+	second := httptest.NewRecorder()
+	h.ServeHTTP(second, idempotentRequest("k1", "Bearer a", `{"n":1}`))
+
+	if calls != 1 {
+		t.Fatalf("backend called %d times, want 1", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Fatalf("replay = %d %q, want %d %q", second.Code, second.Body, first.Code, first.Body)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("replayed response is not marked")
+	}
+}
+
+func TestIdempotencyKeyScopedByClient(t *testing.T) {
+	var calls int64
+	h := idempotentHandler(&calls)
+
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k1", "Bearer a", `{}`))
+	other := httptest.NewRecorder()
+	h.ServeHTTP(other, idempotentRequest("k1", "Bearer b", `{}`))
+
+	if calls != 2 {
+		t.Fatalf("backend called %d times, want 2 (one per client)", calls)
+	}
+	if other.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatal("response of another client was replayed")
+	}
+}
+
+func TestIdempotencyKeyReusedWithDifferentPayload(t *testing.T) {
+	var calls int64
+	h := idempotentHandler(&calls)
+
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k1", "Bearer a", `{"amount":1}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, idempotentRequest("k1", "Bearer a", `{"amount":100}`))
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if calls != 1 {
+		t.Fatalf("backend called %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyConcurrentDuplicates(t *testing.T) {
+	var calls int64
+	h := idempotentHandler(&calls)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("shared", "Bearer a", `{}`))
+			h.ServeHTTP(httptest.NewRecorder(), idempotentRequest(strings.Repeat("k", i+1), "Bearer a", `{}`))
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 33 {
+		t.Fatalf("backend called %d times, want 33 (one per distinct key)", calls)
+	}
+}
@@ -0,0 +1,85 @@
+package features
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+type Hook struct {
+	Name       string
+	OnStart    func() error
+	OnReload   func() error
+	OnShutdown func(ctx context.Context) error
+}
+
+type Lifecycle struct {
+	mu      sync.Mutex
+	hooks   []Hook
+	started int
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+func (l *Lifecycle) Register(h Hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, h)
+}
+
+func (l *Lifecycle) Start() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.started < len(l.hooks) {
+		h := l.hooks[l.started]
+		if h.OnStart != nil {
+			if err := h.OnStart(); err != nil {
+				return fmt.Errorf("starting %s: %v", h.Name, err)
+			}
+		}
+		log.Printf("Lifecycle: started %s", h.Name)
+		l.started++
+	}
+	return nil
+}
+
+func (l *Lifecycle) Reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, h := range l.hooks[:l.started] {
+		if h.OnReload == nil {
+			continue
+		}
+		if err := h.OnReload(); err != nil {
+			log.Printf("Lifecycle: reload of %s failed: %v", h.Name, err)
+		}
+	}
+}
+
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for l.started > 0 {
+		l.started--
+		h := l.hooks[l.started]
+		if h.OnShutdown == nil {
+			continue
+		}
+		if err := h.OnShutdown(ctx); err != nil {
+			log.Printf("Lifecycle: shutdown of %s failed: %v", h.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Printf("Lifecycle: stopped %s", h.Name)
+	}
+	return firstErr
+}
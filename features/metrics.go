@@ -1,10 +1,20 @@
 package features
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -16,13 +26,77 @@ type Metrics struct {
 	Status3xx      uint64
 	Status4xx      uint64
 	Status5xx      uint64
+	RateWarnings   uint64
+	TracesSampled  uint64
+	TracesDropped  uint64
+	ConnRejected   uint64
+	ResponseBytes  uint64
+	TooLarge       uint64
+	Shed           uint64
+	Blocked        uint64
 }
 
 var globalMetrics = &Metrics{}
 
+var (
+	upstreamErrors   = make(map[string]map[string]uint64)
+	upstreamErrorsMu sync.Mutex
+)
+
+func ClassifyUpstreamError(err error) string {
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	case errors.As(err, &recordErr), errors.As(err, &unknownAuthErr), errors.As(err, &hostnameErr),
+		errors.As(err, &certInvalidErr), strings.Contains(err.Error(), "tls:"):
+		return "tls"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "client_canceled"
+	}
+	return "other"
+}
+
+func RecordUpstreamError(backend string, err error) string {
+	kind := ClassifyUpstreamError(err)
+
+	upstreamErrorsMu.Lock()
+	defer upstreamErrorsMu.Unlock()
+	counts, ok := upstreamErrors[backend]
+	if !ok {
+		counts = make(map[string]uint64)
+		upstreamErrors[backend] = counts
+	}
+	counts[kind]++
+	return kind
+}
+
+func upstreamErrorsJSON() string {
+	upstreamErrorsMu.Lock()
+	data, err := json.Marshal(upstreamErrors)
+	upstreamErrorsMu.Unlock()
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
 func RecordRequest(duration time.Duration, statusCode int) {
 	atomic.AddUint64(&globalMetrics.TotalRequests, 1)
 	atomic.AddUint64(&globalMetrics.TotalLatencyMs, uint64(duration.Milliseconds()))
+	globalWindows.Record(time.Now(), duration, statusCode >= 500)
 
 	if statusCode >= 200 && statusCode < 300 {
 		atomic.AddUint64(&globalMetrics.Status2xx, 1)
@@ -36,6 +110,44 @@ func RecordRequest(duration time.Duration, statusCode int) {
 	}
 }
 
+var (
+	metricsSources   = make(map[string]func() interface{})
+	metricsSourcesMu sync.RWMutex
+)
+
+func RegisterMetricsSource(name string, source func() interface{}) {
+	metricsSourcesMu.Lock()
+	metricsSources[name] = source
+	metricsSourcesMu.Unlock()
+}
+
+func metricsSourcesJSON() string {
+	metricsSourcesMu.RLock()
+	out := make(map[string]interface{}, len(metricsSources))
+	for name, source := range metricsSources {
+		out[name] = source()
+	}
+	metricsSourcesMu.RUnlock()
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+func RecordResponseBytes(n int64) {
+	atomic.AddUint64(&globalMetrics.ResponseBytes, uint64(n))
+}
+
+func RecordResponseTooLarge() {
+	atomic.AddUint64(&globalMetrics.TooLarge, 1)
+}
+
+func RecordRateLimitWarning() {
+	atomic.AddUint64(&globalMetrics.RateWarnings, 1)
+}
+
 func MetricsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -47,6 +159,14 @@ func MetricsHandler(w http.ResponseWriter, r *http.Request) {
 	s3xx := atomic.LoadUint64(&globalMetrics.Status3xx)
 	s4xx := atomic.LoadUint64(&globalMetrics.Status4xx)
 	s5xx := atomic.LoadUint64(&globalMetrics.Status5xx)
+	rateWarnings := atomic.LoadUint64(&globalMetrics.RateWarnings)
+	tracesSampled := atomic.LoadUint64(&globalMetrics.TracesSampled)
+	tracesDropped := atomic.LoadUint64(&globalMetrics.TracesDropped)
+	connRejected := atomic.LoadUint64(&globalMetrics.ConnRejected)
+	responseBytes := atomic.LoadUint64(&globalMetrics.ResponseBytes)
+	tooLarge := atomic.LoadUint64(&globalMetrics.TooLarge)
+	shed := atomic.LoadUint64(&globalMetrics.Shed)
+	blocked := atomic.LoadUint64(&globalMetrics.Blocked)
 
 	var avgLat uint64 = 0
 	if reqs > 0 {
@@ -60,8 +180,23 @@ func MetricsHandler(w http.ResponseWriter, r *http.Request) {
 		"status_2xx": %d,
 		"status_3xx": %d,
 		"status_4xx": %d,
-		"status_5xx": %d
-	}`, reqs, errs, avgLat, s2xx, s3xx, s4xx, s5xx)
+		"status_5xx": %d,
+		"rate_limit_warnings": %d,
+		"traces_sampled": %d,
+		"traces_dropped": %d,
+		"connections_rejected": %d,
+		"response_bytes": %d,
+		"responses_too_large": %d,
+		"requests_shed": %d,
+		"requests_blocked": %d,
+		"upstream_errors": %s,
+		"self": %s,
+		"windows": %s,
+		"decision_latency": %s,
+		"lb_time": %s,
+		"experiments": %s,
+		"sources": %s
+	}`, reqs, errs, avgLat, s2xx, s3xx, s4xx, s5xx, rateWarnings, tracesSampled, tracesDropped, connRejected, responseBytes, tooLarge, shed, blocked, upstreamErrorsJSON(), headroomJSON(), windowsJSON(), decisionLatencyJSON(), lbTimeJSON(), experimentsJSON(), metricsSourcesJSON())
 	w.Write([]byte(response))
 
 	log.Printf("Metrics: %s", response)
@@ -1,6 +1,7 @@
 package features
 
 import (
+	"log"
 	"sync"
 	"time"
 )
@@ -10,6 +11,8 @@ type RateLimiter struct {
 	capacity       float64
 	refillRate     float64
 	lastRefillTime time.Time
+	warnThreshold  float64
+	warning        bool
 	mu             sync.Mutex
 }
 
@@ -22,7 +25,18 @@ func NewRateLimiter(capacity float64, refillRate float64) *RateLimiter {
 	}
 }
 
+func (rl *RateLimiter) SetWarningThreshold(threshold float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.warnThreshold = threshold
+}
+
 func (rl *RateLimiter) Allow() bool {
+	allowed, _ := rl.AllowWithWarning()
+	return allowed
+}
+
+func (rl *RateLimiter) AllowWithWarning() (bool, float64) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
@@ -35,9 +49,62 @@ func (rl *RateLimiter) Allow() bool {
 	}
 	rl.lastRefillTime = now
 
-	if rl.tokens >= 1 {
-		rl.tokens--
-		return true
+	if rl.tokens < 1 {
+		return false, 0
+	}
+	rl.tokens--
+
+	if rl.warnThreshold <= 0 {
+		return true, 0
+	}
+
+	usage := 1 - rl.tokens/rl.capacity
+	if usage < rl.warnThreshold {
+		rl.warning = false
+		return true, 0
+	}
+	if !rl.warning {
+		rl.warning = true
+		RecordRateLimitWarning()
+		log.Printf("Rate limit warning: %.0f%% of burst capacity in use", usage*100)
+	}
+	return true, usage
+}
+
+func (rl *RateLimiter) Level() (float64, float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	tokens := rl.tokens + time.Since(rl.lastRefillTime).Seconds()*rl.refillRate
+	if tokens > rl.capacity {
+		tokens = rl.capacity
+	}
+	return tokens, rl.capacity
+}
+
+type BucketState struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+func (rl *RateLimiter) State() BucketState {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return BucketState{Tokens: rl.tokens, Updated: rl.lastRefillTime}
+}
+
+func (rl *RateLimiter) Restore(st BucketState) {
+	if st.Updated.IsZero() || st.Updated.After(time.Now()) {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.tokens = st.Tokens
+	if rl.tokens > rl.capacity {
+		rl.tokens = rl.capacity
+	}
+	if rl.tokens < 0 {
+		rl.tokens = 0
 	}
-	return false
+	rl.lastRefillTime = st.Updated
 }
@@ -0,0 +1,112 @@
+package features
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type RedirectRule struct {
+	Host   string
+	From   string
+	To     string
+	Status int
+}
+
+type RedirectPolicy struct {
+	HTTPS     bool
+	HTTPSPort int
+	StripWWW  bool
+	Status    int
+	Rules     []RedirectRule
+}
+
+var redirectPolicy atomic.Value
+
+func SetRedirectPolicy(p RedirectPolicy) {
+	redirectPolicy.Store(p)
+}
+
+func (rule RedirectRule) target(path string) (string, bool) {
+	if strings.HasSuffix(rule.From, "*") {
+		prefix := strings.TrimSuffix(rule.From, "*")
+		if !strings.HasPrefix(path, prefix) {
+			return "", false
+		}
+		return rule.To + strings.TrimPrefix(path, prefix), true
+	}
+	return rule.To, path == rule.From
+}
+
+func (p RedirectPolicy) Location(r *http.Request) (string, int, bool) {
+	status := p.Status
+	if status == 0 {
+		status = http.StatusMovedPermanently
+	}
+
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	host := r.Host
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	changed := false
+
+	if p.HTTPS && scheme == "http" {
+		scheme = "https"
+		host = hostname
+		if p.HTTPSPort > 0 && p.HTTPSPort != 443 {
+			host = net.JoinHostPort(hostname, strconv.Itoa(p.HTTPSPort))
+		}
+		changed = true
+	}
+	if p.StripWWW && strings.HasPrefix(strings.ToLower(host), "www.") {
+		host = host[len("www."):]
+		hostname = hostname[len("www."):]
+		changed = true
+	}
+
+	path := r.URL.RequestURI()
+	for _, rule := range p.Rules {
+		if rule.Host != "" && !strings.EqualFold(rule.Host, hostname) && !strings.EqualFold(rule.Host, "www."+hostname) {
+			continue
+		}
+		to, ok := rule.target(r.URL.Path)
+		if !ok {
+			continue
+		}
+		if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+			to += "?" + r.URL.RawQuery
+		}
+		if rule.Status != 0 {
+			status = rule.Status
+		}
+		if strings.HasPrefix(to, "http://") || strings.HasPrefix(to, "https://") {
+			return to, status, true
+		}
+		path = to
+		changed = true
+		break
+	}
+
+	if !changed {
+		return "", 0, false
+	}
+	return scheme + "://" + host + path, status, true
+}
+
+func RedirectMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := redirectPolicy.Load().(RedirectPolicy)
+		if location, status, ok := p.Location(r); ok {
+			http.Redirect(w, r, location, status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,63 @@
+package features
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type arrivalKey struct{}
+
+var lbTime struct {
+	count      int64
+	queueNs    int64
+	selectNs   int64
+	upstreamNs int64
+}
+
+func RequestStartMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if r.Header.Get("X-Request-Start") == "" {
+			r.Header.Set("X-Request-Start", "t="+strconv.FormatInt(now.UnixNano()/int64(time.Microsecond), 10))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), arrivalKey{}, now)))
+	})
+}
+
+func RequestArrival(r *http.Request) (time.Time, bool) {
+	t, ok := r.Context().Value(arrivalKey{}).(time.Time)
+	return t, ok
+}
+
+func RecordLBTime(queue, selection, upstream time.Duration) {
+	atomic.AddInt64(&lbTime.count, 1)
+	atomic.AddInt64(&lbTime.queueNs, int64(queue))
+	atomic.AddInt64(&lbTime.selectNs, int64(selection))
+	atomic.AddInt64(&lbTime.upstreamNs, int64(upstream))
+}
+
+func lbTimeJSON() string {
+	count := atomic.LoadInt64(&lbTime.count)
+	avg := func(total *int64) float64 {
+		if count == 0 {
+			return 0
+		}
+		return float64(atomic.LoadInt64(total)) / float64(count) / float64(time.Microsecond)
+	}
+	queue, selection := avg(&lbTime.queueNs), avg(&lbTime.selectNs)
+	data, err := json.Marshal(map[string]interface{}{
+		"count":            count,
+		"avg_queue_us":     queue,
+		"avg_selection_us": selection,
+		"avg_lb_us":        queue + selection,
+		"avg_upstream_us":  avg(&lbTime.upstreamNs),
+	})
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
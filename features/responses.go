@@ -0,0 +1,120 @@
+package features
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+type ResponseSpec struct {
+	Status      int
+	Body        string
+	ContentType string
+}
+
+type StatusRule struct {
+	Prefix        string
+	RateLimited   ResponseSpec
+	Blocked       ResponseSpec
+	Maintenance   ResponseSpec
+	InMaintenance bool
+}
+
+type StatusPolicy struct {
+	RateLimited   ResponseSpec
+	Blocked       ResponseSpec
+	Maintenance   ResponseSpec
+	InMaintenance bool
+	Routes        []StatusRule
+}
+
+var statusPolicy atomic.Value
+
+func SetStatusPolicy(p StatusPolicy) {
+	statusPolicy.Store(p)
+}
+
+func currentStatusPolicy() StatusPolicy {
+	p, _ := statusPolicy.Load().(StatusPolicy)
+	return p
+}
+
+func matchStatusRule(p StatusPolicy, path string) *StatusRule {
+	for i := range p.Routes {
+		if strings.HasPrefix(path, p.Routes[i].Prefix) {
+			return &p.Routes[i]
+		}
+	}
+	return nil
+}
+
+func writeResponse(w http.ResponseWriter, specs []ResponseSpec) {
+	var status int
+	var body, contentType string
+	for _, spec := range specs {
+		if status == 0 {
+			status = spec.Status
+		}
+		if body == "" {
+			body = spec.Body
+		}
+		if contentType == "" {
+			contentType = spec.ContentType
+		}
+	}
+	if body == "" {
+		body = http.StatusText(status)
+	}
+	if contentType == "" {
+		http.Error(w, body, status)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+func respond(w http.ResponseWriter, r *http.Request, pick func(StatusPolicy) ResponseSpec, pickRule func(*StatusRule) ResponseSpec, fallback int) {
+	p := currentStatusPolicy()
+	specs := []ResponseSpec{pick(p), {Status: fallback}}
+	if rule := matchStatusRule(p, r.URL.Path); rule != nil {
+		specs = append([]ResponseSpec{pickRule(rule)}, specs...)
+	}
+	writeResponse(w, specs)
+}
+
+func RespondRateLimited(w http.ResponseWriter, r *http.Request) {
+	respond(w, r,
+		func(p StatusPolicy) ResponseSpec { return p.RateLimited },
+		func(rule *StatusRule) ResponseSpec { return rule.RateLimited },
+		http.StatusTooManyRequests)
+}
+
+func RespondBlocked(w http.ResponseWriter, r *http.Request) {
+	respond(w, r,
+		func(p StatusPolicy) ResponseSpec { return p.Blocked },
+		func(rule *StatusRule) ResponseSpec { return rule.Blocked },
+		http.StatusForbidden)
+}
+
+func RespondMaintenance(w http.ResponseWriter, r *http.Request) {
+	respond(w, r,
+		func(p StatusPolicy) ResponseSpec { return p.Maintenance },
+		func(rule *StatusRule) ResponseSpec { return rule.Maintenance },
+		http.StatusServiceUnavailable)
+}
+
+func MaintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := currentStatusPolicy()
+		inMaintenance := p.InMaintenance
+		if rule := matchStatusRule(p, r.URL.Path); rule != nil && rule.InMaintenance {
+			inMaintenance = true
+		}
+		if inMaintenance {
+			RespondMaintenance(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,210 @@
+package features
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rolloutMinRequests = 20
+
+type RolloutConfig struct {
+	Percent      float64
+	Probation    time.Duration
+	MaxErrorRate float64
+}
+
+type rolloutArm struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+}
+
+func (a rolloutArm) errorRate() float64 {
+	if a.Requests == 0 {
+		return 0
+	}
+	return float64(a.Errors) / float64(a.Requests)
+}
+
+type MiddlewareRollout struct {
+	mu         sync.RWMutex
+	cfg        RolloutConfig
+	next       http.Handler
+	stable     []Middleware
+	candidate  []Middleware
+	stableH    http.Handler
+	candidateH http.Handler
+	prefixes   []string
+	until      time.Time
+	timer      *time.Timer
+	arms       [2]rolloutArm
+	lastResult string
+}
+
+func NewMiddlewareRollout(middlewares []Middleware) *MiddlewareRollout {
+	return &MiddlewareRollout{stable: middlewares}
+}
+
+func (mr *MiddlewareRollout) Middleware(next http.Handler) http.Handler {
+	mr.mu.Lock()
+	mr.next = next
+	mr.stableH = Chain(next, mr.stable...)
+	mr.mu.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr.mu.RLock()
+		stable, candidate := mr.stableH, mr.candidateH
+		onProbation := candidate != nil && mr.matches(r.URL.Path)
+		percent := mr.cfg.Percent
+		mr.mu.RUnlock()
+
+		switch {
+		case candidate == nil:
+			stable.ServeHTTP(w, r)
+		case !onProbation:
+			candidate.ServeHTTP(w, r)
+		default:
+			arm, h := 0, stable
+			if rand.Float64()*100 < percent {
+				arm, h = 1, candidate
+			}
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(sw, r)
+			mr.record(arm, sw.status >= 500)
+		}
+	})
+}
+
+func (mr *MiddlewareRollout) matches(path string) bool {
+	for _, p := range mr.prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mr *MiddlewareRollout) Update(middlewares []Middleware, prefixes []string, cfg RolloutConfig) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.timer != nil {
+		mr.timer.Stop()
+		mr.timer = nil
+	}
+	if mr.candidate != nil {
+		prefixes = append(append([]string{}, mr.prefixes...), prefixes...)
+	}
+	mr.candidate, mr.candidateH, mr.prefixes = nil, nil, nil
+
+	if len(prefixes) == 0 || cfg.Percent <= 0 || cfg.Probation <= 0 {
+		mr.stable = middlewares
+		mr.stableH = Chain(mr.next, middlewares...)
+		return
+	}
+
+	mr.cfg = cfg
+	mr.candidate = middlewares
+	mr.candidateH = Chain(mr.next, middlewares...)
+	mr.prefixes = prefixes
+	mr.until = time.Now().Add(cfg.Probation)
+	mr.arms = [2]rolloutArm{}
+	mr.timer = time.AfterFunc(cfg.Probation, mr.finish)
+	log.Printf("Middleware change on %v serving %.1f%% of requests for %v", prefixes, cfg.Percent, cfg.Probation)
+}
+
+func (mr *MiddlewareRollout) record(arm int, failed bool) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if mr.candidate == nil {
+		return
+	}
+	mr.arms[arm].Requests++
+	if failed {
+		mr.arms[arm].Errors++
+	}
+	if c := mr.arms[1]; c.Requests >= rolloutMinRequests && c.errorRate() > mr.maxErrorRate() {
+		mr.rollbackLocked("candidate error rate exceeded")
+	}
+}
+
+func (mr *MiddlewareRollout) maxErrorRate() float64 {
+	if mr.cfg.MaxErrorRate > 0 {
+		return mr.cfg.MaxErrorRate
+	}
+	return 0.05
+}
+
+func (mr *MiddlewareRollout) finish() {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if mr.candidate == nil {
+		return
+	}
+	if c := mr.arms[1]; c.Requests > 0 && c.errorRate() > mr.maxErrorRate() {
+		mr.rollbackLocked("candidate error rate exceeded")
+		return
+	}
+	mr.promoteLocked("probation passed")
+}
+
+func (mr *MiddlewareRollout) Promote() bool {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if mr.candidate == nil {
+		return false
+	}
+	mr.promoteLocked("promoted manually")
+	return true
+}
+
+func (mr *MiddlewareRollout) Rollback() bool {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	if mr.candidate == nil {
+		return false
+	}
+	mr.rollbackLocked("rolled back manually")
+	return true
+}
+
+func (mr *MiddlewareRollout) promoteLocked(reason string) {
+	mr.stable, mr.stableH = mr.candidate, mr.candidateH
+	mr.endLocked("promoted: " + reason)
+}
+
+func (mr *MiddlewareRollout) rollbackLocked(reason string) {
+	mr.endLocked("rolled back: " + reason)
+}
+
+func (mr *MiddlewareRollout) endLocked(result string) {
+	if mr.timer != nil {
+		mr.timer.Stop()
+		mr.timer = nil
+	}
+	log.Printf("Middleware rollout on %v %s (stable %d/%d errors, candidate %d/%d errors)",
+		mr.prefixes, result, mr.arms[0].Errors, mr.arms[0].Requests, mr.arms[1].Errors, mr.arms[1].Requests)
+	mr.candidate, mr.candidateH, mr.prefixes = nil, nil, nil
+	mr.lastResult = result
+}
+
+func (mr *MiddlewareRollout) Snapshot() interface{} {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	out := map[string]interface{}{
+		"state":       "stable",
+		"last_result": mr.lastResult,
+	}
+	if mr.candidate != nil {
+		out["state"] = "probation"
+		out["prefixes"] = mr.prefixes
+		out["percent"] = mr.cfg.Percent
+		out["remaining_s"] = int(time.Until(mr.until).Seconds())
+		out["stable"] = mr.arms[0]
+		out["candidate"] = mr.arms[1]
+	}
+	return out
+}
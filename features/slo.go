@@ -0,0 +1,156 @@
+package features
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const sloBuckets = 10
+
+type sloBucket struct {
+	slot     int64
+	requests uint64
+	errors   uint64
+}
+
+type sloSeries struct {
+	buckets  [sloBuckets]sloBucket
+	lastSeen time.Time
+}
+
+type BudgetStatus struct {
+	Requests  uint64  `json:"requests"`
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	Remaining float64 `json:"budget_remaining"`
+}
+
+type SLOTracker struct {
+	objective   float64
+	window      time.Duration
+	width       time.Duration
+	minRequests uint64
+	mu          sync.Mutex
+	series      map[string]*sloSeries
+	lastSweep   time.Time
+}
+
+func NewSLOTracker(objective float64, window time.Duration, minRequests int) *SLOTracker {
+	width := window / sloBuckets
+	if width <= 0 {
+		width = time.Second
+	}
+	return &SLOTracker{
+		objective:   objective,
+		window:      window,
+		width:       width,
+		minRequests: uint64(minRequests),
+		series:      make(map[string]*sloSeries),
+		lastSweep:   time.Now(),
+	}
+}
+
+func (t *SLOTracker) Record(key string, isError bool) {
+	now := time.Now()
+	slot := now.UnixNano() / int64(t.width)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastSweep) > t.window {
+		for k, s := range t.series {
+			if now.Sub(s.lastSeen) > t.window {
+				delete(t.series, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	s, ok := t.series[key]
+	if !ok {
+		s = &sloSeries{}
+		t.series[key] = s
+	}
+	s.lastSeen = now
+	b := &s.buckets[slot%sloBuckets]
+	if b.slot != slot {
+		*b = sloBucket{slot: slot}
+	}
+	b.requests++
+	if isError {
+		b.errors++
+	}
+}
+
+func (t *SLOTracker) Budget(key string) BudgetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.series[key]
+	if !ok {
+		return BudgetStatus{Remaining: 1}
+	}
+	return t.status(s, time.Now())
+}
+
+func (t *SLOTracker) status(s *sloSeries, now time.Time) BudgetStatus {
+	current := now.UnixNano() / int64(t.width)
+	var st BudgetStatus
+	for _, b := range s.buckets {
+		if b.slot > current-sloBuckets && b.slot <= current {
+			st.Requests += b.requests
+			st.Errors += b.errors
+		}
+	}
+	st.Remaining = 1
+	if st.Requests == 0 {
+		return st
+	}
+	st.ErrorRate = float64(st.Errors) / float64(st.Requests)
+	if st.Requests >= t.minRequests && t.objective < 1 {
+		st.Remaining = 1 - st.ErrorRate/(1-t.objective)
+	}
+	return st
+}
+
+func (t *SLOTracker) Snapshot() map[string]BudgetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	result := make(map[string]BudgetStatus, len(t.series))
+	for key, s := range t.series {
+		result[key] = t.status(s, now)
+	}
+	return result
+}
+
+type LoadShedder struct {
+	Tracker     *SLOTracker
+	Probability float64
+}
+
+func (s *LoadShedder) Shed(keys ...string) (bool, string) {
+	if !Headroom().Saturated {
+		return false, ""
+	}
+	for _, key := range keys {
+		budget := s.Tracker.Budget(key)
+		if budget.Remaining <= 0 {
+			atomic.AddUint64(&globalMetrics.Shed, 1)
+			return true, key
+		}
+		if s.Probability > 0 && shedPoint(key) < s.Probability*(1-budget.Remaining) {
+			atomic.AddUint64(&globalMetrics.Shed, 1)
+			return true, key
+		}
+	}
+	return false, ""
+}
+
+func shedPoint(key string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32()%10000) / 10000
+}
@@ -0,0 +1,97 @@
+package features
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type TraceSamplingRule struct {
+	Prefix string
+	Rate   float64
+}
+
+type TraceSampling struct {
+	Rate         float64
+	AlwaysErrors bool
+	Routes       []TraceSamplingRule
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", 2*n-1) + "1"
+	}
+	return hex.EncodeToString(b)
+}
+
+func parseTraceparent(h string) (traceID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false, false
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil || parts[1] == strings.Repeat("0", 32) {
+		return "", false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return "", false, false
+	}
+	return parts[1], flags[0]&0x01 == 1, true
+}
+
+func (s TraceSampling) rateFor(path string) float64 {
+	for _, rule := range s.Routes {
+		if strings.HasPrefix(path, rule.Prefix) {
+			return rule.Rate
+		}
+	}
+	return s.Rate
+}
+
+func TraceSamplingMiddleware(sampling TraceSampling) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceID, sampled, ok := parseTraceparent(r.Header.Get("traceparent"))
+			if !ok {
+				traceID = randomHex(16)
+				sampled = mrand.Float64() < sampling.rateFor(r.URL.Path)
+			}
+			spanID := randomHex(8)
+
+			flags := "00"
+			if sampled {
+				flags = "01"
+				atomic.AddUint64(&globalMetrics.TracesSampled, 1)
+			} else {
+				atomic.AddUint64(&globalMetrics.TracesDropped, 1)
+			}
+			r.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-"+flags)
+
+			tw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(tw, r)
+
+			forced := !sampled && sampling.AlwaysErrors && tw.status >= 500
+			if sampled || forced {
+				log.Printf(`{"trace_id":"%s","span_id":"%s","name":"%s %s","status":%d,"duration_ms":%d,"sampled":%t,"error_override":%t}`,
+					traceID, spanID, r.Method, r.URL.Path, tw.status, time.Since(start).Milliseconds(), sampled, forced)
+			}
+		})
+	}
+}
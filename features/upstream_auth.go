@@ -0,0 +1,107 @@
+package features
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultJWTTTL = 5 * time.Minute
+
+type UpstreamAuthConfig struct {
+	Type     string
+	Token    string
+	Username string
+	Password string
+	Secret   string
+	Issuer   string
+	Audience string
+	Subject  string
+	TTL      time.Duration
+}
+
+type UpstreamAuth struct {
+	cfg     UpstreamAuthConfig
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+func NewUpstreamAuth(cfg UpstreamAuthConfig) (*UpstreamAuth, error) {
+	switch cfg.Type {
+	case "bearer":
+		if cfg.Token == "" {
+			return nil, fmt.Errorf("bearer upstream auth needs a token")
+		}
+	case "basic":
+		if cfg.Username == "" {
+			return nil, fmt.Errorf("basic upstream auth needs a username")
+		}
+	case "jwt":
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("jwt upstream auth needs a secret")
+		}
+		if cfg.TTL <= 0 {
+			cfg.TTL = defaultJWTTTL
+		}
+		if cfg.Issuer == "" {
+			cfg.Issuer = "goadapt"
+		}
+	default:
+		return nil, fmt.Errorf("unknown upstream auth type: %s", cfg.Type)
+	}
+	return &UpstreamAuth{cfg: cfg}, nil
+}
+
+func (a *UpstreamAuth) Apply(r *http.Request) {
+	switch a.cfg.Type {
+	case "bearer":
+		r.Header.Set("Authorization", "Bearer "+a.cfg.Token)
+	case "basic":
+		r.SetBasicAuth(a.cfg.Username, a.cfg.Password)
+	case "jwt":
+		r.Header.Set("Authorization", "Bearer "+a.jwt())
+	}
+}
+
+func (a *UpstreamAuth) jwt() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.token != "" && now.Before(a.refresh) {
+		return a.token
+	}
+
+	jti := make([]byte, 16)
+	rand.Read(jti)
+	claims := map[string]interface{}{
+		"iss": a.cfg.Issuer,
+		"iat": now.Unix(),
+		"exp": now.Add(a.cfg.TTL).Unix(),
+		"jti": hex.EncodeToString(jti),
+	}
+	if a.cfg.Audience != "" {
+		claims["aud"] = a.cfg.Audience
+	}
+	if a.cfg.Subject != "" {
+		claims["sub"] = a.cfg.Subject
+	}
+	payload, _ := json.Marshal(claims)
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(a.cfg.Secret))
+	mac.Write([]byte(unsigned))
+
+	a.token = unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+	a.refresh = now.Add(a.cfg.TTL * 4 / 5)
+	return a.token
+}
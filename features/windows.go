@@ -0,0 +1,123 @@
+package features
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	windowBucketWidth = 10 * time.Second
+	windowBuckets     = 360
+)
+
+var latencyBoundsMs = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000}
+
+type windowBucket struct {
+	slot     int64
+	requests uint64
+	errors   uint64
+	latency  [14]uint64
+}
+
+type WindowSummary struct {
+	Requests  uint64  `json:"requests"`
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+}
+
+type RollingStats struct {
+	mu      sync.Mutex
+	buckets [windowBuckets]windowBucket
+}
+
+var globalWindows = &RollingStats{}
+
+func (rs *RollingStats) Record(now time.Time, duration time.Duration, isError bool) {
+	slot := now.UnixNano() / int64(windowBucketWidth)
+	ms := float64(duration) / float64(time.Millisecond)
+	bin := len(latencyBoundsMs)
+	for i, bound := range latencyBoundsMs {
+		if ms <= bound {
+			bin = i
+			break
+		}
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	b := &rs.buckets[slot%windowBuckets]
+	if b.slot != slot {
+		*b = windowBucket{slot: slot}
+	}
+	b.requests++
+	if isError {
+		b.errors++
+	}
+	b.latency[bin]++
+}
+
+func (rs *RollingStats) Summarize(now time.Time, window time.Duration) WindowSummary {
+	current := now.UnixNano() / int64(windowBucketWidth)
+	oldest := current - int64(window/windowBucketWidth) + 1
+
+	var summary WindowSummary
+	var latency [14]uint64
+
+	rs.mu.Lock()
+	for i := range rs.buckets {
+		b := &rs.buckets[i]
+		if b.slot < oldest || b.slot > current {
+			continue
+		}
+		summary.Requests += b.requests
+		summary.Errors += b.errors
+		for j, n := range b.latency {
+			latency[j] += n
+		}
+	}
+	rs.mu.Unlock()
+
+	if summary.Requests == 0 {
+		return summary
+	}
+	summary.ErrorRate = float64(summary.Errors) / float64(summary.Requests)
+	summary.P50Ms = latencyPercentile(latency, summary.Requests, 0.50)
+	summary.P90Ms = latencyPercentile(latency, summary.Requests, 0.90)
+	summary.P99Ms = latencyPercentile(latency, summary.Requests, 0.99)
+	return summary
+}
+
+func latencyPercentile(latency [14]uint64, total uint64, p float64) float64 {
+	target := uint64(float64(total)*p + 0.5)
+	if target == 0 {
+		target = 1
+	}
+	var seen uint64
+	for i, n := range latency {
+		seen += n
+		if seen >= target {
+			if i < len(latencyBoundsMs) {
+				return latencyBoundsMs[i]
+			}
+			break
+		}
+	}
+	return latencyBoundsMs[len(latencyBoundsMs)-1]
+}
+
+func windowsJSON() string {
+	now := time.Now()
+	data, err := json.Marshal(map[string]WindowSummary{
+		"1m": globalWindows.Summarize(now, time.Minute),
+		"5m": globalWindows.Summarize(now, 5*time.Minute),
+		"1h": globalWindows.Summarize(now, time.Hour),
+	})
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
@@ -2,38 +2,347 @@ package health
 
 import (
 	"advanced-lb/balancer"
+	"crypto/tls"
 	"log"
+	"math/rand"
 	"net"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func StartHealthCheck(getLB func() balancer.LoadBalancer, interval time.Duration) {
-	ticker := time.NewTicker(interval)
+type Config struct {
+	Interval       time.Duration
+	MinInterval    time.Duration
+	MaxInterval    time.Duration
+	Type           string
+	Method         string
+	Path           string
+	Timeout        time.Duration
+	ExpectedStatus []int
+	BodyContains   string
+	JSONField      string
+	JSONValue      string
+	GRPCService    string
+	Rise           int
+	Fall           int
+	Concurrency    int
+	Jitter         float64
+	TLS            *tls.Config
+	ForceTLS       bool
+}
+
+type schedule struct {
+	next      time.Time
+	interval  time.Duration
+	alive     bool
+	probed    bool
+	successes int
+	failures  int
+}
+
+func (c Config) adaptive() bool {
+	return c.MinInterval > 0 && c.MaxInterval > c.MinInterval
+}
+
+type Checker struct {
+	stop   chan struct{}
+	done   chan struct{}
+	rounds uint64
+}
+
+type pendingProbe struct {
+	backend *balancer.Backend
+	sched   *schedule
+	result  ProbeResult
+	ran     bool
+	warming bool
+}
+
+type poolMember struct {
+	lb      balancer.LoadBalancer
+	backend *balancer.Backend
+}
+
+func StartHealthCheck(getLBs func() []balancer.LoadBalancer, cfg Config) *Checker {
+	tick := cfg.Interval
+	if cfg.adaptive() {
+		tick = cfg.MinInterval
+		log.Printf("Adaptive health checks between %v and %v", cfg.MinInterval, cfg.MaxInterval)
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 10
+	}
+	probe := func(u *url.URL) ProbeResult {
+		if cfg.useTLS(u) {
+			return handshakeBackend(u, cfg.Timeout, cfg.tlsConfig())
+		}
+		return dialBackend(u, cfg.Timeout)
+	}
+	switch cfg.Type {
+	case "http":
+		probe = newHTTPProber(cfg)
+		log.Printf("HTTP health checks: %s %s", cfg.Method, cfg.Path)
+	case "grpc":
+		probe = newGRPCProber(cfg)
+		log.Printf("gRPC health checks for service %q", cfg.GRPCService)
+	}
+
+	c := &Checker{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	maxJitter := time.Duration(cfg.Jitter * float64(tick))
+
+	schedules := make(map[string]*schedule)
+	seen := make(map[*balancer.Backend]bool)
+
 	go func() {
-		for range ticker.C {
+		defer close(c.done)
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
 			log.Println("Running Health Checks...")
-			lb := getLB()
-			backends := lb.GetBackends()
-			for _, b := range backends {
-				alive := isBackendAlive(b.URL)
-				lb.UpdateBackendStatus(b.URL, alive)
-				status := "UP"
-				if !alive {
-					status = "DOWN"
+			now := time.Now()
+			pending := make(map[string]*pendingProbe)
+			var order []*pendingProbe
+			var members []poolMember
+			current := make(map[*balancer.Backend]bool)
+			active := make(map[string]bool)
+
+			for _, lb := range getLBs() {
+				for _, b := range lb.GetBackends() {
+					key := b.URL.String()
+					current[b] = true
+					active[key] = true
+					members = append(members, poolMember{lb: lb, backend: b})
+
+					s, ok := schedules[key]
+					if !ok {
+						s = &schedule{interval: cfg.MinInterval}
+						schedules[key] = s
+					}
+
+					if takeEjected(key) {
+						s.markDown(cfg, now)
+					}
+
+					due := !cfg.adaptive() || !s.probed || !now.Before(s.next)
+					if due && pending[key] == nil {
+						p := &pendingProbe{backend: b, sched: s}
+						pending[key] = p
+						order = append(order, p)
+					}
+				}
+			}
+
+			if !c.runProbes(order, probe, cfg.Concurrency, maxJitter) {
+				return
+			}
+
+			for _, p := range order {
+				b, s, result := p.backend, p.sched, p.result
+				if b.IsWarming() {
+					if !result.Alive && b.InInitialDelay() {
+						p.warming = true
+						log.Printf("%s [WARMING] (probe DOWN within initial delay)", b.URL)
+						continue
+					}
+					if result.Alive {
+						b.SetWarming(false)
+						log.Printf("%s finished warming", b.URL)
+					}
+				}
+				rise, fall := cfg.thresholds(b)
+				s.reschedule(cfg, now, result.Alive, rise, fall)
+				recordProbe(b.URL.String(), result, s.alive)
+				if result.Alive {
+					balancer.ClearDialFailure(b.URL)
 				}
-				log.Printf("%s [%s]", b.URL, status)
+				if result.Alive == s.alive {
+					log.Printf("%s [%s]", b.URL, statusName(s.alive))
+				} else {
+					log.Printf("%s [%s] (probe %s, %d/%d successes, %d/%d failures)", b.URL, statusName(s.alive),
+						statusName(result.Alive), s.successes, rise, s.failures, fall)
+				}
+			}
+
+			for _, m := range members {
+				key := m.backend.URL.String()
+				if p := pending[key]; p != nil && p.warming {
+					continue
+				}
+				if pending[key] != nil || !seen[m.backend] {
+					m.lb.UpdateBackendStatus(m.backend.URL, schedules[key].alive)
+				}
+			}
+
+			seen = current
+			for key := range schedules {
+				if !active[key] {
+					delete(schedules, key)
+				}
+			}
+			atomic.AddUint64(&c.rounds, 1)
+
+			select {
+			case <-ticker.C:
+			case <-c.stop:
+				return
 			}
 		}
 	}()
+	return c
 }
 
-func isBackendAlive(u *url.URL) bool {
-	timeout := 2 * time.Second
+func (c *Checker) runProbes(probes []*pendingProbe, probe func(*url.URL) ProbeResult, concurrency int, maxJitter time.Duration) bool {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, p := range probes {
+		wg.Add(1)
+		go func(p *pendingProbe) {
+			defer wg.Done()
+			if maxJitter > 0 {
+				timer := time.NewTimer(time.Duration(rand.Int63n(int64(maxJitter))))
+				select {
+				case <-timer.C:
+				case <-c.stop:
+					timer.Stop()
+					return
+				}
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-c.stop:
+				return
+			}
+			p.result = probe(p.backend.URL)
+			p.ran = true
+			<-sem
+		}(p)
+	}
+	wg.Wait()
+
+	for _, p := range probes {
+		if !p.ran {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Checker) Completed() bool {
+	return atomic.LoadUint64(&c.rounds) > 0
+}
+
+func (c *Checker) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c Config) thresholds(b *balancer.Backend) (int, int) {
+	rise, fall := c.Rise, c.Fall
+	if b.HealthRise > 0 {
+		rise = b.HealthRise
+	}
+	if b.HealthFall > 0 {
+		fall = b.HealthFall
+	}
+	if rise <= 0 {
+		rise = 1
+	}
+	if fall <= 0 {
+		fall = 1
+	}
+	return rise, fall
+}
+
+func (s *schedule) reschedule(cfg Config, now time.Time, probeAlive bool, rise, fall int) {
+	if probeAlive {
+		s.successes++
+		s.failures = 0
+	} else {
+		s.failures++
+		s.successes = 0
+	}
+
+	alive := s.alive
+	switch {
+	case !s.probed:
+		alive = probeAlive
+	case !s.alive && s.successes >= rise:
+		alive = true
+	case s.alive && s.failures >= fall:
+		alive = false
+	}
+
+	if cfg.adaptive() {
+		if !s.probed || alive != s.alive || probeAlive != alive {
+			s.interval = cfg.MinInterval
+		} else {
+			s.interval *= 2
+			if s.interval > cfg.MaxInterval {
+				s.interval = cfg.MaxInterval
+			}
+		}
+	}
+	s.alive = alive
+	s.probed = true
+	s.next = now.Add(s.interval)
+}
+
+func (s *schedule) markDown(cfg Config, now time.Time) {
+	s.alive = false
+	s.probed = true
+	s.successes = 0
+	s.failures = 0
+	if cfg.adaptive() {
+		s.interval = cfg.MinInterval
+		s.next = now.Add(s.interval)
+	}
+}
+
+func dialBackend(u *url.URL, timeout time.Duration) ProbeResult {
+	start := time.Now()
 	conn, err := net.DialTimeout("tcp", u.Host, timeout)
+	result := ProbeResult{
+		Time:      start,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
 	if err != nil {
-		return false
+		result.Error = err.Error()
+		return result
 	}
 	conn.Close()
-	return true
+	result.Alive = true
+	return result
+}
+
+func handshakeBackend(u *url.URL, timeout time.Duration, tlsCfg *tls.Config) ProbeResult {
+	addr := u.Host
+	if u.Port() == "" {
+		addr += ":443"
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = u.Hostname()
+	}
+
+	start := time.Now()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsCfg)
+	result := ProbeResult{
+		Time:      start,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	conn.Close()
+	result.Alive = true
+	return result
 }
@@ -0,0 +1,197 @@
+package health
+
+import (
+	"advanced-lb/balancer"
+	"advanced-lb/storage"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type DNSExportConfig struct {
+	Provider  string
+	Name      string
+	TTL       int
+	Interval  time.Duration
+	HostsFile string
+	ZoneID    string
+	AccessKey string
+	SecretKey string
+	Endpoint  string
+}
+
+type DNSExporter struct {
+	cfg    DNSExportConfig
+	getLBs func() []balancer.LoadBalancer
+	client *http.Client
+	last   string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func NewDNSExporter(cfg DNSExportConfig, getLBs func() []balancer.LoadBalancer) *DNSExporter {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 30
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 15 * time.Second
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://route53.amazonaws.com"
+	}
+	if cfg.AccessKey == "" {
+		cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if cfg.SecretKey == "" {
+		cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return &DNSExporter{
+		cfg:    cfg,
+		getLBs: getLBs,
+		client: &http.Client{Timeout: 10 * time.Second},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (e *DNSExporter) Start() error {
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(e.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			e.sync()
+			select {
+			case <-ticker.C:
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (e *DNSExporter) Stop() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *DNSExporter) healthyIPs() []string {
+	seen := make(map[string]bool)
+	var ips []string
+	for _, lb := range e.getLBs() {
+		for _, b := range lb.GetBackends() {
+			if !b.IsAlive() || b.IsDraining() {
+				continue
+			}
+			host := b.URL.Hostname()
+			addrs := []string{host}
+			if net.ParseIP(host) == nil {
+				resolved, err := net.LookupHost(host)
+				if err != nil {
+					log.Printf("DNS export: could not resolve %s: %v", host, err)
+					continue
+				}
+				addrs = resolved
+			}
+			for _, addr := range addrs {
+				if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil && !seen[addr] {
+					seen[addr] = true
+					ips = append(ips, addr)
+				}
+			}
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func (e *DNSExporter) sync() {
+	ips := e.healthyIPs()
+	if len(ips) == 0 {
+		log.Printf("DNS export: no healthy backends, keeping previous records for %s", e.cfg.Name)
+		return
+	}
+	key := strings.Join(ips, ",")
+	if key == e.last {
+		return
+	}
+
+	var err error
+	switch e.cfg.Provider {
+	case "route53":
+		err = e.publishRoute53(ips)
+	default:
+		err = e.publishHosts(ips)
+	}
+	if err != nil {
+		log.Printf("DNS export to %s failed: %v", e.cfg.Provider, err)
+		return
+	}
+	e.last = key
+	log.Printf("DNS export: %s -> %s", e.cfg.Name, key)
+}
+
+func (e *DNSExporter) publishHosts(ips []string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Managed by GoAdapt: healthy backends for %s\n", e.cfg.Name)
+	for _, ip := range ips {
+		fmt.Fprintf(&buf, "%s %s\n", ip, e.cfg.Name)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(e.cfg.HostsFile), filepath.Base(e.cfg.HostsFile)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), e.cfg.HostsFile)
+}
+
+func (e *DNSExporter) publishRoute53(ips []string) error {
+	var records strings.Builder
+	for _, ip := range ips {
+		fmt.Fprintf(&records, "<ResourceRecord><Value>%s</Value></ResourceRecord>", ip)
+	}
+	body := []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>`+
+		`<ChangeResourceRecordSetsRequest xmlns="https://route53.amazonaws.com/doc/2013-04-01/">`+
+		`<ChangeBatch><Comment>GoAdapt health-aware export</Comment><Changes><Change><Action>UPSERT</Action>`+
+		`<ResourceRecordSet><Name>%s</Name><Type>A</Type><TTL>%d</TTL><ResourceRecords>%s</ResourceRecords></ResourceRecordSet>`+
+		`</Change></Changes></ChangeBatch></ChangeResourceRecordSetsRequest>`,
+		e.cfg.Name, e.cfg.TTL, records.String()))
+
+	path := "/2013-04-01/hostedzone/" + strings.TrimPrefix(e.cfg.ZoneID, "/hostedzone/") + "/rrset"
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(e.cfg.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	storage.SignV4(req, body, path, "us-east-1", "route53", e.cfg.AccessKey, e.cfg.SecretKey)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("route53: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+type Event struct {
+	Type    string    `json:"type"`
+	Backend string    `json:"backend"`
+	Reason  string    `json:"reason,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+var (
+	subscribers    = make(map[int]func(Event))
+	nextSubscriber int
+	subscribersMu  sync.RWMutex
+)
+
+func Subscribe(fn func(Event)) func() {
+	subscribersMu.Lock()
+	id := nextSubscriber
+	nextSubscriber++
+	subscribers[id] = fn
+	subscribersMu.Unlock()
+
+	return func() {
+		subscribersMu.Lock()
+		delete(subscribers, id)
+		subscribersMu.Unlock()
+	}
+}
+
+func publish(e Event) {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
@@ -0,0 +1,153 @@
+package health
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const grpcServing = 1
+
+var grpcStatusNames = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+func newGRPCProber(cfg Config) func(u *url.URL) ProbeResult {
+	cleartext := &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.DialTimeout(network, addr, cfg.Timeout)
+			},
+		},
+	}
+	secure := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: &http2.Transport{TLSClientConfig: cfg.tlsConfig()},
+	}
+
+	request := grpcHealthRequest(cfg.GRPCService)
+
+	return func(u *url.URL) ProbeResult {
+		client, scheme := cleartext, "http"
+		if cfg.useTLS(u) {
+			client, scheme = secure, "https"
+		}
+		target := &url.URL{Scheme: scheme, Host: u.Host, Path: "/grpc.health.v1.Health/Check"}
+
+		start := time.Now()
+		result := ProbeResult{Time: start}
+
+		req, err := http.NewRequest(http.MethodPost, target.String(), bytes.NewReader(request))
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+		req.Header.Set("User-Agent", "GoAdapt-HealthCheck")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			result.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+			result.Error = err.Error()
+			return result
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+		resp.Body.Close()
+		result.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+
+		if err := grpcHealthStatus(resp, body); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Alive = true
+		return result
+	}
+}
+
+func grpcHealthRequest(service string) []byte {
+	var msg []byte
+	if service != "" {
+		var size [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(size[:], uint64(len(service)))
+		msg = append(msg, 0x0a)
+		msg = append(msg, size[:n]...)
+		msg = append(msg, service...)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+func grpcHealthStatus(resp *http.Response, body []byte) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	}
+
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		msg := resp.Trailer.Get("Grpc-Message")
+		if msg == "" {
+			msg = resp.Header.Get("Grpc-Message")
+		}
+		return fmt.Errorf("grpc-status %s %s", status, msg)
+	}
+
+	if len(body) < 5 {
+		return fmt.Errorf("truncated gRPC response")
+	}
+	size := binary.BigEndian.Uint32(body[1:5])
+	if body[0] != 0 || int(size) > len(body)-5 {
+		return fmt.Errorf("malformed gRPC response")
+	}
+
+	serving := uint64(0)
+	msg := body[5 : 5+size]
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("malformed health response")
+		}
+		msg = msg[n:]
+		if tag&7 != 0 {
+			return fmt.Errorf("unexpected wire type in health response")
+		}
+		value, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("malformed health response")
+		}
+		msg = msg[n:]
+		if tag>>3 == 1 {
+			serving = value
+		}
+	}
+
+	if serving != grpcServing {
+		name, ok := grpcStatusNames[serving]
+		if !ok {
+			name = fmt.Sprint(serving)
+		}
+		return fmt.Errorf("health status %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package health
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const historySize = 50
+
+type ProbeResult struct {
+	Time      time.Time `json:"time"`
+	Alive     bool      `json:"alive"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type Transition struct {
+	Time time.Time `json:"time"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
+
+type BackendHistory struct {
+	Backend     string        `json:"backend"`
+	Probes      []ProbeResult `json:"probes"`
+	Transitions []Transition  `json:"transitions"`
+}
+
+type backendHistory struct {
+	backend     string
+	alive       bool
+	probes      []ProbeResult
+	transitions []Transition
+}
+
+var (
+	histories   = make(map[string]*backendHistory)
+	historiesMu sync.Mutex
+)
+
+func statusName(alive bool) string {
+	if alive {
+		return "UP"
+	}
+	return "DOWN"
+}
+
+func recordProbe(backend string, result ProbeResult, alive bool) {
+	historiesMu.Lock()
+	h, ok := histories[backend]
+	if !ok {
+		h = &backendHistory{backend: backend, alive: true}
+		histories[backend] = h
+	}
+
+	h.probes = append(h.probes, result)
+	if len(h.probes) > historySize {
+		h.probes = h.probes[len(h.probes)-historySize:]
+	}
+
+	changed := alive != h.alive
+	if changed {
+		h.transitions = append(h.transitions, Transition{
+			Time: result.Time,
+			From: statusName(h.alive),
+			To:   statusName(alive),
+		})
+		if len(h.transitions) > historySize {
+			h.transitions = h.transitions[len(h.transitions)-historySize:]
+		}
+		h.alive = alive
+	}
+	historiesMu.Unlock()
+
+	if changed {
+		publish(Event{
+			Type:    strings.ToLower(statusName(alive)),
+			Backend: backend,
+			Reason:  result.Error,
+			Time:    result.Time,
+		})
+	}
+}
+
+func History(id string) (BackendHistory, bool) {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+
+	h, ok := histories[id]
+	if !ok {
+		return BackendHistory{}, false
+	}
+	return BackendHistory{
+		Backend:     h.backend,
+		Probes:      append([]ProbeResult{}, h.probes...),
+		Transitions: append([]Transition{}, h.transitions...),
+	}, true
+}
+
+func PruneHistory(keep map[string]bool) {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+
+	for id := range histories {
+		if !keep[id] {
+			delete(histories, id)
+		}
+	}
+}
+
+type Status struct {
+	LastCheck           time.Time
+	ConsecutiveFailures int
+	LastTransition      time.Time
+}
+
+func CurrentStatus(id string) (Status, bool) {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+
+	h, ok := histories[id]
+	if !ok || len(h.probes) == 0 {
+		return Status{}, false
+	}
+	st := Status{LastCheck: h.probes[len(h.probes)-1].Time}
+	for i := len(h.probes) - 1; i >= 0 && !h.probes[i].Alive; i-- {
+		st.ConsecutiveFailures++
+	}
+	if len(h.transitions) > 0 {
+		st.LastTransition = h.transitions[len(h.transitions)-1].Time
+	}
+	return st, true
+}
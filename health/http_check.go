@@ -0,0 +1,113 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const maxHealthBody = 64 * 1024
+
+func newHTTPProber(cfg Config) func(u *url.URL) ProbeResult {
+	method := cfg.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := cfg.Path
+	if path == "" {
+		path = "/"
+	}
+	client := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: &http.Transport{TLSClientConfig: cfg.tlsConfig()},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return func(u *url.URL) ProbeResult {
+		target := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: path}
+		if cfg.useTLS(u) {
+			target.Scheme = "https"
+		}
+		if i := strings.Index(path, "?"); i >= 0 {
+			target.Path, target.RawQuery = path[:i], path[i+1:]
+		}
+
+		start := time.Now()
+		result := ProbeResult{Time: start}
+
+		req, err := http.NewRequest(method, target.String(), nil)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		req.Header.Set("User-Agent", "GoAdapt-HealthCheck")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			result.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+			result.Error = err.Error()
+			return result
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+		resp.Body.Close()
+		result.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+
+		if err := cfg.match(resp.StatusCode, body); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Alive = true
+		return result
+	}
+}
+
+func (c Config) match(status int, body []byte) error {
+	if len(c.ExpectedStatus) > 0 {
+		ok := false
+		for _, code := range c.ExpectedStatus {
+			if code == status {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unexpected status %d", status)
+		}
+	} else if status < 200 || status >= 400 {
+		return fmt.Errorf("unexpected status %d", status)
+	}
+
+	if c.BodyContains != "" && !strings.Contains(string(body), c.BodyContains) {
+		return fmt.Errorf("body does not contain %q", c.BodyContains)
+	}
+
+	if c.JSONField != "" {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return fmt.Errorf("invalid JSON body: %v", err)
+		}
+		for _, part := range strings.Split(c.JSONField, ".") {
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("JSON field %s not found", c.JSONField)
+			}
+			if doc, ok = obj[part]; !ok {
+				return fmt.Errorf("JSON field %s not found", c.JSONField)
+			}
+		}
+		if c.JSONValue != "" && fmt.Sprint(doc) != c.JSONValue {
+			return fmt.Errorf("JSON field %s is %v, want %s", c.JSONField, doc, c.JSONValue)
+		}
+	}
+	return nil
+}
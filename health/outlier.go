@@ -0,0 +1,272 @@
+package health
+
+import (
+	"advanced-lb/balancer"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const outlierLatencySamples = 512
+
+type OutlierConfig struct {
+	Interval           time.Duration
+	BaseEjectionTime   time.Duration
+	MaxEjectionPercent int
+	MinRequests        int
+	MinHosts           int
+	StdevFactor        float64
+}
+
+type outlierStats struct {
+	requests     int
+	errors       int
+	latencies    []float64
+	pos          int
+	ejections    int
+	ejectedUntil time.Time
+	reason       string
+}
+
+type OutlierEjection struct {
+	Ejections    int       `json:"ejections"`
+	Ejected      bool      `json:"ejected"`
+	EjectedUntil time.Time `json:"ejected_until,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+type OutlierDetector struct {
+	cfg    OutlierConfig
+	getLBs func() []balancer.LoadBalancer
+	mu     sync.Mutex
+	stats  map[string]*outlierStats
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func NewOutlierDetector(cfg OutlierConfig, getLBs func() []balancer.LoadBalancer) *OutlierDetector {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.BaseEjectionTime <= 0 {
+		cfg.BaseEjectionTime = 30 * time.Second
+	}
+	if cfg.MaxEjectionPercent <= 0 {
+		cfg.MaxEjectionPercent = 10
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 100
+	}
+	if cfg.MinHosts <= 0 {
+		cfg.MinHosts = 3
+	}
+	if cfg.StdevFactor <= 0 {
+		cfg.StdevFactor = 1.9
+	}
+	return &OutlierDetector{
+		cfg:    cfg,
+		getLBs: getLBs,
+		stats:  make(map[string]*outlierStats),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (d *OutlierDetector) Start() error {
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.evaluate(time.Now())
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (d *OutlierDetector) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+func (d *OutlierDetector) Record(b *balancer.Backend, duration time.Duration, failed bool) {
+	key := b.URL.String()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.stats[key]
+	if !ok {
+		s = &outlierStats{}
+		d.stats[key] = s
+	}
+	s.requests++
+	if failed {
+		s.errors++
+	}
+	ms := float64(duration) / float64(time.Millisecond)
+	if len(s.latencies) < outlierLatencySamples {
+		s.latencies = append(s.latencies, ms)
+	} else {
+		s.latencies[s.pos] = ms
+		s.pos = (s.pos + 1) % outlierLatencySamples
+	}
+}
+
+type outlierCandidate struct {
+	backend     *balancer.Backend
+	stats       *outlierStats
+	successRate float64
+	p90         float64
+}
+
+func (d *OutlierDetector) evaluate(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	active := make(map[string]bool)
+	ejectedNow := make(map[string]bool)
+	for _, lb := range d.getLBs() {
+		backends := lb.GetBackends()
+		ejectedCount := 0
+		var candidates []outlierCandidate
+		for _, b := range backends {
+			key := b.URL.String()
+			active[key] = true
+			s, ok := d.stats[key]
+			if !ok {
+				continue
+			}
+			if now.Before(s.ejectedUntil) {
+				ejectedCount++
+				continue
+			}
+			if s.requests < d.cfg.MinRequests {
+				continue
+			}
+			candidates = append(candidates, outlierCandidate{
+				backend:     b,
+				stats:       s,
+				successRate: 1 - float64(s.errors)/float64(s.requests),
+				p90:         percentile(s.latencies, 0.9),
+			})
+		}
+		if len(candidates) < d.cfg.MinHosts {
+			continue
+		}
+
+		maxEjected := len(backends) * d.cfg.MaxEjectionPercent / 100
+		if maxEjected < 1 {
+			maxEjected = 1
+		}
+
+		rateMean, rateStdev := meanStdev(candidates, func(c outlierCandidate) float64 { return c.successRate })
+		latMean, latStdev := meanStdev(candidates, func(c outlierCandidate) float64 { return c.p90 })
+		rateThreshold := rateMean - d.cfg.StdevFactor*rateStdev
+		latThreshold := latMean + d.cfg.StdevFactor*latStdev
+
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].successRate != candidates[j].successRate {
+				return candidates[i].successRate < candidates[j].successRate
+			}
+			return candidates[i].p90 > candidates[j].p90
+		})
+		for _, c := range candidates {
+			reason := ""
+			switch {
+			case rateStdev > 0 && c.successRate < rateThreshold:
+				reason = "success rate"
+			case latStdev > 0 && c.p90 > latThreshold:
+				reason = "latency"
+			}
+			if reason == "" {
+				continue
+			}
+			if ejectedCount >= maxEjected {
+				log.Printf("%s is an outlier (%s) but %d%% of the pool is already ejected", c.backend.URL, reason, d.cfg.MaxEjectionPercent)
+				break
+			}
+
+			c.stats.ejections++
+			ejection := d.cfg.BaseEjectionTime * time.Duration(c.stats.ejections)
+			c.stats.ejectedUntil = now.Add(ejection)
+			c.stats.reason = reason
+			c.backend.Eject(ejection)
+			ejectedCount++
+			ejectedNow[c.backend.URL.String()] = true
+			log.Printf("%s [EJECTED] (outlier: %s, success rate %.3f, p90 %.1fms) for %v", c.backend.URL, reason,
+				c.successRate, c.p90, ejection)
+			publish(Event{
+				Type:    "ejected",
+				Backend: c.backend.URL.String(),
+				Reason:  fmt.Sprintf("outlier: %s, for %v", reason, ejection),
+				Time:    now,
+			})
+		}
+	}
+
+	for key, s := range d.stats {
+		if !active[key] {
+			delete(d.stats, key)
+			continue
+		}
+		if !ejectedNow[key] && !now.Before(s.ejectedUntil) && s.ejections > 0 {
+			s.ejections--
+		}
+		s.requests = 0
+		s.errors = 0
+		s.latencies = s.latencies[:0]
+		s.pos = 0
+	}
+}
+
+func (d *OutlierDetector) Ejections() map[string]OutlierEjection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	result := make(map[string]OutlierEjection)
+	for key, s := range d.stats {
+		if s.ejections == 0 {
+			continue
+		}
+		e := OutlierEjection{Ejections: s.ejections, Reason: s.reason}
+		if now.Before(s.ejectedUntil) {
+			e.Ejected = true
+			e.EjectedUntil = s.ejectedUntil
+		}
+		result[key] = e
+	}
+	return result
+}
+
+func meanStdev(candidates []outlierCandidate, value func(outlierCandidate) float64) (float64, float64) {
+	var sum float64
+	for _, c := range candidates {
+		sum += value(c)
+	}
+	mean := sum / float64(len(candidates))
+	var variance float64
+	for _, c := range candidates {
+		diff := value(c) - mean
+		variance += diff * diff
+	}
+	return mean, math.Sqrt(variance / float64(len(candidates)))
+}
+
+func percentile(samples []float64, p float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+	return sorted[int(p*float64(len(sorted)-1))]
+}
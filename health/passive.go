@@ -0,0 +1,112 @@
+package health
+
+import (
+	"advanced-lb/balancer"
+	"log"
+	"sync"
+	"time"
+)
+
+type PassiveConfig struct {
+	ConsecutiveFailures int
+	ErrorRate           float64
+	MinRequests         int
+	Window              int
+}
+
+type passiveTracker struct {
+	results     []bool
+	pos         int
+	filled      int
+	failures    int
+	consecutive int
+}
+
+type PassiveChecker struct {
+	cfg      PassiveConfig
+	getLBs   func() []balancer.LoadBalancer
+	mu       sync.Mutex
+	trackers map[string]*passiveTracker
+}
+
+var (
+	ejected   = make(map[string]bool)
+	ejectedMu sync.Mutex
+)
+
+func NewPassiveChecker(cfg PassiveConfig, getLBs func() []balancer.LoadBalancer) *PassiveChecker {
+	if cfg.Window <= 0 {
+		cfg.Window = 50
+	}
+	if cfg.MinRequests <= 0 || cfg.MinRequests > cfg.Window {
+		cfg.MinRequests = cfg.Window / 2
+	}
+	return &PassiveChecker{
+		cfg:      cfg,
+		getLBs:   getLBs,
+		trackers: make(map[string]*passiveTracker),
+	}
+}
+
+func (p *PassiveChecker) Record(b *balancer.Backend, failed bool) {
+	key := b.URL.String()
+
+	p.mu.Lock()
+	t, ok := p.trackers[key]
+	if !ok {
+		t = &passiveTracker{results: make([]bool, p.cfg.Window)}
+		p.trackers[key] = t
+	}
+
+	if t.filled == len(t.results) && t.results[t.pos] {
+		t.failures--
+	}
+	t.results[t.pos] = failed
+	t.pos = (t.pos + 1) % len(t.results)
+	if t.filled < len(t.results) {
+		t.filled++
+	}
+	if failed {
+		t.failures++
+		t.consecutive++
+	} else {
+		t.consecutive = 0
+	}
+
+	reason := ""
+	rate := float64(t.failures) / float64(t.filled)
+	switch {
+	case p.cfg.ConsecutiveFailures > 0 && t.consecutive >= p.cfg.ConsecutiveFailures:
+		reason = "consecutive failures"
+	case p.cfg.ErrorRate > 0 && t.filled >= p.cfg.MinRequests && rate >= p.cfg.ErrorRate:
+		reason = "error rate"
+	}
+	if reason != "" {
+		delete(p.trackers, key)
+	}
+	p.mu.Unlock()
+
+	if reason == "" || !b.IsAlive() {
+		return
+	}
+
+	ejectedMu.Lock()
+	ejected[key] = true
+	ejectedMu.Unlock()
+	recordProbe(key, ProbeResult{Time: time.Now(), Error: "passive: " + reason}, false)
+
+	for _, lb := range p.getLBs() {
+		lb.UpdateBackendStatus(b.URL, false)
+	}
+	log.Printf("%s [DOWN] (passive: %s, error rate %.2f)", b.URL, reason, rate)
+}
+
+func takeEjected(key string) bool {
+	ejectedMu.Lock()
+	defer ejectedMu.Unlock()
+	if !ejected[key] {
+		return false
+	}
+	delete(ejected, key)
+	return true
+}
@@ -0,0 +1,206 @@
+package health
+
+import (
+	"advanced-lb/balancer"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const steeringProbeWindow = 10
+
+type SteeringPeer struct {
+	Region string
+	URL    string
+}
+
+type RegionHint struct {
+	Region    string    `json:"region"`
+	Healthy   int       `json:"healthy_backends"`
+	Total     int       `json:"total_backends"`
+	RTTMs     float64   `json:"rtt_ms"`
+	Weight    int       `json:"weight"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type Steering struct {
+	Region   string
+	Peers    []SteeringPeer
+	interval time.Duration
+	getLBs   func() []balancer.LoadBalancer
+	client   *http.Client
+	mu       sync.RWMutex
+	remote   map[string]RegionHint
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewSteering(region string, peers []SteeringPeer, interval time.Duration, getLBs func() []balancer.LoadBalancer) *Steering {
+	return &Steering{
+		Region:   region,
+		Peers:    peers,
+		interval: interval,
+		getLBs:   getLBs,
+		client:   &http.Client{Timeout: interval / 2},
+		remote:   make(map[string]RegionHint),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *Steering) Start() error {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			for _, peer := range s.Peers {
+				hint := s.fetch(peer)
+				s.mu.Lock()
+				s.remote[peer.Region] = hint
+				s.mu.Unlock()
+			}
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *Steering) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Steering) fetch(peer SteeringPeer) RegionHint {
+	hint := RegionHint{Region: peer.Region, UpdatedAt: time.Now()}
+
+	resp, err := s.client.Get(peer.URL)
+	if err != nil {
+		hint.Error = err.Error()
+		log.Printf("Steering: peer %s unreachable: %v", peer.Region, err)
+		return hint
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		hint.Error = fmt.Sprintf("status %d", resp.StatusCode)
+		return hint
+	}
+
+	var body struct {
+		Local RegionHint `json:"local"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		hint.Error = err.Error()
+		return hint
+	}
+	body.Local.Region = peer.Region
+	body.Local.UpdatedAt = hint.UpdatedAt
+	return body.Local
+}
+
+func (s *Steering) Local() RegionHint {
+	hint := RegionHint{Region: s.Region, UpdatedAt: time.Now()}
+
+	seen := make(map[string]bool)
+	var rttSum float64
+	var rttCount int
+	for _, lb := range s.getLBs() {
+		for _, b := range lb.GetBackends() {
+			if seen[b.URL.Host] {
+				continue
+			}
+			seen[b.URL.Host] = true
+			hint.Total++
+			if !b.IsAlive() {
+				continue
+			}
+			hint.Healthy++
+
+			h, ok := History(b.URL.String())
+			if !ok {
+				continue
+			}
+			probes := h.Probes
+			if len(probes) > steeringProbeWindow {
+				probes = probes[len(probes)-steeringProbeWindow:]
+			}
+			for _, p := range probes {
+				if p.Alive {
+					rttSum += p.LatencyMs
+					rttCount++
+				}
+			}
+		}
+	}
+	if rttCount > 0 {
+		hint.RTTMs = rttSum / float64(rttCount)
+	}
+	return hint
+}
+
+func (s *Steering) Hints() (RegionHint, []RegionHint) {
+	hints := []RegionHint{s.Local()}
+
+	stale := time.Now().Add(-3 * s.interval)
+	s.mu.RLock()
+	for _, hint := range s.remote {
+		if hint.Error == "" && hint.UpdatedAt.Before(stale) {
+			hint.Error = "stale"
+		}
+		hints = append(hints, hint)
+	}
+	s.mu.RUnlock()
+
+	scores := make([]float64, len(hints))
+	var total float64
+	for i, hint := range hints {
+		if hint.Error != "" || hint.Healthy == 0 {
+			continue
+		}
+		scores[i] = float64(hint.Healthy) / float64(hint.Total) / math.Max(hint.RTTMs, 1)
+		total += scores[i]
+	}
+	for i := range hints {
+		hints[i].Weight = 0
+		if total > 0 {
+			hints[i].Weight = int(math.Round(100 * scores[i] / total))
+		}
+	}
+	local := hints[0]
+
+	sort.Slice(hints, func(i, j int) bool {
+		if hints[i].Weight != hints[j].Weight {
+			return hints[i].Weight > hints[j].Weight
+		}
+		return hints[i].Region < hints[j].Region
+	})
+	return local, hints
+}
+
+func (s *Steering) Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	local, hints := s.Hints()
+	response := map[string]interface{}{
+		"region":  s.Region,
+		"local":   local,
+		"regions": hints,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,39 @@
+package health
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+func NewTLSConfig(caFile, serverName string, insecureSkipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func (c Config) useTLS(u *url.URL) bool {
+	return c.ForceTLS || u.Scheme == "https"
+}
+
+func (c Config) tlsConfig() *tls.Config {
+	if c.TLS == nil {
+		return &tls.Config{}
+	}
+	return c.TLS.Clone()
+}
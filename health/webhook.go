@@ -0,0 +1,163 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type Webhook struct {
+	URL        string
+	Kind       string
+	RoutingKey string
+	Events     []string
+}
+
+type Notifier struct {
+	hooks       []Webhook
+	client      *http.Client
+	queue       chan Event
+	unsubscribe func()
+	done        chan struct{}
+}
+
+func NewNotifier(hooks []Webhook) *Notifier {
+	for i := range hooks {
+		if hooks[i].Kind == "pagerduty" && hooks[i].URL == "" {
+			hooks[i].URL = pagerDutyEventsURL
+		}
+	}
+	return &Notifier{
+		hooks:  hooks,
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan Event, 100),
+		done:   make(chan struct{}),
+	}
+}
+
+func (n *Notifier) Start() error {
+	n.unsubscribe = Subscribe(func(e Event) {
+		select {
+		case n.queue <- e:
+		default:
+			log.Printf("Webhook queue full, dropping %s event for %s", e.Type, e.Backend)
+		}
+	})
+	go func() {
+		defer close(n.done)
+		for e := range n.queue {
+			for _, hook := range n.hooks {
+				if hook.wants(e.Type) {
+					n.deliver(hook, e)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (n *Notifier) Stop() {
+	n.unsubscribe()
+	close(n.queue)
+	<-n.done
+}
+
+func (h Webhook) wants(eventType string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifier) deliver(hook Webhook, e Event) {
+	body, err := json.Marshal(hook.payload(e))
+	if err != nil {
+		log.Printf("Webhook %s: %v", hook.Kind, err)
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 1; attempt <= 3; attempt++ {
+		err = n.post(hook.URL, body)
+		if err == nil {
+			return
+		}
+		if attempt < 3 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	log.Printf("Webhook %s for %s event on %s failed: %v", hook.URL, e.Type, e.Backend, err)
+}
+
+func (n *Notifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (h Webhook) payload(e Event) interface{} {
+	summary := fmt.Sprintf("Backend %s is %s", e.Backend, statusText(e.Type))
+	if e.Reason != "" {
+		summary += " (" + e.Reason + ")"
+	}
+
+	switch h.Kind {
+	case "slack":
+		icon := ":red_circle:"
+		switch e.Type {
+		case "up":
+			icon = ":large_green_circle:"
+		case "ejected":
+			icon = ":warning:"
+		}
+		return map[string]string{"text": icon + " " + summary}
+	case "pagerduty":
+		action, severity, dedup := "trigger", "critical", "goadapt:"+e.Backend
+		switch e.Type {
+		case "up":
+			action = "resolve"
+		case "ejected":
+			severity = "warning"
+			dedup += ":outlier"
+		}
+		return map[string]interface{}{
+			"routing_key":  h.RoutingKey,
+			"event_action": action,
+			"dedup_key":    dedup,
+			"payload": map[string]string{
+				"summary":   summary,
+				"source":    e.Backend,
+				"severity":  severity,
+				"timestamp": e.Time.Format(time.RFC3339),
+			},
+		}
+	}
+	return e
+}
+
+func statusText(eventType string) string {
+	switch eventType {
+	case "up":
+		return "UP"
+	case "down":
+		return "DOWN"
+	}
+	return "ejected as an outlier"
+}
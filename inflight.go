@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type inflightRequest struct {
+	ID      string
+	Method  string
+	Path    string
+	Backend string
+	Client  string
+	Start   time.Time
+	cancel  context.CancelFunc
+}
+
+var (
+	inflight    = make(map[string]*inflightRequest)
+	inflightMu  sync.Mutex
+	inflightSeq uint64
+)
+
+func trackInflight(r *http.Request, backend string) (*http.Request, func()) {
+	id, _ := r.Context().Value("RequestID").(string)
+	if id == "" {
+		id = fmt.Sprintf("req-%d", atomic.AddUint64(&inflightSeq, 1))
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	req := &inflightRequest{
+		ID:      id,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Backend: backend,
+		Client:  r.RemoteAddr,
+		Start:   time.Now(),
+		cancel:  cancel,
+	}
+
+	inflightMu.Lock()
+	if _, exists := inflight[id]; exists {
+		id = fmt.Sprintf("%s-%d", id, atomic.AddUint64(&inflightSeq, 1))
+		req.ID = id
+	}
+	inflight[id] = req
+	inflightMu.Unlock()
+
+	return r.WithContext(ctx), func() {
+		inflightMu.Lock()
+		delete(inflight, id)
+		inflightMu.Unlock()
+		cancel()
+	}
+}
+
+func inflightHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		type entry struct {
+			ID        string `json:"id"`
+			Method    string `json:"method"`
+			Path      string `json:"path"`
+			Backend   string `json:"backend"`
+			Client    string `json:"client"`
+			ElapsedMs int64  `json:"elapsed_ms"`
+		}
+
+		inflightMu.Lock()
+		entries := make([]entry, 0, len(inflight))
+		for _, req := range inflight {
+			entries = append(entries, entry{
+				ID:        req.ID,
+				Method:    req.Method,
+				Path:      req.Path,
+				Backend:   req.Backend,
+				Client:    req.Client,
+				ElapsedMs: time.Since(req.Start).Milliseconds(),
+			})
+		}
+		inflightMu.Unlock()
+
+		sort.Slice(entries, func(i, j int) bool { return entries[i].ElapsedMs > entries[j].ElapsedMs })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+
+	case http.MethodPost:
+		id := r.URL.Query().Get("cancel")
+		if id == "" {
+			http.Error(w, "Missing cancel parameter", http.StatusBadRequest)
+			return
+		}
+
+		inflightMu.Lock()
+		req, ok := inflight[id]
+		inflightMu.Unlock()
+		if !ok {
+			http.Error(w, "Request not found", http.StatusNotFound)
+			return
+		}
+
+		req.cancel()
+		log.Printf("Cancelled in-flight request %s %s %s to %s", req.ID, req.Method, req.Path, req.Backend)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Request cancelled"))
+
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
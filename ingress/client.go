@@ -0,0 +1,151 @@
+package ingress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+type Client struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running inside a Kubernetes cluster")
+	}
+
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s/ca.crt", serviceAccountDir)
+	}
+
+	return &Client{
+		baseURL: "https://" + net.JoinHostPort(host, port),
+		token:   strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+func (c *Client) get(path string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type ServiceBackend struct {
+	Service *struct {
+		Name string `json:"name"`
+		Port struct {
+			Number int    `json:"number"`
+			Name   string `json:"name"`
+		} `json:"port"`
+	} `json:"service"`
+}
+
+type Ingress struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		IngressClassName string          `json:"ingressClassName"`
+		DefaultBackend   *ServiceBackend `json:"defaultBackend"`
+		TLS              []struct {
+			Hosts      []string `json:"hosts"`
+			SecretName string   `json:"secretName"`
+		} `json:"tls"`
+		Rules []struct {
+			Host string `json:"host"`
+			HTTP *struct {
+				Paths []struct {
+					Path     string         `json:"path"`
+					PathType string         `json:"pathType"`
+					Backend  ServiceBackend `json:"backend"`
+				} `json:"paths"`
+			} `json:"http"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+func (c *Client) ListIngresses(namespace string) ([]Ingress, error) {
+	path := "/apis/networking.k8s.io/v1/ingresses"
+	if namespace != "" {
+		path = "/apis/networking.k8s.io/v1/namespaces/" + namespace + "/ingresses"
+	}
+
+	var list struct {
+		Items []Ingress `json:"items"`
+	}
+	if err := c.get(path, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+func (c *Client) servicePort(namespace, service, portName string) (int, error) {
+	var svc struct {
+		Spec struct {
+			Ports []struct {
+				Name string `json:"name"`
+				Port int    `json:"port"`
+			} `json:"ports"`
+		} `json:"spec"`
+	}
+	if err := c.get("/api/v1/namespaces/"+namespace+"/services/"+service, &svc); err != nil {
+		return 0, err
+	}
+	for _, p := range svc.Spec.Ports {
+		if p.Name == portName {
+			return p.Port, nil
+		}
+	}
+	return 0, fmt.Errorf("service %s/%s has no port named %s", namespace, service, portName)
+}
+
+func (c *Client) tlsCertificate(namespace, secret string) (tls.Certificate, error) {
+	var s struct {
+		Data map[string][]byte `json:"data"`
+	}
+	if err := c.get("/api/v1/namespaces/"+namespace+"/secrets/"+secret, &s); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(s.Data["tls.crt"], s.Data["tls.key"])
+}
@@ -0,0 +1,166 @@
+package ingress
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Route struct {
+	Host     string
+	Path     string
+	Backends []string
+}
+
+type Certificate struct {
+	Hosts []string
+	Cert  tls.Certificate
+}
+
+func matchesClass(ing Ingress, class string) bool {
+	if class == "" {
+		return true
+	}
+	if ing.Spec.IngressClassName != "" {
+		return ing.Spec.IngressClassName == class
+	}
+	return ing.Metadata.Annotations["kubernetes.io/ingress.class"] == class
+}
+
+func (c *Client) backendURL(namespace string, b ServiceBackend) (string, error) {
+	if b.Service == nil {
+		return "", fmt.Errorf("only service backends are supported")
+	}
+	port := b.Service.Port.Number
+	if port == 0 {
+		p, err := c.servicePort(namespace, b.Service.Name, b.Service.Port.Name)
+		if err != nil {
+			return "", err
+		}
+		port = p
+	}
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", b.Service.Name, namespace, port), nil
+}
+
+func (c *Client) Sync(namespace, class string) ([]Route, []Certificate, error) {
+	ingresses, err := c.ListIngresses(namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byKey := make(map[string]*Route)
+	add := func(host, path, backend string) {
+		if path == "" {
+			path = "/"
+		}
+		key := host + "|" + path
+		rt, ok := byKey[key]
+		if !ok {
+			rt = &Route{Host: host, Path: path}
+			byKey[key] = rt
+		}
+		for _, existing := range rt.Backends {
+			if existing == backend {
+				return
+			}
+		}
+		rt.Backends = append(rt.Backends, backend)
+	}
+
+	var certs []Certificate
+	for _, ing := range ingresses {
+		if !matchesClass(ing, class) {
+			continue
+		}
+		ns := ing.Metadata.Namespace
+
+		if ing.Spec.DefaultBackend != nil {
+			if u, err := c.backendURL(ns, *ing.Spec.DefaultBackend); err == nil {
+				add("", "/", u)
+			} else {
+				log.Printf("Ingress %s/%s: skipping default backend: %v", ns, ing.Metadata.Name, err)
+			}
+		}
+
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				u, err := c.backendURL(ns, p.Backend)
+				if err != nil {
+					log.Printf("Ingress %s/%s: skipping path %s: %v", ns, ing.Metadata.Name, p.Path, err)
+					continue
+				}
+				add(rule.Host, p.Path, u)
+			}
+		}
+
+		for _, t := range ing.Spec.TLS {
+			cert, err := c.tlsCertificate(ns, t.SecretName)
+			if err != nil {
+				log.Printf("Ingress %s/%s: skipping TLS secret %s: %v", ns, ing.Metadata.Name, t.SecretName, err)
+				continue
+			}
+			certs = append(certs, Certificate{Hosts: t.Hosts, Cert: cert})
+		}
+	}
+
+	routes := make([]Route, 0, len(byKey))
+	for _, rt := range byKey {
+		sort.Strings(rt.Backends)
+		routes = append(routes, *rt)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if len(routes[i].Path) != len(routes[j].Path) {
+			return len(routes[i].Path) > len(routes[j].Path)
+		}
+		if routes[i].Host != routes[j].Host {
+			return routes[i].Host > routes[j].Host
+		}
+		return routes[i].Path < routes[j].Path
+	})
+	return routes, certs, nil
+}
+
+type CertStore struct {
+	certs map[string]*tls.Certificate
+	mu    sync.RWMutex
+}
+
+func NewCertStore() *CertStore {
+	return &CertStore{certs: make(map[string]*tls.Certificate)}
+}
+
+func (s *CertStore) Update(certs []Certificate) {
+	m := make(map[string]*tls.Certificate)
+	for i := range certs {
+		for _, h := range certs[i].Hosts {
+			m[strings.ToLower(h)] = &certs[i].Cert
+		}
+	}
+
+	s.mu.Lock()
+	s.certs = m
+	s.mu.Unlock()
+}
+
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cert, ok := s.certs[name]; ok {
+		return cert, nil
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := s.certs["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return nil, nil
+}
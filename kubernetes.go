@@ -0,0 +1,57 @@
+package main
+
+import (
+	"advanced-lb/ingress"
+	"log"
+	"reflect"
+	"time"
+)
+
+func startIngressController(cfg *Config, certs *ingress.CertStore) error {
+	client, err := ingress.NewInClusterClient()
+	if err != nil {
+		return err
+	}
+
+	interval, err := time.ParseDuration(cfg.Kubernetes.ResyncInterval)
+	if err != nil || interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	sync := func() {
+		translated, tlsCerts, err := client.Sync(cfg.Kubernetes.Namespace, cfg.Kubernetes.IngressClass)
+		if err != nil {
+			log.Printf("Ingress sync failed: %v", err)
+			return
+		}
+		certs.Update(tlsCerts)
+
+		defs := make([]RouteConfig, 0, len(translated))
+		for _, rt := range translated {
+			rc := RouteConfig{Host: rt.Host, Path: rt.Path}
+			for _, u := range rt.Backends {
+				rc.Backends = append(rc.Backends, BackendConfig{URL: u})
+			}
+			defs = append(defs, rc)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if reflect.DeepEqual(defs, ingressRoutes) {
+			return
+		}
+		ingressRoutes = defs
+		routes = initRoutes(currentCfg, globalLB, map[string]bool{})
+		log.Printf("Ingress sync applied %d routes and %d TLS certificates", len(defs), len(tlsCerts))
+	}
+
+	sync()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sync()
+		}
+	}()
+	return nil
+}
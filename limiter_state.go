@@ -0,0 +1,93 @@
+package main
+
+import (
+	"advanced-lb/features"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+)
+
+const limiterStateKey = "ratelimit"
+
+var limiterStatePath = "ratelimit.json"
+
+var (
+	connLimiter     *features.ConnLimitListener
+	savedConnStates map[string]features.BucketState
+)
+
+type limiterState struct {
+	Requests    *features.BucketState           `json:"requests,omitempty"`
+	Connections map[string]features.BucketState `json:"connections,omitempty"`
+}
+
+func saveLimiterState() error {
+	st := limiterState{}
+	if rateLimiter != nil {
+		requests := rateLimiter.State()
+		st.Requests = &requests
+	}
+	if connLimiter != nil {
+		st.Connections = connLimiter.Buckets()
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		return store.Set(limiterStateKey, data, 0)
+	}
+	tmp := limiterStatePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, limiterStatePath)
+}
+
+func loadLimiterState() (*limiterState, error) {
+	var data []byte
+	var err error
+	if store != nil {
+		data, err = store.Get(limiterStateKey)
+	} else {
+		data, err = os.ReadFile(limiterStatePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &limiterState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func registerLimiterPersistence(cfg *Config) {
+	if cfg.RateLimiter.PersistPath != "" {
+		limiterStatePath = cfg.RateLimiter.PersistPath
+	}
+	lifecycle.Register(features.Hook{
+		Name: "ratelimit-state",
+		OnStart: func() error {
+			st, err := loadLimiterState()
+			if err != nil {
+				log.Printf("Could not load rate limiter state (starting with full buckets): %v", err)
+				return nil
+			}
+			if st.Requests != nil {
+				rateLimiter.Restore(*st.Requests)
+			}
+			savedConnStates = st.Connections
+			log.Printf("Rate limiter state restored (%d connection buckets)", len(st.Connections))
+			return nil
+		},
+		OnShutdown: func(ctx context.Context) error {
+			if err := saveLimiterState(); err != nil {
+				return err
+			}
+			log.Println("Rate limiter state saved on shutdown")
+			return nil
+		},
+	})
+}
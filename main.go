@@ -4,25 +4,36 @@ import (
 	"advanced-lb/balancer"
 	"advanced-lb/features"
 	"advanced-lb/health"
+	"advanced-lb/ingress"
+	"advanced-lb/storage"
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v2"
 )
 
 type statusCapture struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
 func (sc *statusCapture) WriteHeader(code int) {
@@ -30,15 +41,154 @@ func (sc *statusCapture) WriteHeader(code int) {
 	sc.ResponseWriter.WriteHeader(code)
 }
 
+func (sc *statusCapture) Write(b []byte) (int, error) {
+	n, err := sc.ResponseWriter.Write(b)
+	sc.bytes += int64(n)
+	return n, err
+}
+
+type BackendConfig struct {
+	URL            string            `yaml:"url"`
+	Weight         *int              `yaml:"weight"`
+	MaxConnections int64             `yaml:"max_connections"`
+	Zone           string            `yaml:"zone"`
+	Labels         map[string]string `yaml:"labels"`
+	Director       struct {
+		Scheme      string `yaml:"scheme"`
+		PathPrefix  string `yaml:"path_prefix"`
+		StripPrefix string `yaml:"strip_prefix"`
+		HostHeader  string `yaml:"host_header"`
+	} `yaml:"director"`
+	Health struct {
+		Rise         int    `yaml:"rise"`
+		Fall         int    `yaml:"fall"`
+		InitialDelay string `yaml:"initial_delay"`
+	} `yaml:"health"`
+	CircuitBreaker struct {
+		Threshold int    `yaml:"threshold"`
+		Timeout   string `yaml:"timeout"`
+	} `yaml:"circuit_breaker"`
+	Transport    TransportConfig    `yaml:"transport"`
+	UpstreamAuth UpstreamAuthConfig `yaml:"upstream_auth"`
+}
+
+type UpstreamAuthConfig struct {
+	Type     string `yaml:"type"`
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	JWT      struct {
+		Secret   string `yaml:"secret"`
+		Issuer   string `yaml:"issuer"`
+		Audience string `yaml:"audience"`
+		Subject  string `yaml:"subject"`
+		TTL      string `yaml:"ttl"`
+	} `yaml:"jwt"`
+}
+
+type TransportConfig struct {
+	DialTimeout       string `yaml:"dial_timeout"`
+	ResponseTimeout   string `yaml:"response_timeout"`
+	KeepAlivePeriod   string `yaml:"keep_alive_period"`
+	IdleTimeout       string `yaml:"idle_timeout"`
+	MaxIdlePerHost    int    `yaml:"max_idle_per_host"`
+	DisableKeepAlives bool   `yaml:"disable_keep_alives"`
+}
+
+type ResponseConfig struct {
+	Status      int    `yaml:"status"`
+	Body        string `yaml:"body"`
+	ContentType string `yaml:"content_type"`
+}
+
+type MatchConfig struct {
+	Name   string `yaml:"name"`
+	Equals string `yaml:"equals"`
+	Regex  string `yaml:"regex"`
+}
+
+type RouteConfig struct {
+	Host            string             `yaml:"host"`
+	Path            string             `yaml:"path"`
+	Headers         []MatchConfig      `yaml:"headers"`
+	Cookies         []MatchConfig      `yaml:"cookies"`
+	Algorithm       string             `yaml:"algorithm"`
+	Backends        []BackendConfig    `yaml:"backends"`
+	Labels          map[string]string  `yaml:"labels"`
+	MaxResponseSize int64              `yaml:"max_response_size"`
+	UpstreamAuth    UpstreamAuthConfig `yaml:"upstream_auth"`
+	Rewrite         struct {
+		StripPrefix bool   `yaml:"strip_prefix"`
+		AddPrefix   string `yaml:"add_prefix"`
+		Regex       string `yaml:"regex"`
+		Replacement string `yaml:"replacement"`
+		Host        string `yaml:"host"`
+	} `yaml:"rewrite"`
+	Body struct {
+		JSONField  string `yaml:"json_field"`
+		SOAPAction string `yaml:"soap_action"`
+		Equals     string `yaml:"equals"`
+		MaxBytes   int64  `yaml:"max_bytes"`
+	} `yaml:"body"`
+}
+
 type Config struct {
-	Port        int    `yaml:"port"`
-	Algorithm   string `yaml:"algorithm"`
-	HealthCheck string `yaml:"health_check_interval"`
-	QLearning   struct {
-		Alpha   float64 `yaml:"alpha"`
-		Gamma   float64 `yaml:"gamma"`
-		Epsilon float64 `yaml:"epsilon"`
+	Port             int                    `yaml:"port"`
+	Algorithm        string                 `yaml:"algorithm"`
+	HealthCheck      string                 `yaml:"health_check_interval"`
+	SlowStart        string                 `yaml:"slow_start"`
+	Zone             string                 `yaml:"zone"`
+	ShutdownTimeout  string                 `yaml:"shutdown_timeout"`
+	ShutdownDelay    string                 `yaml:"shutdown_delay"`
+	DecisionBudget   string                 `yaml:"decision_budget"`
+	NegativeCache    string                 `yaml:"negative_cache_ttl"`
+	StateRetention   string                 `yaml:"state_retention"`
+	MaxResponseSize  int64                  `yaml:"max_response_size"`
+	AlgorithmOptions map[string]interface{} `yaml:"algorithm_options"`
+	QLearning        struct {
+		Alpha        float64  `yaml:"alpha"`
+		Gamma        float64  `yaml:"gamma"`
+		Epsilon      float64  `yaml:"epsilon"`
+		State        []string `yaml:"state"`
+		PathDepth    int      `yaml:"path_depth"`
+		TimeBuckets  int      `yaml:"time_buckets"`
+		DoubleQ      bool     `yaml:"double_q"`
+		Mode         string   `yaml:"mode"`
+		Warmup       int      `yaml:"warmup_requests"`
+		LoadPenalty  *float64 `yaml:"load_penalty"`
+		ErrPenalty   *float64 `yaml:"error_rate_penalty"`
+		PersistPath  string   `yaml:"persist_path"`
+		PersistEvery string   `yaml:"persist_interval"`
+		Snapshots    *int     `yaml:"persist_snapshots"`
+		Reward       struct {
+			Base              *float64 `yaml:"base"`
+			LatencyWeight     *float64 `yaml:"latency_weight"`
+			ServerError       *float64 `yaml:"server_error"`
+			ClientErrorWeight *float64 `yaml:"client_error_penalty"`
+			ConnectionWeight  *float64 `yaml:"connection_penalty"`
+			Floor             *float64 `yaml:"floor"`
+		} `yaml:"reward"`
 	} `yaml:"q_learning"`
+	Clusters []struct {
+		Name     string          `yaml:"name"`
+		Weight   *int            `yaml:"weight"`
+		Backends []BackendConfig `yaml:"backends"`
+	} `yaml:"clusters"`
+	ClusterFailover struct {
+		Overprovisioning float64 `yaml:"overprovisioning"`
+	} `yaml:"cluster_failover"`
+	Subset struct {
+		Size   int               `yaml:"size"`
+		ID     string            `yaml:"id"`
+		Labels map[string]string `yaml:"labels"`
+	} `yaml:"subset"`
+	URIHash struct {
+		IncludeQuery bool `yaml:"include_query"`
+	} `yaml:"uri_hash"`
+	Hash struct {
+		Source string `yaml:"source"`
+		Key    string `yaml:"key"`
+	} `yaml:"hash"`
 	Middleware struct {
 		Compress        bool  `yaml:"compress"`
 		MaxBodySize     int64 `yaml:"max_body_size"`
@@ -48,119 +198,1264 @@ type Config struct {
 		Threshold int    `yaml:"threshold"`
 		Timeout   string `yaml:"timeout"`
 	} `yaml:"circuit_breaker"`
+	Transport TransportConfig `yaml:"transport"`
+	Server    struct {
+		MaxHeaderBytes    int    `yaml:"max_header_bytes"`
+		ReadHeaderTimeout string `yaml:"read_header_timeout"`
+		ReadTimeout       string `yaml:"read_timeout"`
+		WriteTimeout      string `yaml:"write_timeout"`
+		IdleTimeout       string `yaml:"idle_timeout"`
+	} `yaml:"server"`
+	ConnLimit struct {
+		Enabled bool    `yaml:"enabled"`
+		Rate    float64 `yaml:"rate"`
+		Burst   float64 `yaml:"burst"`
+	} `yaml:"connection_limit"`
 	RateLimiter struct {
-		Enabled bool `yaml:"enabled"`
-		Limit   int  `yaml:"limit"`
-		Burst   int  `yaml:"burst"`
+		Enabled          bool    `yaml:"enabled"`
+		Limit            int     `yaml:"limit"`
+		Burst            int     `yaml:"burst"`
+		WarningThreshold float64 `yaml:"warning_threshold"`
+		Persist          bool    `yaml:"persist"`
+		PersistPath      string  `yaml:"persist_path"`
 	} `yaml:"rate_limiter"`
+	Mirror struct {
+		URL     string  `yaml:"url"`
+		Percent float64 `yaml:"percent"`
+		Compare bool    `yaml:"compare"`
+		MaxBody int64   `yaml:"max_body"`
+		Timeout string  `yaml:"timeout"`
+	} `yaml:"mirror"`
+	Blocklist struct {
+		Sources     []string `yaml:"sources"`
+		Interval    string   `yaml:"interval"`
+		Action      string   `yaml:"action"`
+		TarpitDelay string   `yaml:"tarpit_delay"`
+	} `yaml:"blocklist"`
+	Capture struct {
+		File    string `yaml:"file"`
+		MaxBody int64  `yaml:"max_body"`
+		Routes  []struct {
+			Host   string `yaml:"host"`
+			Prefix string `yaml:"prefix"`
+			Header string `yaml:"header"`
+			Value  string `yaml:"value"`
+		} `yaml:"routes"`
+		RedactHeaders  []string `yaml:"redact_headers"`
+		RedactFields   []string `yaml:"redact_fields"`
+		RedactPatterns []string `yaml:"redact_patterns"`
+	} `yaml:"capture"`
+	ConfigWatch struct {
+		Enabled  bool   `yaml:"enabled"`
+		Debounce string `yaml:"debounce"`
+	} `yaml:"config_watch"`
+	Warmup         WarmupConfig `yaml:"warmup"`
+	WeightProfiles struct {
+		Timezone string          `yaml:"timezone"`
+		Profiles []WeightProfile `yaml:"profiles"`
+	} `yaml:"weight_profiles"`
+	LoadShedding struct {
+		Enabled     bool    `yaml:"enabled"`
+		Objective   float64 `yaml:"objective"`
+		Window      string  `yaml:"window"`
+		MinRequests int     `yaml:"min_requests"`
+		Probability float64 `yaml:"probability"`
+	} `yaml:"load_shedding"`
+	RequestHeaders struct {
+		Enabled        bool     `yaml:"enabled"`
+		Strip          []string `yaml:"strip"`
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		Routes         []struct {
+			Prefix string   `yaml:"prefix"`
+			Allow  []string `yaml:"allow"`
+		} `yaml:"routes"`
+	} `yaml:"request_headers"`
+	ResponseHeaders struct {
+		Enabled bool              `yaml:"enabled"`
+		Strip   []string          `yaml:"strip"`
+		Set     map[string]string `yaml:"set"`
+		Routes  []struct {
+			Prefix string            `yaml:"prefix"`
+			Strip  []string          `yaml:"strip"`
+			Set    map[string]string `yaml:"set"`
+		} `yaml:"routes"`
+	} `yaml:"response_headers"`
+	MiddlewareRollout struct {
+		Percent      float64 `yaml:"percent"`
+		Probation    string  `yaml:"probation"`
+		MaxErrorRate float64 `yaml:"max_error_rate"`
+	} `yaml:"middleware_rollout"`
+	Redirects struct {
+		HTTPS     bool `yaml:"https"`
+		HTTPSPort int  `yaml:"https_port"`
+		StripWWW  bool `yaml:"strip_www"`
+		Status    int  `yaml:"status"`
+		Rules     []struct {
+			Host   string `yaml:"host"`
+			From   string `yaml:"from"`
+			To     string `yaml:"to"`
+			Status int    `yaml:"status"`
+		} `yaml:"rules"`
+	} `yaml:"redirects"`
+	Responses struct {
+		RateLimited     ResponseConfig `yaml:"rate_limited"`
+		Blocked         ResponseConfig `yaml:"blocked"`
+		Maintenance     ResponseConfig `yaml:"maintenance"`
+		MaintenanceMode bool           `yaml:"maintenance_mode"`
+		Routes          []struct {
+			Prefix          string         `yaml:"prefix"`
+			RateLimited     ResponseConfig `yaml:"rate_limited"`
+			Blocked         ResponseConfig `yaml:"blocked"`
+			Maintenance     ResponseConfig `yaml:"maintenance"`
+			MaintenanceMode bool           `yaml:"maintenance_mode"`
+		} `yaml:"routes"`
+	} `yaml:"responses"`
+	Idempotency struct {
+		Enabled      bool     `yaml:"enabled"`
+		TTL          string   `yaml:"ttl"`
+		MaxEntries   int      `yaml:"max_entries"`
+		MaxBodySize  int64    `yaml:"max_body_size"`
+		ScopeHeaders []string `yaml:"scope_headers"`
+	} `yaml:"idempotency"`
+	Auth struct {
+		Enabled bool     `yaml:"enabled"`
+		Header  string   `yaml:"header"`
+		APIKeys []string `yaml:"api_keys"`
+		Bypass  []string `yaml:"bypass"`
+	} `yaml:"auth"`
+	Kubernetes struct {
+		Enabled        bool   `yaml:"enabled"`
+		Namespace      string `yaml:"namespace"`
+		IngressClass   string `yaml:"ingress_class"`
+		ResyncInterval string `yaml:"resync_interval"`
+	} `yaml:"kubernetes"`
+	Health struct {
+		MinInterval    string   `yaml:"min_interval"`
+		MaxInterval    string   `yaml:"max_interval"`
+		Type           string   `yaml:"type"`
+		Method         string   `yaml:"method"`
+		Path           string   `yaml:"path"`
+		Timeout        string   `yaml:"timeout"`
+		ExpectedStatus []int    `yaml:"expected_status"`
+		BodyContains   string   `yaml:"body_contains"`
+		JSONField      string   `yaml:"json_field"`
+		JSONValue      string   `yaml:"json_value"`
+		GRPCService    string   `yaml:"grpc_service"`
+		Rise           int      `yaml:"rise"`
+		Fall           int      `yaml:"fall"`
+		Concurrency    int      `yaml:"concurrency"`
+		Jitter         *float64 `yaml:"jitter"`
+		InitialDelay   string   `yaml:"initial_delay"`
+		TLS            struct {
+			Enabled            bool   `yaml:"enabled"`
+			CAFile             string `yaml:"ca_file"`
+			ServerName         string `yaml:"server_name"`
+			InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+		} `yaml:"tls"`
+		Passive struct {
+			Enabled             bool    `yaml:"enabled"`
+			ConsecutiveFailures int     `yaml:"consecutive_failures"`
+			ErrorRate           float64 `yaml:"error_rate"`
+			MinRequests         int     `yaml:"min_requests"`
+			Window              int     `yaml:"window"`
+		} `yaml:"passive"`
+		OutlierDetection struct {
+			Enabled            bool    `yaml:"enabled"`
+			Interval           string  `yaml:"interval"`
+			BaseEjectionTime   string  `yaml:"base_ejection_time"`
+			MaxEjectionPercent int     `yaml:"max_ejection_percent"`
+			MinRequests        int     `yaml:"min_requests"`
+			MinHosts           int     `yaml:"min_hosts"`
+			StdevFactor        float64 `yaml:"stdev_factor"`
+		} `yaml:"outlier_detection"`
+		Webhooks []struct {
+			URL        string   `yaml:"url"`
+			Type       string   `yaml:"type"`
+			RoutingKey string   `yaml:"routing_key"`
+			Events     []string `yaml:"events"`
+		} `yaml:"webhooks"`
+	} `yaml:"health"`
+	SelfMonitor struct {
+		Interval     string  `yaml:"interval"`
+		WarnHeadroom float64 `yaml:"warn_headroom"`
+		MaxOverhead  string  `yaml:"max_overhead"`
+	} `yaml:"self_monitor"`
+	Tracing struct {
+		Enabled            bool    `yaml:"enabled"`
+		SampleRate         float64 `yaml:"sample_rate"`
+		AlwaysSampleErrors bool    `yaml:"always_sample_errors"`
+		Routes             []struct {
+			Prefix     string  `yaml:"prefix"`
+			SampleRate float64 `yaml:"sample_rate"`
+		} `yaml:"routes"`
+	} `yaml:"tracing"`
+	ExtAuthz struct {
+		Enabled         bool     `yaml:"enabled"`
+		URL             string   `yaml:"url"`
+		Timeout         string   `yaml:"timeout"`
+		Prefixes        []string `yaml:"prefixes"`
+		ForwardHeaders  []string `yaml:"forward_headers"`
+		UpstreamHeaders []string `yaml:"upstream_headers"`
+		FailOpen        bool     `yaml:"fail_open"`
+		CacheTTL        string   `yaml:"cache_ttl"`
+	} `yaml:"ext_authz"`
+	Experiments []struct {
+		Name     string `yaml:"name"`
+		Prefix   string `yaml:"prefix"`
+		Source   string `yaml:"source"`
+		Key      string `yaml:"key"`
+		Variants []struct {
+			Name    string `yaml:"name"`
+			Percent int    `yaml:"percent"`
+		} `yaml:"variants"`
+	} `yaml:"experiments"`
+	Storage struct {
+		Type      string `yaml:"type"`
+		Path      string `yaml:"path"`
+		Address   string `yaml:"address"`
+		Password  string `yaml:"password"`
+		DB        int    `yaml:"db"`
+		Prefix    string `yaml:"prefix"`
+		Bucket    string `yaml:"bucket"`
+		Region    string `yaml:"region"`
+		Endpoint  string `yaml:"endpoint"`
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key"`
+	} `yaml:"storage"`
 	SSL struct {
 		Enabled  bool   `yaml:"enabled"`
 		CertFile string `yaml:"cert_file"`
 		KeyFile  string `yaml:"key_file"`
 	} `yaml:"ssl"`
-	Backends []struct {
-		URL    string `yaml:"url"`
-		Weight int    `yaml:"weight"`
-	} `yaml:"backends"`
+	Backends []BackendConfig `yaml:"backends"`
+	Routes   []RouteConfig   `yaml:"routes"`
+	Fallback struct {
+		URL string `yaml:"url"`
+	} `yaml:"fallback"`
+	DNSExport struct {
+		Enabled   bool   `yaml:"enabled"`
+		Provider  string `yaml:"provider"`
+		Name      string `yaml:"name"`
+		TTL       int    `yaml:"ttl"`
+		Interval  string `yaml:"interval"`
+		HostsFile string `yaml:"hosts_file"`
+		ZoneID    string `yaml:"zone_id"`
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key"`
+		Endpoint  string `yaml:"endpoint"`
+	} `yaml:"dns_export"`
+	Steering struct {
+		Enabled  bool   `yaml:"enabled"`
+		Region   string `yaml:"region"`
+		Interval string `yaml:"interval"`
+		Peers    []struct {
+			Region string `yaml:"region"`
+			URL    string `yaml:"url"`
+		} `yaml:"peers"`
+	} `yaml:"steering"`
+	Canary struct {
+		URL            string  `yaml:"url"`
+		InitialPercent float64 `yaml:"initial_percent"`
+		StepPercent    float64 `yaml:"step_percent"`
+		MaxPercent     float64 `yaml:"max_percent"`
+		BakePeriod     string  `yaml:"bake_period"`
+		MaxErrorRate   float64 `yaml:"max_error_rate"`
+		MaxLatency     string  `yaml:"max_latency"`
+	} `yaml:"canary"`
 }
 
 var (
 	configPath  string
+	currentCfg  *Config
 	mu          sync.RWMutex
+	reloadMu    sync.Mutex
 	globalLB    balancer.LoadBalancer
+	fallback    *balancer.Backend
+	canary      *balancer.Backend
+	canaryCtl   *features.CanaryController
 	rateLimiter *features.RateLimiter
+	passive     *health.PassiveChecker
+	outliers    *health.OutlierDetector
+	shedder     *features.LoadShedder
+	store       storage.Store
+	lifecycle   = features.NewLifecycle()
 )
 
+var qTablePath = "qtable.json"
+
+var qTableSnapshots = 3
+
+const qTableKey = "qtable"
+
+func qTableLocation(namespace string) (string, string) {
+	if namespace == "" {
+		return qTablePath, qTableKey
+	}
+	safe := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, namespace)
+	return strings.TrimSuffix(qTablePath, ".json") + "." + safe + ".json", qTableKey + ":" + namespace
+}
+
+func persistQTable(namespace string, ql *balancer.QLearning) error {
+	path, key := qTableLocation(namespace)
+	if store == nil {
+		return ql.Persist(path, qTableSnapshots)
+	}
+	data, err := ql.MarshalState()
+	if err != nil {
+		return err
+	}
+	return store.Set(key, data, 0)
+}
+
+func loadQTable(namespace string, ql *balancer.QLearning) error {
+	path, key := qTableLocation(namespace)
+	if store == nil {
+		return ql.Load(path)
+	}
+	data, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	return ql.UnmarshalState(data)
+}
+
+func learnerNamespace(namespace, cluster string) string {
+	switch {
+	case cluster == "":
+		return namespace
+	case namespace == "":
+		return "cluster:" + cluster
+	}
+	return namespace + "|cluster:" + cluster
+}
+
+func qLearners() map[string]*balancer.QLearning {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	learners := make(map[string]*balancer.QLearning)
+	for cluster, ql := range balancer.QLearners(globalLB) {
+		learners[learnerNamespace("", cluster)] = ql
+	}
+	for _, rt := range routes {
+		for cluster, ql := range balancer.QLearners(rt.lb) {
+			learners[learnerNamespace(rt.namespace(), cluster)] = ql
+		}
+	}
+	return learners
+}
+
+func persistQTables() error {
+	var firstErr error
+	for namespace, ql := range qLearners() {
+		if err := persistQTable(namespace, ql); err != nil {
+			log.Printf("Failed to persist Q-table %q: %v", namespace, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
 func loadConfig(path string) (*Config, error) {
+	var data []byte
+	var err error
+	if source, ok := parseRemoteSource(path); ok {
+		data, err = source.load()
+	} else {
+		data, err = readConfigDocument(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func readConfigDocument(path string) ([]byte, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	var cfg Config
-	err = yaml.Unmarshal(data, &cfg)
-	if err != nil {
-		return nil, err
+	return decodeConfigDocument(data, path)
+}
+
+func decodeConfigDocument(data []byte, name string) ([]byte, error) {
+	var raw interface{}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		raw = jsonNumbers(raw)
+	case ".toml":
+		var doc map[string]interface{}
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return nil, err
+		}
+		raw = doc
+	default:
+		if !bytes.Contains(data, []byte("${")) {
+			return data, nil
+		}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := expandEnv(raw)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(raw)
+}
+
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = jsonNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+func intOr(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func durationOr(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration %q, using %v", value, fallback)
+		return fallback
+	}
+	return d
+}
+
+func breakerSettings(cfg *Config) (int, time.Duration) {
+	cbThreshold := cfg.CircuitBreaker.Threshold
+	if cbThreshold <= 0 {
+		cbThreshold = 3
+	}
+
+	cbTimeout, err := time.ParseDuration(cfg.CircuitBreaker.Timeout)
+	if err != nil {
+		cbTimeout = 10 * time.Second
+	}
+	return cbThreshold, cbTimeout
+}
+
+func backendBreakerSettings(cfg *Config, b BackendConfig) (int, time.Duration) {
+	cbThreshold, cbTimeout := breakerSettings(cfg)
+	return intOr(b.CircuitBreaker.Threshold, cbThreshold), durationOr(b.CircuitBreaker.Timeout, cbTimeout)
+}
+
+func transportOptions(global, override TransportConfig) balancer.TransportOptions {
+	return balancer.TransportOptions{
+		DialTimeout:         durationOr(override.DialTimeout, durationOr(global.DialTimeout, 0)),
+		ResponseTimeout:     durationOr(override.ResponseTimeout, durationOr(global.ResponseTimeout, 0)),
+		KeepAlive:           durationOr(override.KeepAlivePeriod, durationOr(global.KeepAlivePeriod, 0)),
+		IdleConnTimeout:     durationOr(override.IdleTimeout, durationOr(global.IdleTimeout, 0)),
+		MaxIdleConnsPerHost: intOr(override.MaxIdlePerHost, global.MaxIdlePerHost),
+		DisableKeepAlives:   global.DisableKeepAlives || override.DisableKeepAlives,
+	}
+}
+
+func responseSpec(rc ResponseConfig) features.ResponseSpec {
+	return features.ResponseSpec{Status: rc.Status, Body: rc.Body, ContentType: rc.ContentType}
+}
+
+func statusPolicy(cfg *Config) features.StatusPolicy {
+	policy := features.StatusPolicy{
+		RateLimited:   responseSpec(cfg.Responses.RateLimited),
+		Blocked:       responseSpec(cfg.Responses.Blocked),
+		Maintenance:   responseSpec(cfg.Responses.Maintenance),
+		InMaintenance: cfg.Responses.MaintenanceMode,
+	}
+	for _, rt := range cfg.Responses.Routes {
+		policy.Routes = append(policy.Routes, features.StatusRule{
+			Prefix:        rt.Prefix,
+			RateLimited:   responseSpec(rt.RateLimited),
+			Blocked:       responseSpec(rt.Blocked),
+			Maintenance:   responseSpec(rt.Maintenance),
+			InMaintenance: rt.MaintenanceMode,
+		})
+	}
+	return policy
+}
+
+func redirectPolicy(cfg *Config) features.RedirectPolicy {
+	policy := features.RedirectPolicy{
+		HTTPS:     cfg.Redirects.HTTPS,
+		HTTPSPort: cfg.Redirects.HTTPSPort,
+		StripWWW:  cfg.Redirects.StripWWW,
+		Status:    cfg.Redirects.Status,
+	}
+	for _, rule := range cfg.Redirects.Rules {
+		policy.Rules = append(policy.Rules, features.RedirectRule{Host: rule.Host, From: rule.From, To: rule.To, Status: rule.Status})
+	}
+	return policy
+}
+
+func initFallback(cfg *Config) *balancer.Backend {
+	if cfg.Fallback.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.Fallback.URL)
+	if err != nil {
+		log.Printf("Invalid fallback URL %s: %v", cfg.Fallback.URL, err)
+		return nil
+	}
+	cbThreshold, cbTimeout := breakerSettings(cfg)
+	return balancer.NewBackend(u, 1, cbThreshold, cbTimeout, transportOptions(cfg.Transport, TransportConfig{}))
+}
+
+func initCanary(cfg *Config) (*balancer.Backend, *features.CanaryController) {
+	if cfg.Canary.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.Canary.URL)
+	if err != nil {
+		log.Printf("Invalid canary URL %s: %v", cfg.Canary.URL, err)
+		return nil, nil
+	}
+
+	initial := cfg.Canary.InitialPercent
+	if initial <= 0 {
+		initial = 5
+	}
+	step := cfg.Canary.StepPercent
+	if step <= 0 {
+		step = 10
+	}
+	max := cfg.Canary.MaxPercent
+	if max <= 0 || max > 100 {
+		max = 100
+	}
+	bakePeriod, err := time.ParseDuration(cfg.Canary.BakePeriod)
+	if err != nil || bakePeriod <= 0 {
+		bakePeriod = 5 * time.Minute
+	}
+	maxErrorRate := cfg.Canary.MaxErrorRate
+	if maxErrorRate <= 0 {
+		maxErrorRate = 0.05
+	}
+	var maxLatency time.Duration
+	if cfg.Canary.MaxLatency != "" {
+		if d, err := time.ParseDuration(cfg.Canary.MaxLatency); err == nil {
+			maxLatency = d
+		}
+	}
+
+	cbThreshold, cbTimeout := breakerSettings(cfg)
+	ctl := features.NewCanaryController(initial, step, max, bakePeriod, maxErrorRate, maxLatency)
+	return balancer.NewBackend(u, 1, cbThreshold, cbTimeout, transportOptions(cfg.Transport, TransportConfig{})), ctl
+}
+
+func initBackends(cfg *Config, defs []BackendConfig, plan *backendPlan) []*balancer.Backend {
+	backends := make([]*balancer.Backend, 0, len(defs))
+
+	var slowStart time.Duration
+	if cfg.SlowStart != "" {
+		d, err := time.ParseDuration(cfg.SlowStart)
+		if err != nil {
+			log.Printf("Invalid slow_start %s: %v", cfg.SlowStart, err)
+		} else {
+			slowStart = d
+		}
+	}
+
+	for _, b := range defs {
+		u, err := url.Parse(b.URL)
+		if err != nil {
+			log.Printf("Invalid backend URL %s: %v", b.URL, err)
+			continue
+		}
+		weight := 1
+		if b.Weight != nil {
+			weight = *b.Weight
+		}
+		if plan != nil {
+			plan.weights[u.String()] = weight
+			if existing, ok := plan.reuse[u.String()]; ok {
+				backends = append(backends, existing)
+				continue
+			}
+		}
+		cbThreshold, cbTimeout := backendBreakerSettings(cfg, b)
+		backend := balancer.NewBackend(u, weight, cbThreshold, cbTimeout, transportOptions(cfg.Transport, b.Transport))
+		backend.SlowStart = slowStart
+		backend.MaxConnections = b.MaxConnections
+		backend.Zone = b.Zone
+		backend.Labels = b.Labels
+		backend.HealthRise = b.Health.Rise
+		backend.HealthFall = b.Health.Fall
+		backend.InitialDelay = durationOr(b.Health.InitialDelay, durationOr(cfg.Health.InitialDelay, 0))
+		if _, known := health.CurrentStatus(u.String()); backend.InitialDelay > 0 && !known {
+			backend.SetWarming(true)
+		}
+		if b.Director.Scheme != "" || b.Director.PathPrefix != "" || b.Director.StripPrefix != "" || b.Director.HostHeader != "" {
+			backend.SetDirector(balancer.DirectorOptions{
+				Scheme:      b.Director.Scheme,
+				PathPrefix:  b.Director.PathPrefix,
+				StripPrefix: b.Director.StripPrefix,
+				HostHeader:  b.Director.HostHeader,
+			})
+		}
+		if b.UpstreamAuth.Type != "" {
+			auth, err := newUpstreamAuth(b.UpstreamAuth)
+			if err != nil {
+				log.Printf("Ignoring upstream_auth for backend %s: %v", b.URL, err)
+			} else {
+				backend.AddRequestHook(auth.Apply)
+			}
+		}
+		backends = append(backends, backend)
+	}
+
+	if len(cfg.Subset.Labels) > 0 {
+		backends = balancer.SelectByLabels(backends, cfg.Subset.Labels)
+	}
+	if cfg.Subset.Size > 0 {
+		id := cfg.Subset.ID
+		if id == "" {
+			id, _ = os.Hostname()
+		}
+		backends = balancer.Subset(backends, id, cfg.Subset.Size)
+		log.Printf("Using subset of %d backends for instance %s", len(backends), id)
+	}
+	return backends
+}
+
+func newUpstreamAuth(ua UpstreamAuthConfig) (*features.UpstreamAuth, error) {
+	return features.NewUpstreamAuth(features.UpstreamAuthConfig{
+		Type:     ua.Type,
+		Token:    ua.Token,
+		Username: ua.Username,
+		Password: ua.Password,
+		Secret:   ua.JWT.Secret,
+		Issuer:   ua.JWT.Issuer,
+		Audience: ua.JWT.Audience,
+		Subject:  ua.JWT.Subject,
+		TTL:      durationOr(ua.JWT.TTL, 0),
+	})
+}
+
+func initLB(cfg *Config) balancer.LoadBalancer {
+	return newLB(cfg, cfg.Algorithm, defaultBackends(cfg, nil))
+}
+
+func defaultBackends(cfg *Config, plan *backendPlan) []*balancer.Backend {
+	backends := initBackends(cfg, cfg.Backends, plan)
+	for _, cl := range cfg.Clusters {
+		for _, b := range initBackends(cfg, cl.Backends, plan) {
+			if b.Cluster != cl.Name {
+				b.Cluster = cl.Name
+			}
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
+func clusterDefs(cfg *Config) []balancer.Cluster {
+	clusters := make([]balancer.Cluster, 0, len(cfg.Clusters))
+	for _, cl := range cfg.Clusters {
+		weight := 1
+		if cl.Weight != nil {
+			weight = *cl.Weight
+		}
+		clusters = append(clusters, balancer.Cluster{Name: cl.Name, Weight: weight})
+	}
+	return clusters
+}
+
+func hasClusterBackends(backends []*balancer.Backend) bool {
+	for _, b := range backends {
+		if b.Cluster != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func algorithmOptions(cfg *Config) map[string]interface{} {
+	opts := map[string]interface{}{
+		"epsilon":         cfg.QLearning.Epsilon,
+		"alpha":           cfg.QLearning.Alpha,
+		"gamma":           cfg.QLearning.Gamma,
+		"state":           cfg.QLearning.State,
+		"path_depth":      cfg.QLearning.PathDepth,
+		"time_buckets":    cfg.QLearning.TimeBuckets,
+		"double_q":        cfg.QLearning.DoubleQ,
+		"mode":            cfg.QLearning.Mode,
+		"warmup_requests": cfg.QLearning.Warmup,
+		"include_query":   cfg.URIHash.IncludeQuery,
+		"hash_source":     cfg.Hash.Source,
+		"hash_key":        cfg.Hash.Key,
+	}
+	optional := map[string]*float64{
+		"reward_base":                 cfg.QLearning.Reward.Base,
+		"reward_latency_weight":       cfg.QLearning.Reward.LatencyWeight,
+		"reward_server_error":         cfg.QLearning.Reward.ServerError,
+		"reward_client_error_penalty": cfg.QLearning.Reward.ClientErrorWeight,
+		"reward_connection_penalty":   cfg.QLearning.Reward.ConnectionWeight,
+		"reward_floor":                cfg.QLearning.Reward.Floor,
+		"load_penalty":                cfg.QLearning.LoadPenalty,
+		"error_rate_penalty":          cfg.QLearning.ErrPenalty,
+	}
+	for k, v := range optional {
+		if v != nil {
+			opts[k] = *v
+		}
+	}
+	for k, v := range cfg.AlgorithmOptions {
+		opts[k] = v
+	}
+	return opts
+}
+
+func newLB(cfg *Config, algorithm string, backends []*balancer.Backend) balancer.LoadBalancer {
+	if !balancer.IsRegistered(algorithm) {
+		log.Printf("unknown algorithm: %s, falling back to round-robin", algorithm)
+		algorithm = "round-robin"
+	}
+	opts := algorithmOptions(cfg)
+	build := func(pool *balancer.ServerPool) balancer.LoadBalancer {
+		lb, _ := balancer.New(algorithm, pool, opts)
+		return lb
+	}
+
+	var lb balancer.LoadBalancer
+	if clusters := clusterDefs(cfg); len(clusters) > 0 && hasClusterBackends(backends) {
+		lb = balancer.NewClusterBalancer(clusters, backends, cfg.Zone, cfg.ClusterFailover.Overprovisioning, build)
+	} else {
+		lb = build(&balancer.ServerPool{Backends: backends, Zone: cfg.Zone})
+	}
+
+	budget, _ := time.ParseDuration(cfg.DecisionBudget)
+	return balancer.NewGuarded(lb, algorithm, cfg.Zone, budget)
+}
+
+func validateUpstreamAuth(ua UpstreamAuthConfig) error {
+	if ua.Type == "" {
+		return nil
+	}
+	if ua.JWT.TTL != "" {
+		if d, err := time.ParseDuration(ua.JWT.TTL); err != nil || d <= 0 {
+			return fmt.Errorf("invalid upstream_auth.jwt.ttl: %s", ua.JWT.TTL)
+		}
+	}
+	if _, err := newUpstreamAuth(ua); err != nil {
+		return fmt.Errorf("invalid upstream_auth: %v", err)
+	}
+	return nil
+}
+
+func validateTransport(t TransportConfig) error {
+	durations := map[string]string{
+		"dial_timeout":      t.DialTimeout,
+		"response_timeout":  t.ResponseTimeout,
+		"keep_alive_period": t.KeepAlivePeriod,
+		"idle_timeout":      t.IdleTimeout,
+	}
+	for key, value := range durations {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("invalid transport.%s: %s", key, value)
+		}
+	}
+	if t.MaxIdlePerHost < 0 {
+		return fmt.Errorf("invalid transport.max_idle_per_host: %d", t.MaxIdlePerHost)
+	}
+	return nil
+}
+
+func validateConfig(cfg *Config) error {
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", cfg.Port)
+	}
+
+	if !balancer.IsRegistered(cfg.Algorithm) {
+		return fmt.Errorf("invalid algorithm: %s", cfg.Algorithm)
+	}
+
+	usesHash := cfg.Algorithm == "hash"
+	for _, rt := range cfg.Routes {
+		if rt.Path == "" {
+			return fmt.Errorf("route is missing a path")
+		}
+		if strings.Contains(strings.TrimSuffix(rt.Path, "*"), "*") {
+			return fmt.Errorf("route %s: '*' is only allowed at the end of the path", rt.Path)
+		}
+		if rt.Algorithm != "" && !balancer.IsRegistered(rt.Algorithm) {
+			return fmt.Errorf("invalid algorithm for route %s: %s", rt.Path, rt.Algorithm)
+		}
+		if rt.Algorithm == "hash" {
+			usesHash = true
+		}
+		for _, m := range append(append([]MatchConfig{}, rt.Headers...), rt.Cookies...) {
+			if m.Name == "" {
+				return fmt.Errorf("route %s: header and cookie rules need a name", rt.Path)
+			}
+		}
+		if _, err := newRequestPredicates(rt); err != nil {
+			return fmt.Errorf("route %s: %v", rt.Path, err)
+		}
+		if _, err := newURLRewrite(rt); err != nil {
+			return fmt.Errorf("route %s: invalid rewrite.regex: %v", rt.Path, err)
+		}
+		if err := validateUpstreamAuth(rt.UpstreamAuth); err != nil {
+			return fmt.Errorf("route %s: %v", rt.Path, err)
+		}
+		for _, b := range rt.Backends {
+			if err := validateUpstreamAuth(b.UpstreamAuth); err != nil {
+				return fmt.Errorf("route %s backend %s: %v", rt.Path, b.URL, err)
+			}
+		}
+		if rt.Body.JSONField != "" && rt.Body.SOAPAction != "" {
+			return fmt.Errorf("route %s: body.json_field and body.soap_action are mutually exclusive", rt.Path)
+		}
+		if rt.MaxResponseSize < 0 {
+			return fmt.Errorf("route %s: invalid max_response_size %d", rt.Path, rt.MaxResponseSize)
+		}
+		if rt.Body.MaxBytes < 0 {
+			return fmt.Errorf("route %s: invalid body.max_bytes %d", rt.Path, rt.Body.MaxBytes)
+		}
+		for _, b := range rt.Backends {
+			if _, err := url.Parse(b.URL); err != nil {
+				return fmt.Errorf("invalid backend URL %s for route %s: %v", b.URL, rt.Path, err)
+			}
+		}
+	}
+
+	if usesHash {
+		switch cfg.Hash.Source {
+		case "header", "cookie", "query":
+			if cfg.Hash.Key == "" {
+				return fmt.Errorf("hash source %s requires a key", cfg.Hash.Source)
+			}
+		case "ip", "":
+		default:
+			return fmt.Errorf("invalid hash source: %s", cfg.Hash.Source)
+		}
+	}
+
+	for _, feature := range cfg.QLearning.State {
+		switch feature {
+		case "path_prefix", "method", "time_of_day":
+		default:
+			return fmt.Errorf("invalid q_learning state feature: %s", feature)
+		}
+	}
+
+	for _, e := range cfg.Experiments {
+		if e.Name == "" {
+			return fmt.Errorf("experiment is missing a name")
+		}
+		total := 0
+		for _, v := range e.Variants {
+			if v.Name == "" || v.Percent < 0 {
+				return fmt.Errorf("invalid variant in experiment %s", e.Name)
+			}
+			total += v.Percent
+		}
+		if total > 100 {
+			return fmt.Errorf("variant percentages in experiment %s add up to %d", e.Name, total)
+		}
+	}
+
+	if cfg.ExtAuthz.Enabled {
+		if u, err := url.Parse(cfg.ExtAuthz.URL); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid ext_authz url: %s", cfg.ExtAuthz.URL)
+		}
+	}
+
+	if cfg.QLearning.Snapshots != nil && *cfg.QLearning.Snapshots < 0 {
+		return fmt.Errorf("invalid q_learning.persist_snapshots: %d", *cfg.QLearning.Snapshots)
+	}
+
+	switch cfg.QLearning.Mode {
+	case "", "q-learning", "sarsa":
+	default:
+		return fmt.Errorf("invalid q_learning.mode: %s", cfg.QLearning.Mode)
+	}
+
+	if cfg.Mirror.URL != "" {
+		if u, err := url.Parse(cfg.Mirror.URL); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid mirror.url: %s", cfg.Mirror.URL)
+		}
+		if cfg.Mirror.Percent < 0 || cfg.Mirror.Percent > 100 {
+			return fmt.Errorf("invalid mirror.percent: %v", cfg.Mirror.Percent)
+		}
+	}
+
+	if err := validateWeightProfiles(cfg); err != nil {
+		return err
+	}
+	if err := validateWarmup(cfg); err != nil {
+		return err
+	}
+
+	if cfg.LoadShedding.Enabled {
+		if o := cfg.LoadShedding.Objective; o != 0 && (o <= 0 || o >= 1) {
+			return fmt.Errorf("invalid load_shedding.objective: %v", o)
+		}
+		if p := cfg.LoadShedding.Probability; p < 0 || p > 1 {
+			return fmt.Errorf("invalid load_shedding.probability: %v", p)
+		}
+		if cfg.LoadShedding.Window != "" {
+			if d, err := time.ParseDuration(cfg.LoadShedding.Window); err != nil || d <= 0 {
+				return fmt.Errorf("invalid load_shedding.window %q", cfg.LoadShedding.Window)
+			}
+		}
+	}
+
+	if cfg.DNSExport.Enabled {
+		if cfg.DNSExport.Name == "" {
+			return fmt.Errorf("dns_export is enabled but no name is configured")
+		}
+		switch cfg.DNSExport.Provider {
+		case "", "hosts":
+			if cfg.DNSExport.HostsFile == "" {
+				return fmt.Errorf("dns_export provider hosts requires hosts_file")
+			}
+		case "route53":
+			if cfg.DNSExport.ZoneID == "" {
+				return fmt.Errorf("dns_export provider route53 requires zone_id")
+			}
+		default:
+			return fmt.Errorf("invalid dns_export provider: %s", cfg.DNSExport.Provider)
+		}
+	}
+
+	if cfg.Steering.Enabled {
+		if cfg.Steering.Region == "" {
+			return fmt.Errorf("steering is enabled but no region is configured")
+		}
+		for _, p := range cfg.Steering.Peers {
+			if u, err := url.Parse(p.URL); err != nil || u.Host == "" || p.Region == "" {
+				return fmt.Errorf("invalid steering peer %s: %s", p.Region, p.URL)
+			}
+		}
+	}
+
+	if cfg.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("invalid server.max_header_bytes: %d", cfg.Server.MaxHeaderBytes)
+	}
+	for _, d := range []string{cfg.Server.ReadHeaderTimeout, cfg.Server.ReadTimeout, cfg.Server.WriteTimeout, cfg.Server.IdleTimeout} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("invalid server timeout %q: %v", d, err)
+		}
+	}
+
+	allBackends := append([]BackendConfig{}, cfg.Backends...)
+	clusterNames := make(map[string]bool)
+	for _, cl := range cfg.Clusters {
+		if cl.Name == "" || clusterNames[cl.Name] {
+			return fmt.Errorf("clusters need a unique name: %q", cl.Name)
+		}
+		clusterNames[cl.Name] = true
+		if cl.Weight != nil && *cl.Weight < 0 {
+			return fmt.Errorf("invalid weight %d for cluster %s", *cl.Weight, cl.Name)
+		}
+		allBackends = append(allBackends, cl.Backends...)
+	}
+	if cfg.ClusterFailover.Overprovisioning < 0 {
+		return fmt.Errorf("invalid cluster_failover.overprovisioning: %v", cfg.ClusterFailover.Overprovisioning)
+	}
+
+	if len(allBackends) == 0 && !cfg.Kubernetes.Enabled {
+		return fmt.Errorf("no backends configured")
+	}
+
+	for _, b := range allBackends {
+		switch b.Director.Scheme {
+		case "", "http", "https":
+		default:
+			return fmt.Errorf("invalid director scheme for backend %s: %s", b.URL, b.Director.Scheme)
+		}
+		if _, err := url.Parse(b.URL); err != nil {
+			return fmt.Errorf("invalid backend URL %s: %v", b.URL, err)
+		}
+		if b.Weight != nil && *b.Weight < 0 {
+			return fmt.Errorf("invalid weight %d for backend %s", *b.Weight, b.URL)
+		}
+		if b.Health.Rise < 0 || b.Health.Fall < 0 {
+			return fmt.Errorf("invalid health rise/fall for backend %s", b.URL)
+		}
+		if b.Health.InitialDelay != "" {
+			if d, err := time.ParseDuration(b.Health.InitialDelay); err != nil || d < 0 {
+				return fmt.Errorf("invalid health initial_delay for backend %s: %s", b.URL, b.Health.InitialDelay)
+			}
+		}
+		if b.MaxConnections < 0 {
+			return fmt.Errorf("invalid max_connections %d for backend %s", b.MaxConnections, b.URL)
+		}
+		if b.CircuitBreaker.Threshold < 0 {
+			return fmt.Errorf("invalid circuit_breaker.threshold %d for backend %s", b.CircuitBreaker.Threshold, b.URL)
+		}
+		if b.CircuitBreaker.Timeout != "" {
+			if d, err := time.ParseDuration(b.CircuitBreaker.Timeout); err != nil || d <= 0 {
+				return fmt.Errorf("invalid circuit_breaker.timeout for backend %s: %s", b.URL, b.CircuitBreaker.Timeout)
+			}
+		}
+		if err := validateTransport(b.Transport); err != nil {
+			return fmt.Errorf("backend %s: %v", b.URL, err)
+		}
+		if err := validateUpstreamAuth(b.UpstreamAuth); err != nil {
+			return fmt.Errorf("backend %s: %v", b.URL, err)
+		}
+	}
+	if err := validateTransport(cfg.Transport); err != nil {
+		return err
+	}
+
+	if cfg.MiddlewareRollout.Percent < 0 || cfg.MiddlewareRollout.Percent > 100 {
+		return fmt.Errorf("invalid middleware_rollout.percent: %v", cfg.MiddlewareRollout.Percent)
+	}
+	if cfg.MiddlewareRollout.MaxErrorRate < 0 || cfg.MiddlewareRollout.MaxErrorRate > 1 {
+		return fmt.Errorf("invalid middleware_rollout.max_error_rate: %v", cfg.MiddlewareRollout.MaxErrorRate)
+	}
+	if cfg.MiddlewareRollout.Probation != "" {
+		if d, err := time.ParseDuration(cfg.MiddlewareRollout.Probation); err != nil || d < 0 {
+			return fmt.Errorf("invalid middleware_rollout.probation: %s", cfg.MiddlewareRollout.Probation)
+		}
+	}
+
+	if cfg.RateLimiter.WarningThreshold < 0 || cfg.RateLimiter.WarningThreshold > 1 {
+		return fmt.Errorf("invalid rate_limiter.warning_threshold: %v", cfg.RateLimiter.WarningThreshold)
+	}
+
+	redirectStatus := func(status int) bool {
+		switch status {
+		case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			return true
+		}
+		return false
+	}
+	if !redirectStatus(cfg.Redirects.Status) {
+		return fmt.Errorf("invalid redirects.status %d: use 301, 302, 307 or 308", cfg.Redirects.Status)
+	}
+	if cfg.Redirects.HTTPSPort < 0 || cfg.Redirects.HTTPSPort > 65535 {
+		return fmt.Errorf("invalid redirects.https_port: %d", cfg.Redirects.HTTPSPort)
+	}
+	for _, rule := range cfg.Redirects.Rules {
+		if rule.From == "" || rule.To == "" {
+			return fmt.Errorf("redirect rule needs both from and to")
+		}
+		if !redirectStatus(rule.Status) {
+			return fmt.Errorf("invalid status %d for redirect %s: use 301, 302, 307 or 308", rule.Status, rule.From)
+		}
+	}
+
+	responses := map[string]ResponseConfig{
+		"rate_limited": cfg.Responses.RateLimited,
+		"blocked":      cfg.Responses.Blocked,
+		"maintenance":  cfg.Responses.Maintenance,
+	}
+	for _, rt := range cfg.Responses.Routes {
+		responses[rt.Prefix+" rate_limited"] = rt.RateLimited
+		responses[rt.Prefix+" blocked"] = rt.Blocked
+		responses[rt.Prefix+" maintenance"] = rt.Maintenance
+	}
+	for name, rc := range responses {
+		if rc.Status != 0 && (rc.Status < 100 || rc.Status > 599) {
+			return fmt.Errorf("invalid responses status %d for %s", rc.Status, name)
+		}
+	}
+
+	if _, err := features.ParseCIDRs(cfg.RequestHeaders.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted_proxies entry: %v", err)
+	}
+
+	if cfg.Auth.Enabled && len(cfg.Auth.APIKeys) == 0 {
+		return fmt.Errorf("auth is enabled but no api_keys are configured")
+	}
+
+	switch cfg.Storage.Type {
+	case "", "memory", "bolt", "redis", "s3":
+	default:
+		return fmt.Errorf("invalid storage type: %s", cfg.Storage.Type)
+	}
+
+	for _, d := range []string{cfg.Health.MinInterval, cfg.Health.MaxInterval} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid health interval %q", d)
+		}
+	}
+	if cfg.Health.Passive.ErrorRate < 0 || cfg.Health.Passive.ErrorRate > 1 {
+		return fmt.Errorf("invalid health.passive.error_rate: %v", cfg.Health.Passive.ErrorRate)
+	}
+	for _, d := range []string{cfg.Health.OutlierDetection.Interval, cfg.Health.OutlierDetection.BaseEjectionTime} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid health.outlier_detection duration %q", d)
+		}
+	}
+	if p := cfg.Health.OutlierDetection.MaxEjectionPercent; p < 0 || p > 100 {
+		return fmt.Errorf("invalid health.outlier_detection.max_ejection_percent: %d", p)
+	}
+	if cfg.Health.OutlierDetection.StdevFactor < 0 {
+		return fmt.Errorf("invalid health.outlier_detection.stdev_factor: %v", cfg.Health.OutlierDetection.StdevFactor)
+	}
+	for _, hook := range cfg.Health.Webhooks {
+		switch hook.Type {
+		case "", "generic", "slack":
+			if hook.URL == "" {
+				return fmt.Errorf("health webhook of type %q requires url", hook.Type)
+			}
+		case "pagerduty":
+			if hook.RoutingKey == "" {
+				return fmt.Errorf("pagerduty health webhook requires routing_key")
+			}
+		default:
+			return fmt.Errorf("invalid health webhook type: %s", hook.Type)
+		}
+		for _, e := range hook.Events {
+			switch e {
+			case "up", "down", "ejected":
+			default:
+				return fmt.Errorf("invalid health webhook event: %s", e)
+			}
+		}
+	}
+	if cfg.Health.Jitter != nil && (*cfg.Health.Jitter < 0 || *cfg.Health.Jitter > 0.5) {
+		return fmt.Errorf("invalid health.jitter: %v (must be between 0 and 0.5)", *cfg.Health.Jitter)
+	}
+	if cfg.Health.TLS.CAFile != "" {
+		if _, err := health.NewTLSConfig(cfg.Health.TLS.CAFile, "", false); err != nil {
+			return fmt.Errorf("invalid health.tls.ca_file: %v", err)
+		}
+	}
+	if cfg.Health.Concurrency < 0 {
+		return fmt.Errorf("invalid health.concurrency: %d", cfg.Health.Concurrency)
+	}
+	if cfg.Health.Rise < 0 || cfg.Health.Fall < 0 {
+		return fmt.Errorf("invalid health.rise/health.fall: %d/%d", cfg.Health.Rise, cfg.Health.Fall)
+	}
+	if cfg.Health.InitialDelay != "" {
+		if d, err := time.ParseDuration(cfg.Health.InitialDelay); err != nil || d < 0 {
+			return fmt.Errorf("invalid health.initial_delay: %s", cfg.Health.InitialDelay)
+		}
+	}
+	switch cfg.Health.Type {
+	case "", "tcp", "http", "grpc":
+	default:
+		return fmt.Errorf("invalid health.type: %s", cfg.Health.Type)
 	}
-	return &cfg, nil
-}
-
-func initLB(cfg *Config) balancer.LoadBalancer {
-	pool := &balancer.ServerPool{
-		Backends: make([]*balancer.Backend, 0),
+	if cfg.Health.Timeout != "" {
+		if _, err := time.ParseDuration(cfg.Health.Timeout); err != nil {
+			return fmt.Errorf("invalid health.timeout %s: %v", cfg.Health.Timeout, err)
+		}
 	}
-
-	cbThreshold := cfg.CircuitBreaker.Threshold
-	if cbThreshold <= 0 {
-		cbThreshold = 3
+	if (cfg.Health.MinInterval == "") != (cfg.Health.MaxInterval == "") ||
+		durationOr(cfg.Health.MaxInterval, 0) < durationOr(cfg.Health.MinInterval, 0) {
+		return fmt.Errorf("health.min_interval and health.max_interval must be set together with min <= max")
 	}
 
-	cbTimeout, err := time.ParseDuration(cfg.CircuitBreaker.Timeout)
-	if err != nil {
-		cbTimeout = 10 * time.Second
+	if cfg.MaxResponseSize < 0 {
+		return fmt.Errorf("invalid max_response_size: %d", cfg.MaxResponseSize)
 	}
 
-	for _, b := range cfg.Backends {
-		u, err := url.Parse(b.URL)
-		if err != nil {
-			log.Printf("Invalid backend URL %s: %v", b.URL, err)
-			continue
+	if cfg.NegativeCache != "" {
+		if _, err := time.ParseDuration(cfg.NegativeCache); err != nil {
+			return fmt.Errorf("invalid negative_cache_ttl %s: %v", cfg.NegativeCache, err)
 		}
-		pool.Backends = append(pool.Backends, balancer.NewBackend(u, b.Weight, cbThreshold, cbTimeout))
 	}
-
-	var lb balancer.LoadBalancer
-	switch cfg.Algorithm {
-	case "round-robin":
-		lb = balancer.NewRoundRobin(pool)
-	case "least-connections":
-		lb = balancer.NewLeastConnections(pool)
-	case "q-learning":
-		epsilon := cfg.QLearning.Epsilon
-		if epsilon == 0 {
-			epsilon = 0.01
-		}
-		alpha := cfg.QLearning.Alpha
-		if alpha == 0 {
-			alpha = 0.3
-		}
-		gamma := cfg.QLearning.Gamma
-		if gamma == 0 {
-			gamma = 0.95
-		}
-		lb = balancer.NewQLearning(pool, epsilon, alpha, gamma)
-	case "weighted-round-robin":
-		lb = balancer.NewWeightedRoundRobin(pool)
-	case "ip-hash":
-		lb = balancer.NewIPHash(pool)
-	case "least-response-time":
-		lb = balancer.NewLeastResponseTime(pool)
+	switch cfg.Blocklist.Action {
+	case "", "reject", "tarpit":
 	default:
-		lb = balancer.NewRoundRobin(pool)
+		return fmt.Errorf("invalid blocklist.action: %s", cfg.Blocklist.Action)
 	}
-	return lb
-}
-
-func validateConfig(cfg *Config) error {
-	if cfg.Port <= 0 || cfg.Port > 65535 {
-		return fmt.Errorf("invalid port: %d", cfg.Port)
+	for _, p := range cfg.Capture.RedactPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid capture.redact_patterns entry %q: %v", p, err)
+		}
 	}
-
-	validAlgos := map[string]bool{
-		"round-robin": true, "least-connections": true, "q-learning": true,
-		"weighted-round-robin": true, "ip-hash": true, "least-response-time": true,
+	if cfg.Capture.MaxBody < 0 {
+		return fmt.Errorf("invalid capture.max_body: %d", cfg.Capture.MaxBody)
+	}
+	if cfg.ConfigWatch.Debounce != "" {
+		if d, err := time.ParseDuration(cfg.ConfigWatch.Debounce); err != nil || d <= 0 {
+			return fmt.Errorf("invalid config_watch.debounce %s", cfg.ConfigWatch.Debounce)
+		}
+	}
+	if cfg.ShutdownDelay != "" {
+		if d, err := time.ParseDuration(cfg.ShutdownDelay); err != nil || d < 0 {
+			return fmt.Errorf("invalid shutdown_delay %s", cfg.ShutdownDelay)
+		}
+	}
+	if cfg.StateRetention != "" {
+		if d, err := time.ParseDuration(cfg.StateRetention); err != nil || d <= 0 {
+			return fmt.Errorf("invalid state_retention %s", cfg.StateRetention)
+		}
 	}
 
-	if !validAlgos[cfg.Algorithm] {
-		return fmt.Errorf("invalid algorithm: %s", cfg.Algorithm)
+	if cfg.SlowStart != "" {
+		if _, err := time.ParseDuration(cfg.SlowStart); err != nil {
+			return fmt.Errorf("invalid slow_start %s: %v", cfg.SlowStart, err)
+		}
 	}
 
-	if len(cfg.Backends) == 0 {
-		return fmt.Errorf("no backends configured")
+	if cfg.Fallback.URL != "" {
+		if _, err := url.Parse(cfg.Fallback.URL); err != nil {
+			return fmt.Errorf("invalid fallback URL %s: %v", cfg.Fallback.URL, err)
+		}
 	}
 
-	for _, b := range cfg.Backends {
-		if _, err := url.Parse(b.URL); err != nil {
-			return fmt.Errorf("invalid backend URL %s: %v", b.URL, err)
+	if cfg.Canary.URL != "" {
+		if _, err := url.Parse(cfg.Canary.URL); err != nil {
+			return fmt.Errorf("invalid canary URL %s: %v", cfg.Canary.URL, err)
+		}
+		for _, d := range []string{cfg.Canary.BakePeriod, cfg.Canary.MaxLatency} {
+			if d == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(d); err != nil {
+				return fmt.Errorf("invalid canary duration %s: %v", d, err)
+			}
 		}
 	}
 
@@ -168,48 +1463,134 @@ func validateConfig(cfg *Config) error {
 }
 
 func reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
 	log.Println("Reloading configuration...")
 	newCfg, err := loadConfig(configPath)
 	if err != nil {
+		setConfigError(err)
+		log.Printf("Failed to reload config: %v", err)
 		http.Error(w, "Failed to reload config", http.StatusInternalServerError)
 		return
 	}
 
 	if err := validateConfig(newCfg); err != nil {
+		setConfigError(err)
 		http.Error(w, fmt.Sprintf("Invalid configuration: %v", err), http.StatusBadRequest)
 		log.Printf("Configuration validation failed: %v", err)
 		return
 	}
+	setConfigError(nil)
+
+	applyConfig(newCfg)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Configuration reloaded"))
+}
 
-	var oldQTable map[string]float64
-	var oldCounts map[string]int64
-	var oldEpsilon, oldGamma, oldMaxQValue, oldLastQDelta float64
+func applyConfig(newCfg *Config) {
+	oldStates := make(map[string]*qLearningState)
 
 	mu.RLock()
-	if ql, ok := globalLB.(*balancer.QLearning); ok {
-		oldQTable = make(map[string]float64)
-		oldCounts = make(map[string]int64)
-		ql.ExportState(&oldQTable, &oldCounts, &oldEpsilon, &oldGamma, &oldMaxQValue, &oldLastQDelta)
-		log.Println("Saved Q-Learning state for reload")
+	for cluster, ql := range balancer.QLearners(globalLB) {
+		oldStates[cluster] = exportQLearningState(ql)
 	}
 	mu.RUnlock()
+	if len(oldStates) > 0 {
+		log.Println("Saved Q-Learning state for reload")
+	}
 
 	mu.Lock()
-	globalLB = initLB(newCfg)
+	oldCfg := currentCfg
+	currentCfg = newCfg
+	balancer.SetNegativeCacheTTL(durationOr(newCfg.NegativeCache, 0))
+	balancer.SetStateRetention(durationOr(newCfg.StateRetention, 0))
+	previousLB := globalLB
+	var changed map[string]bool
+	globalLB, changed = reloadDefaultLB(oldCfg, newCfg)
+	routes = initRoutes(newCfg, globalLB, changed)
+	fallback = initFallback(newCfg)
+	features.SetStatusPolicy(statusPolicy(newCfg))
+	features.SetRedirectPolicy(redirectPolicy(newCfg))
+	if canaryCtl != nil {
+		canaryCtl.Stop()
+	}
+	canary, canaryCtl = initCanary(newCfg)
 
-	if ql, ok := globalLB.(*balancer.QLearning); ok && oldQTable != nil {
-		ql.ImportState(oldQTable, oldCounts, oldEpsilon, oldGamma, oldMaxQValue, oldLastQDelta)
-		log.Println("Q-Learning state restored after reload")
+	if globalLB != previousLB {
+		restored := false
+		for cluster, ql := range balancer.QLearners(globalLB) {
+			if state, ok := oldStates[cluster]; ok {
+				state.restore(ql)
+				restored = true
+			}
+		}
+		if restored {
+			log.Println("Q-Learning state restored after reload")
+		}
 	}
 	mu.Unlock()
 
+	rolloutPolicies(oldCfg, newCfg)
+	pruneHealthHistory()
+	lifecycle.Reload()
 	log.Println("Configuration reloaded successfully")
+}
+
+func hasAliveBackend(lb balancer.LoadBalancer) bool {
+	for _, b := range lb.GetBackends() {
+		if b.IsAlive() {
+			return true
+		}
+	}
+	return false
+}
+
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.URL.Query().Get("backend")
+	u, err := url.Parse(target)
+	if target == "" || err != nil {
+		http.Error(w, "Missing or invalid backend parameter", http.StatusBadRequest)
+		return
+	}
+
+	found := false
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			if b.URL.String() == u.String() {
+				found = true
+				lb.Drain(u)
+				break
+			}
+		}
+	}
+	if !found {
+		http.Error(w, "Backend not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Backend %s marked as draining", u)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Configuration reloaded"))
+	w.Write([]byte("Backend draining"))
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+
+	strict := false
+	devTLS := false
+	devTLSCA := ""
 	flag.StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
+	flag.BoolVar(&strict, "strict", false, "Refuse to start if any startup dependency check fails")
+	flag.BoolVar(&devTLS, "dev-tls", false, "Serve HTTPS with a generated in-memory certificate (development only)")
+	flag.StringVar(&devTLSCA, "dev-tls-ca", "", "With --dev-tls, sign the certificate with a throwaway CA and write the CA certificate to this file")
 	flag.Parse()
 
 	cfg, err := loadConfig(configPath)
@@ -217,7 +1598,81 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	if failed := runPreflight(cfg); len(failed) > 0 {
+		for _, err := range failed {
+			log.Printf("Startup check failed: %v", err)
+		}
+		if strict {
+			log.Fatalf("%d startup dependency checks failed, refusing to start (--strict)", len(failed))
+		}
+		log.Printf("Warning: %d startup dependency checks failed", len(failed))
+	}
+
+	if cfg.Storage.Type != "" {
+		store, err = storage.New(storage.Config{
+			Type:      cfg.Storage.Type,
+			Path:      cfg.Storage.Path,
+			Address:   cfg.Storage.Address,
+			Password:  cfg.Storage.Password,
+			DB:        cfg.Storage.DB,
+			Prefix:    cfg.Storage.Prefix,
+			Bucket:    cfg.Storage.Bucket,
+			Region:    cfg.Storage.Region,
+			Endpoint:  cfg.Storage.Endpoint,
+			AccessKey: cfg.Storage.AccessKey,
+			SecretKey: cfg.Storage.SecretKey,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize %s storage: %v", cfg.Storage.Type, err)
+		}
+		log.Printf("Using %s storage for persistent state", cfg.Storage.Type)
+		lifecycle.Register(features.Hook{
+			Name: "storage",
+			OnShutdown: func(ctx context.Context) error {
+				return store.Close()
+			},
+		})
+	}
+
+	if cfg.QLearning.PersistPath != "" {
+		qTablePath = cfg.QLearning.PersistPath
+	}
+	if cfg.QLearning.Snapshots != nil {
+		qTableSnapshots = *cfg.QLearning.Snapshots
+	}
+
+	currentCfg = cfg
+	balancer.SetNegativeCacheTTL(durationOr(cfg.NegativeCache, 0))
+	balancer.SetStateRetention(durationOr(cfg.StateRetention, 0))
 	globalLB = initLB(cfg)
+	routes = initRoutes(cfg, globalLB, nil)
+	fallback = initFallback(cfg)
+	features.SetStatusPolicy(statusPolicy(cfg))
+	features.SetRedirectPolicy(redirectPolicy(cfg))
+	canary, canaryCtl = initCanary(cfg)
+	startCanary := func() error {
+		mu.RLock()
+		ctl := canaryCtl
+		mu.RUnlock()
+		if ctl == nil {
+			return nil
+		}
+		return ctl.Start()
+	}
+	lifecycle.Register(features.Hook{
+		Name:     "canary",
+		OnStart:  startCanary,
+		OnReload: startCanary,
+		OnShutdown: func(ctx context.Context) error {
+			mu.RLock()
+			ctl := canaryCtl
+			mu.RUnlock()
+			if ctl != nil {
+				ctl.Stop()
+			}
+			return nil
+		},
+	})
 
 	rlLimit := cfg.RateLimiter.Limit
 	if rlLimit <= 0 {
@@ -229,75 +1684,451 @@ func main() {
 	}
 
 	rateLimiter = features.NewRateLimiter(float64(rlBurst), float64(rlLimit))
+	rateLimiter.SetWarningThreshold(cfg.RateLimiter.WarningThreshold)
+	if cfg.RateLimiter.Persist {
+		registerLimiterPersistence(cfg)
+	}
 
-	if cfg.Algorithm == "q-learning" {
-		if ql, ok := globalLB.(*balancer.QLearning); ok {
-			qTablePath := "qtable.json"
-			if err := ql.Load(qTablePath); err != nil {
-				log.Printf("Could not load Q-table (starting fresh): %v", err)
-			} else {
-				log.Println("Q-table loaded successfully")
-			}
+	for cluster, ql := range balancer.QLearners(globalLB) {
+		namespace := learnerNamespace("", cluster)
+		name := namespace
+		if name == "" {
+			name = "default"
+		}
+		if err := loadQTable(namespace, ql); err != nil {
+			log.Printf("Could not load Q-table %s (starting fresh): %v", name, err)
+		} else {
+			log.Printf("Q-table %s loaded successfully", name)
+		}
+	}
 
+	persistInterval, err := time.ParseDuration(cfg.QLearning.PersistEvery)
+	if err != nil || persistInterval <= 0 {
+		persistInterval = 5 * time.Minute
+	}
+
+	stopPersist := make(chan struct{})
+	persistDone := make(chan struct{})
+	lifecycle.Register(features.Hook{
+		Name: "qtable-persist",
+		OnStart: func() error {
 			go func() {
-				ticker := time.NewTicker(5 * time.Minute)
+				defer close(persistDone)
+				ticker := time.NewTicker(persistInterval)
 				defer ticker.Stop()
-				for range ticker.C {
-					if err := ql.Persist(qTablePath); err != nil {
-						log.Printf("Failed to persist Q-table: %v", err)
-					} else {
-						log.Println("Q-table persisted successfully")
+				for {
+					select {
+					case <-ticker.C:
+						if err := persistQTables(); err == nil {
+							log.Println("Q-tables persisted successfully")
+						}
+					case <-stopPersist:
+						return
 					}
 				}
 			}()
-		}
-	}
+			return nil
+		},
+		OnShutdown: func(ctx context.Context) error {
+			close(stopPersist)
+			<-persistDone
+			if err := persistQTables(); err != nil {
+				return err
+			}
+			log.Println("Q-tables saved successfully on shutdown")
+			return nil
+		},
+	})
 
 	healthInterval, err := time.ParseDuration(cfg.HealthCheck)
 	if err != nil {
 		healthInterval = 10 * time.Second
 	}
 
-	health.StartHealthCheck(func() balancer.LoadBalancer {
-		mu.RLock()
-		defer mu.RUnlock()
-		return globalLB
-	}, healthInterval)
+	healthCfg := health.Config{
+		Interval:       healthInterval,
+		MinInterval:    durationOr(cfg.Health.MinInterval, 0),
+		MaxInterval:    durationOr(cfg.Health.MaxInterval, 0),
+		Type:           cfg.Health.Type,
+		Method:         cfg.Health.Method,
+		Path:           cfg.Health.Path,
+		Timeout:        durationOr(cfg.Health.Timeout, 2*time.Second),
+		ExpectedStatus: cfg.Health.ExpectedStatus,
+		BodyContains:   cfg.Health.BodyContains,
+		JSONField:      cfg.Health.JSONField,
+		JSONValue:      cfg.Health.JSONValue,
+		GRPCService:    cfg.Health.GRPCService,
+		Rise:           intOr(cfg.Health.Rise, 2),
+		Fall:           intOr(cfg.Health.Fall, 3),
+		Concurrency:    intOr(cfg.Health.Concurrency, 10),
+		Jitter:         0.1,
+	}
+	if cfg.Health.Jitter != nil {
+		healthCfg.Jitter = *cfg.Health.Jitter
+	}
+	healthCfg.ForceTLS = cfg.Health.TLS.Enabled
+	healthCfg.TLS, err = health.NewTLSConfig(cfg.Health.TLS.CAFile, cfg.Health.TLS.ServerName, cfg.Health.TLS.InsecureSkipVerify)
+	if err != nil {
+		log.Fatalf("Invalid health.tls: %v", err)
+	}
+	if cfg.Health.TLS.InsecureSkipVerify {
+		log.Println("Warning: health checks skip TLS certificate verification")
+	}
+	lifecycle.Register(features.Hook{
+		Name: "health-check",
+		OnStart: func() error {
+			healthChecker = health.StartHealthCheck(allLBs, healthCfg)
+			return nil
+		},
+		OnShutdown: func(ctx context.Context) error {
+			healthChecker.Stop()
+			return nil
+		},
+	})
+
+	if cfg.DNSExport.Enabled {
+		exporter := health.NewDNSExporter(health.DNSExportConfig{
+			Provider:  cfg.DNSExport.Provider,
+			Name:      cfg.DNSExport.Name,
+			TTL:       cfg.DNSExport.TTL,
+			Interval:  durationOr(cfg.DNSExport.Interval, 15*time.Second),
+			HostsFile: cfg.DNSExport.HostsFile,
+			ZoneID:    cfg.DNSExport.ZoneID,
+			AccessKey: cfg.DNSExport.AccessKey,
+			SecretKey: cfg.DNSExport.SecretKey,
+			Endpoint:  cfg.DNSExport.Endpoint,
+		}, allLBs)
+		lifecycle.Register(features.Hook{
+			Name:    "dns-export",
+			OnStart: exporter.Start,
+			OnShutdown: func(ctx context.Context) error {
+				exporter.Stop()
+				return nil
+			},
+		})
+	}
+
+	if cfg.Health.Passive.Enabled {
+		passive = health.NewPassiveChecker(health.PassiveConfig{
+			ConsecutiveFailures: intOr(cfg.Health.Passive.ConsecutiveFailures, 5),
+			ErrorRate:           cfg.Health.Passive.ErrorRate,
+			MinRequests:         cfg.Health.Passive.MinRequests,
+			Window:              cfg.Health.Passive.Window,
+		}, allLBs)
+		log.Println("Passive health checking enabled")
+	}
+
+	if cfg.Mirror.URL != "" {
+		target, _ := url.Parse(cfg.Mirror.URL)
+		percent := cfg.Mirror.Percent
+		if percent == 0 {
+			percent = 100
+		}
+		activeMirror = newMirror(target, percent, cfg.Mirror.Compare, cfg.Mirror.MaxBody, durationOr(cfg.Mirror.Timeout, 5*time.Second))
+		log.Printf("Mirroring %.0f%% of requests to %s", percent, target)
+	}
+
+	if source, ok := parseRemoteSource(configPath); ok && cfg.ConfigWatch.Enabled {
+		watcher := newRemoteConfigWatcher(source, durationOr(cfg.ConfigWatch.Debounce, 500*time.Millisecond))
+		lifecycle.Register(features.Hook{
+			Name:    "config-watch",
+			OnStart: watcher.Start,
+			OnShutdown: func(ctx context.Context) error {
+				watcher.Stop()
+				return nil
+			},
+		})
+	} else if cfg.ConfigWatch.Enabled {
+		watcher := newConfigWatcher(configPath, durationOr(cfg.ConfigWatch.Debounce, 500*time.Millisecond))
+		lifecycle.Register(features.Hook{
+			Name:    "config-watch",
+			OnStart: watcher.Start,
+			OnShutdown: func(ctx context.Context) error {
+				watcher.Stop()
+				return nil
+			},
+		})
+	}
+
+	scheduler := newWeightScheduler()
+	lifecycle.Register(features.Hook{
+		Name:     "weight-profiles",
+		OnStart:  scheduler.Start,
+		OnReload: scheduler.Reload,
+		OnShutdown: func(ctx context.Context) error {
+			scheduler.Stop()
+			return nil
+		},
+	})
+	if len(cfg.WeightProfiles.Profiles) > 0 {
+		log.Printf("Weight profiles: %d configured", len(cfg.WeightProfiles.Profiles))
+	}
+
+	warm := newWarmer()
+	lifecycle.Register(features.Hook{
+		Name:     "warmup",
+		OnStart:  warm.Start,
+		OnReload: warm.Reload,
+		OnShutdown: func(ctx context.Context) error {
+			warm.Stop()
+			return nil
+		},
+	})
+
+	if len(cfg.Health.Webhooks) > 0 {
+		hooks := make([]health.Webhook, 0, len(cfg.Health.Webhooks))
+		for _, h := range cfg.Health.Webhooks {
+			hooks = append(hooks, health.Webhook{URL: h.URL, Kind: h.Type, RoutingKey: h.RoutingKey, Events: h.Events})
+		}
+		notifier := health.NewNotifier(hooks)
+		lifecycle.Register(features.Hook{
+			Name:    "health-webhooks",
+			OnStart: notifier.Start,
+			OnShutdown: func(ctx context.Context) error {
+				notifier.Stop()
+				return nil
+			},
+		})
+		log.Printf("Health event webhooks: %d configured", len(hooks))
+	}
+
+	if cfg.LoadShedding.Enabled {
+		objective := cfg.LoadShedding.Objective
+		if objective == 0 {
+			objective = 0.99
+		}
+		tracker := features.NewSLOTracker(objective, durationOr(cfg.LoadShedding.Window, 5*time.Minute),
+			intOr(cfg.LoadShedding.MinRequests, 20))
+		shedder = &features.LoadShedder{Tracker: tracker, Probability: cfg.LoadShedding.Probability}
+		features.RegisterMetricsSource("slo", func() interface{} {
+			return tracker.Snapshot()
+		})
+		log.Printf("Error budget-aware load shedding enabled (objective %v)", objective)
+	}
+
+	if cfg.Health.OutlierDetection.Enabled {
+		od := cfg.Health.OutlierDetection
+		outliers = health.NewOutlierDetector(health.OutlierConfig{
+			Interval:           durationOr(od.Interval, 10*time.Second),
+			BaseEjectionTime:   durationOr(od.BaseEjectionTime, 30*time.Second),
+			MaxEjectionPercent: od.MaxEjectionPercent,
+			MinRequests:        od.MinRequests,
+			MinHosts:           od.MinHosts,
+			StdevFactor:        od.StdevFactor,
+		}, allLBs)
+		lifecycle.Register(features.Hook{
+			Name:    "outlier-detection",
+			OnStart: outliers.Start,
+			OnShutdown: func(ctx context.Context) error {
+				outliers.Stop()
+				return nil
+			},
+		})
+		features.RegisterMetricsSource("outliers", func() interface{} {
+			return outliers.Ejections()
+		})
+	}
+
+	monitorInterval, err := time.ParseDuration(cfg.SelfMonitor.Interval)
+	if err != nil || monitorInterval <= 0 {
+		monitorInterval = 10 * time.Second
+	}
+	warnHeadroom := cfg.SelfMonitor.WarnHeadroom
+	if warnHeadroom <= 0 {
+		warnHeadroom = 0.2
+	}
+	maxOverhead, err := time.ParseDuration(cfg.SelfMonitor.MaxOverhead)
+	if err != nil {
+		maxOverhead = 5 * time.Millisecond
+	}
+	headroom := features.NewHeadroomEstimator(monitorInterval, warnHeadroom, maxOverhead)
+	lifecycle.Register(features.Hook{
+		Name:    "headroom",
+		OnStart: headroom.Start,
+		OnShutdown: func(ctx context.Context) error {
+			headroom.Stop()
+			return nil
+		},
+	})
+
+	features.RegisterMetricsSource("qlearning", func() interface{} {
+		out := make(map[string]balancer.ConvergenceStats)
+		for namespace, ql := range qLearners() {
+			if namespace == "" {
+				namespace = "default"
+			}
+			out[namespace] = ql.Convergence()
+		}
+		return out
+	})
+	if len(cfg.Clusters) > 0 {
+		features.RegisterMetricsSource("clusters", func() interface{} {
+			mu.RLock()
+			defer mu.RUnlock()
+			if cb, ok := balancer.AsClusterBalancer(globalLB); ok {
+				return cb.Snapshot()
+			}
+			return nil
+		})
+	}
+	features.RegisterMetricsSource("backends", func() interface{} {
+		out := make(map[string]interface{})
+		for _, lb := range allLBs() {
+			for _, b := range lb.GetBackends() {
+				out[b.URL.String()] = map[string]interface{}{
+					"labels":             b.Labels,
+					"alive":              b.IsAlive(),
+					"active_connections": atomic.LoadInt64(&b.ActiveConnections),
+				}
+			}
+		}
+		return out
+	})
+
+	watchDumpSignal()
 
 	log.Printf("Starting Load Balancer on port %d with algorithm %s", cfg.Port, cfg.Algorithm)
 
+	maxHeaderBytes := cfg.Server.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		ReadHeaderTimeout: durationOr(cfg.Server.ReadHeaderTimeout, 0),
+		ReadTimeout:       durationOr(cfg.Server.ReadTimeout, 15*time.Second),
+		WriteTimeout:      durationOr(cfg.Server.WriteTimeout, 15*time.Second),
+		IdleTimeout:       durationOr(cfg.Server.IdleTimeout, 60*time.Second),
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+
+	if cfg.Kubernetes.Enabled {
+		certs := ingress.NewCertStore()
+		if err := startIngressController(cfg, certs); err != nil {
+			log.Fatalf("Failed to start ingress controller: %v", err)
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+		log.Println("Ingress controller mode enabled")
+	}
+
+	if devTLS || devTLSCA != "" {
+		cert, err := devTLSCertificate(devTLSCA)
+		if err != nil {
+			log.Fatalf("Could not generate dev TLS certificate: %v", err)
+		}
+		if server.TLSConfig == nil {
+			server.TLSConfig = &tls.Config{}
+		}
+		server.TLSConfig.Certificates = []tls.Certificate{cert}
+		cfg.SSL.Enabled, cfg.SSL.CertFile, cfg.SSL.KeyFile = true, "", ""
+		if devTLSCA != "" {
+			log.Printf("Warning: dev TLS enabled, certificate signed by a throwaway CA written to %s", devTLSCA)
+		} else {
+			log.Println("Warning: dev TLS enabled with a self-signed certificate, do not use in production")
+		}
 	}
 
 	http.HandleFunc("/reload", reloadConfigHandler)
+	http.HandleFunc("/admin/drain", drainHandler)
+	http.HandleFunc("/admin/weight", weightHandler)
+	http.HandleFunc("/admin/algorithm", algorithmHandler)
+	http.HandleFunc("/admin/backends/", backendHistoryHandler)
+	http.HandleFunc("/admin/inflight", inflightHandler)
+	http.HandleFunc("/admin/mirror", mirrorReportHandler)
+	http.HandleFunc("/admin/heatmap", heatmapHandler)
+	http.HandleFunc("/admin/config", configDumpHandler)
+	http.HandleFunc("/admin/middleware-rollout", middlewareRolloutHandler)
+	http.HandleFunc("/admin/qlearning/reset", qLearningResetHandler)
+	http.HandleFunc("/admin/qlearning/forget", qLearningForgetHandler)
+	http.HandleFunc("/health/backends", healthBackendsHandler)
 	http.HandleFunc("/stats", features.MetricsHandler)
+	http.HandleFunc("/stats/qlearning", qLearningStatsHandler)
+	if cfg.Steering.Enabled {
+		steeringInterval, err := time.ParseDuration(cfg.Steering.Interval)
+		if err != nil || steeringInterval <= 0 {
+			steeringInterval = 10 * time.Second
+		}
+		var peers []health.SteeringPeer
+		for _, p := range cfg.Steering.Peers {
+			peers = append(peers, health.SteeringPeer{Region: p.Region, URL: p.URL})
+		}
+		steering := health.NewSteering(cfg.Steering.Region, peers, steeringInterval, allLBs)
+		lifecycle.Register(features.Hook{
+			Name:    "steering",
+			OnStart: steering.Start,
+			OnShutdown: func(ctx context.Context) error {
+				steering.Stop()
+				return nil
+			},
+		})
+		http.HandleFunc("/steering", steering.Handler)
+		log.Printf("Steering hints enabled for region %s with %d peers", cfg.Steering.Region, len(peers))
+	}
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
+	http.HandleFunc("/livez", livezHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/startupz", startupzHandler)
 
 	mainHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if cfg.RateLimiter.Enabled && !rateLimiter.Allow() {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
+		handlerStart := time.Now()
+
+		if cfg.RateLimiter.Enabled {
+			allowed, usage := rateLimiter.AllowWithWarning()
+			if !allowed {
+				features.RespondRateLimited(w, r)
+				return
+			}
+			if usage > 0 {
+				w.Header().Set("X-RateLimit-Warning", fmt.Sprintf("%.0f%% of rate limit used", usage*100))
+			}
 		}
 
 		cookie, err := r.Cookie("lb_session")
 		var peer *balancer.Backend
 
 		mu.RLock()
-		lb := globalLB
+		peekLimit := bodyPeekLimit(routes, r)
+		mu.RUnlock()
+		body := bufferBody(r, peekLimit)
+
+		mu.RLock()
+		lb, rt := routeLB(routes, r, body, globalLB)
+		maxResponse := currentCfg.MaxResponseSize
+		routeKey := "route:default"
+		if rt != nil {
+			routeKey = rt.namespace()
+			if rt.maxResponse > 0 {
+				maxResponse = rt.maxResponse
+			}
+		}
+		fb := fallback
+		cb, cc := canary, canaryCtl
 		mu.RUnlock()
 
+		clientKey := "client:" + r.RemoteAddr
+		if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			clientKey = "client:" + ip
+		}
+		if shedder != nil {
+			if shed, key := shedder.Shed(routeKey, clientKey); shed {
+				log.Printf("Shedding request for %s %s: error budget of %s exhausted", r.Method, r.URL.Path, key)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
 		if err == nil {
 			for _, b := range lb.GetBackends() {
 				if b.URL.String() == cookie.Value {
 					if b.IsAlive() {
-						peer = b
+						if !b.IsSaturated() {
+							peer = b
+						}
 						break
 					} else {
 						http.SetCookie(w, &http.Cookie{
@@ -312,28 +2143,57 @@ func main() {
 			}
 		}
 
+		pooled := true
+		if peer == nil && cc != nil && cb.IsAlive() && cc.ShouldRoute() {
+			peer = cb
+			pooled = false
+		}
+
 		if peer == nil {
 			peer = lb.NextBackend(r)
 		}
 
+		if peer == nil && fb != nil && !hasAliveBackend(lb) {
+			peer = fb
+			pooled = false
+		}
+
 		if peer == nil {
 			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:  "lb_session",
-			Value: peer.URL.String(),
-			Path:  "/",
-		})
+		if pooled {
+			http.SetCookie(w, &http.Cookie{
+				Name:   "lb_session",
+				Value:  peer.URL.String(),
+				Path:   "/",
+				Secure: r.TLS != nil,
+			})
+		}
 
 		atomic.AddInt64(&peer.ActiveConnections, 1)
 		defer atomic.AddInt64(&peer.ActiveConnections, -1)
 
+		r = balancer.WithMaxResponseBytes(r, maxResponse)
+		r, untrack := trackInflight(r, peer.URL.String())
+		defer untrack()
+
+		var mirrored *mirrorCall
+		if activeMirror != nil {
+			r, mirrored = activeMirror.start(r)
+		}
+
 		capture := &statusCapture{ResponseWriter: w, statusCode: http.StatusOK}
+		if mirrored != nil {
+			capture.ResponseWriter = mirrored.tee(w)
+		}
+
+		upstream := rt.upstreamRequest(r)
 
 		start := time.Now()
-		peer.ReverseProxy.ServeHTTP(capture, r)
+		features.RecordOverhead(start.Sub(handlerStart))
+		peer.ReverseProxy.ServeHTTP(capture, upstream)
 		duration := time.Since(start)
 
 		var requestErr error
@@ -343,16 +2203,42 @@ func main() {
 		}
 
 		features.RecordRequest(duration, capture.statusCode)
-		lb.OnRequestCompletion(peer.URL, duration, requestErr)
+		arrival, ok := features.RequestArrival(r)
+		if !ok {
+			arrival = handlerStart
+		}
+		features.RecordLBTime(handlerStart.Sub(arrival), start.Sub(handlerStart), duration)
+		if mirrored != nil {
+			mirrored.finish(capture.statusCode, duration, capture.bytes)
+		}
+		features.RecordResponseBytes(capture.bytes)
+		if passive != nil && r.Context().Err() == nil {
+			passive.Record(peer, isError)
+		}
+		features.RecordBackendLatency(peer.URL.String(), duration, isError)
+		if outliers != nil && r.Context().Err() == nil {
+			outliers.Record(peer, duration, isError)
+		}
+		if shedder != nil {
+			shedder.Tracker.Record(routeKey, isError)
+			shedder.Tracker.Record(clientKey, isError)
+		}
+		if pooled {
+			balancer.CompleteRequest(lb, r, peer.URL, duration, capture.statusCode, requestErr)
+		} else if peer == cb {
+			cc.Record(duration, isError)
+		}
 
-		log.Printf(`{"time":"%s","client":"%s","method":"%s","path":"%s","backend":"%s","status":%d,"duration_ms":%d,"error":"%v"}`,
+		log.Printf(`{"time":"%s","client":"%s","method":"%s","path":"%s","backend":"%s","status":%d,"bytes":%d,"duration_ms":%d,"lb_ms":%.3f,"error":"%v"}`,
 			start.Format(time.RFC3339),
 			r.RemoteAddr,
 			r.Method,
 			r.URL.Path,
 			peer.URL.String(),
 			capture.statusCode,
+			capture.bytes,
 			duration.Milliseconds(),
+			float64(start.Sub(arrival))/float64(time.Millisecond),
 			requestErr,
 		)
 	})
@@ -362,6 +2248,80 @@ func main() {
 		features.ProxyHeadersMiddleware,
 	}
 
+	policies, err := policyMiddlewares(cfg)
+	if err != nil {
+		log.Fatalf("Invalid request_headers policy: %v", err)
+	}
+	policyRollout = features.NewMiddlewareRollout(policies)
+	features.RegisterMetricsSource("middleware_rollout", policyRollout.Snapshot)
+	middlewares = append(middlewares, policyRollout.Middleware)
+
+	if cfg.Idempotency.Enabled {
+		ttl, err := time.ParseDuration(cfg.Idempotency.TTL)
+		if err != nil || ttl <= 0 {
+			ttl = 24 * time.Hour
+		}
+		maxEntries := cfg.Idempotency.MaxEntries
+		if maxEntries <= 0 {
+			maxEntries = 10000
+		}
+		maxBodySize := cfg.Idempotency.MaxBodySize
+		if maxBodySize <= 0 {
+			maxBodySize = 1 << 20
+		}
+		cache := features.NewIdempotencyCache(ttl, maxEntries, maxBodySize)
+		scope := cfg.Idempotency.ScopeHeaders
+		if len(scope) == 0 && cfg.Auth.Header != "" {
+			scope = []string{"Authorization", cfg.Auth.Header}
+		}
+		cache.SetScope(scope)
+		middlewares = append(middlewares, features.IdempotencyMiddleware(cache))
+	}
+
+	if cfg.ExtAuthz.Enabled {
+		timeout, _ := time.ParseDuration(cfg.ExtAuthz.Timeout)
+		cacheTTL, _ := time.ParseDuration(cfg.ExtAuthz.CacheTTL)
+		authz := features.NewExtAuthz(features.ExtAuthzConfig{
+			URL:             cfg.ExtAuthz.URL,
+			Timeout:         timeout,
+			Prefixes:        cfg.ExtAuthz.Prefixes,
+			ForwardHeaders:  cfg.ExtAuthz.ForwardHeaders,
+			UpstreamHeaders: cfg.ExtAuthz.UpstreamHeaders,
+			FailOpen:        cfg.ExtAuthz.FailOpen,
+			CacheTTL:        cacheTTL,
+		})
+		middlewares = append(middlewares, authz.Middleware)
+		log.Printf("External authorization enabled via %s (fail_open=%t)", cfg.ExtAuthz.URL, cfg.ExtAuthz.FailOpen)
+	}
+
+	if len(cfg.Capture.Routes) > 0 {
+		captureCfg := features.CaptureConfig{
+			File:          cfg.Capture.File,
+			MaxBody:       cfg.Capture.MaxBody,
+			RedactHeaders: cfg.Capture.RedactHeaders,
+			RedactFields:  cfg.Capture.RedactFields,
+		}
+		for _, rc := range cfg.Capture.Routes {
+			captureCfg.Rules = append(captureCfg.Rules, features.CaptureRule{Host: rc.Host, Prefix: rc.Prefix, Header: rc.Header, Value: rc.Value})
+		}
+		for _, p := range cfg.Capture.RedactPatterns {
+			captureCfg.RedactRegexps = append(captureCfg.RedactRegexps, regexp.MustCompile(p))
+		}
+		pc, err := features.NewPayloadCapture(captureCfg)
+		if err != nil {
+			log.Fatalf("Could not open payload capture sink: %v", err)
+		}
+		lifecycle.Register(features.Hook{
+			Name: "payload-capture",
+			OnShutdown: func(ctx context.Context) error {
+				pc.Close()
+				return nil
+			},
+		})
+		middlewares = append(middlewares, features.CaptureMiddleware(pc))
+		log.Printf("Payload capture enabled for %d route rule(s)", len(captureCfg.Rules))
+	}
+
 	if cfg.Middleware.MaxBodySize > 0 {
 		middlewares = append(middlewares, features.MaxBodySizeMiddleware(cfg.Middleware.MaxBodySize))
 	}
@@ -374,45 +2334,116 @@ func main() {
 		middlewares = append(middlewares, features.GzipMiddleware)
 	}
 
+	if len(cfg.Blocklist.Sources) > 0 {
+		blocklist := features.NewBlocklist(features.BlocklistConfig{
+			Sources:     cfg.Blocklist.Sources,
+			Interval:    durationOr(cfg.Blocklist.Interval, 5*time.Minute),
+			Action:      cfg.Blocklist.Action,
+			TarpitDelay: durationOr(cfg.Blocklist.TarpitDelay, 10*time.Second),
+		})
+		lifecycle.Register(features.Hook{
+			Name:    "blocklist",
+			OnStart: blocklist.Start,
+			OnShutdown: func(ctx context.Context) error {
+				blocklist.Stop()
+				return nil
+			},
+		})
+		features.RegisterMetricsSource("blocklist", blocklist.Snapshot)
+		middlewares = append(middlewares, features.BlocklistMiddleware(blocklist))
+	}
+
+	middlewares = append(middlewares, features.MaintenanceMiddleware)
+	middlewares = append(middlewares, features.RedirectMiddleware)
+	middlewares = append(middlewares, features.RequestStartMiddleware)
+
 	finalHandler := features.Chain(mainHandler, middlewares...)
 	log.Println("Initializing Middleware chain and registering handlers...")
 	http.Handle("/", finalHandler)
 
+	if cfg.Auth.Enabled {
+		server.Handler = features.AuthMiddleware(features.AuthConfig{
+			Header:  cfg.Auth.Header,
+			APIKeys: cfg.Auth.APIKeys,
+			Bypass:  cfg.Auth.Bypass,
+		})(http.DefaultServeMux)
+		log.Printf("API key authentication enabled with %d bypass rules", len(cfg.Auth.Bypass))
+	}
+
+	shutdownTimeout, err := time.ParseDuration(cfg.ShutdownTimeout)
+	if err != nil || shutdownTimeout <= 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+	shutdownDelay := durationOr(cfg.ShutdownDelay, 0)
+
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
+
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		<-quit
+		atomic.StoreInt32(&shuttingDown, 1)
 		log.Println("Shutting down server...")
 
-		mu.RLock()
-		if ql, ok := globalLB.(*balancer.QLearning); ok {
-			qTablePath := "qtable.json"
-			if err := ql.Persist(qTablePath); err != nil {
-				log.Printf("Failed to save Q-table on shutdown: %v", err)
-			} else {
-				log.Println("Q-table saved successfully on shutdown")
-			}
+		if shutdownDelay > 0 {
+			log.Printf("Reporting not-ready and refusing new connections for %v before draining", shutdownDelay)
+			server.SetKeepAlivesEnabled(false)
+			time.Sleep(shutdownDelay)
 		}
-		mu.RUnlock()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		log.Printf("Draining connections for up to %v (HTTP/2 clients receive GOAWAY)", shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
-			log.Fatalf("Server forced to shutdown: %v", err)
+			log.Printf("Server forced to shutdown after %v: %v", shutdownTimeout, err)
+			server.Close()
 		}
+
+		hookCtx, hookCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer hookCancel()
+		lifecycle.Shutdown(hookCtx)
 		log.Println("Server exited")
 	}()
 
+	if err := lifecycle.Start(); err != nil {
+		log.Fatalf("Startup failed: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("Could not listen on %s: %v", server.Addr, err)
+	}
+	ln = drainingListener{ln}
+	if cfg.ConnLimit.Enabled {
+		rate := cfg.ConnLimit.Rate
+		if rate <= 0 {
+			rate = 20
+		}
+		burst := cfg.ConnLimit.Burst
+		if burst <= 0 {
+			burst = 2 * rate
+		}
+		connLimiter = features.NewConnLimitListener(ln, rate, burst)
+		if len(savedConnStates) > 0 {
+			log.Printf("Restored %d per-IP connection buckets", connLimiter.RestoreBuckets(savedConnStates))
+		}
+		ln = connLimiter
+		log.Printf("Per-IP connection rate limit: %.0f/s (burst %.0f)", rate, burst)
+	}
+
 	if cfg.SSL.Enabled {
 		log.Printf("Starting HTTPS Load Balancer on port %d", cfg.Port)
-		if err := server.ListenAndServeTLS(cfg.SSL.CertFile, cfg.SSL.KeyFile); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Could not listen on %s: %v", server.Addr, err)
+		if err := server.ServeTLS(ln, cfg.SSL.CertFile, cfg.SSL.KeyFile); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Could not serve on %s: %v", server.Addr, err)
 		}
 	} else {
 		log.Printf("Starting HTTP Load Balancer on port %d", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Could not listen on %s: %v", server.Addr, err)
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Could not serve on %s: %v", server.Addr, err)
 		}
 	}
+
+	<-shutdownDone
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"advanced-lb/features"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+var policyRollout *features.MiddlewareRollout
+
+func policyMiddlewares(cfg *Config) ([]features.Middleware, error) {
+	var middlewares []features.Middleware
+
+	if cfg.Tracing.Enabled {
+		sampling := features.TraceSampling{
+			Rate:         cfg.Tracing.SampleRate,
+			AlwaysErrors: cfg.Tracing.AlwaysSampleErrors,
+		}
+		for _, rt := range cfg.Tracing.Routes {
+			sampling.Routes = append(sampling.Routes, features.TraceSamplingRule{Prefix: rt.Prefix, Rate: rt.SampleRate})
+		}
+		middlewares = append(middlewares, features.TraceSamplingMiddleware(sampling))
+		log.Printf("Trace sampling enabled at %.2f with %d route overrides", sampling.Rate, len(sampling.Routes))
+	}
+
+	if len(cfg.Experiments) > 0 {
+		experiments := make([]features.Experiment, 0, len(cfg.Experiments))
+		for _, ec := range cfg.Experiments {
+			e := features.Experiment{Name: ec.Name, Prefix: ec.Prefix, Source: ec.Source, Key: ec.Key}
+			for _, v := range ec.Variants {
+				e.Variants = append(e.Variants, features.Variant{Name: v.Name, Percent: v.Percent})
+			}
+			experiments = append(experiments, e)
+		}
+		middlewares = append(middlewares, features.ExperimentMiddleware(experiments))
+		log.Printf("A/B experiments enabled: %d", len(experiments))
+	}
+
+	if cfg.RequestHeaders.Enabled {
+		trusted, err := features.ParseCIDRs(cfg.RequestHeaders.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		policy := features.HeaderPolicy{
+			Strip:          cfg.RequestHeaders.Strip,
+			TrustedProxies: trusted,
+		}
+		for _, rt := range cfg.RequestHeaders.Routes {
+			policy.Routes = append(policy.Routes, features.HeaderRule{Prefix: rt.Prefix, Allow: rt.Allow})
+		}
+		middlewares = append(middlewares, features.HeaderPolicyMiddleware(policy))
+	}
+
+	if cfg.ResponseHeaders.Enabled {
+		policy := features.ResponseHeaderPolicy{
+			Strip: cfg.ResponseHeaders.Strip,
+			Set:   cfg.ResponseHeaders.Set,
+		}
+		for _, rt := range cfg.ResponseHeaders.Routes {
+			policy.Routes = append(policy.Routes, features.ResponseHeaderRule{Prefix: rt.Prefix, Strip: rt.Strip, Set: rt.Set})
+		}
+		middlewares = append(middlewares, features.ResponseHeaderMiddleware(policy))
+	}
+
+	return middlewares, nil
+}
+
+func policyGlobals(cfg *Config) []interface{} {
+	return []interface{}{
+		cfg.Tracing.Enabled, cfg.Tracing.SampleRate, cfg.Tracing.AlwaysSampleErrors,
+		cfg.RequestHeaders.Enabled, cfg.RequestHeaders.Strip, cfg.RequestHeaders.TrustedProxies,
+		cfg.ResponseHeaders.Enabled, cfg.ResponseHeaders.Strip, cfg.ResponseHeaders.Set,
+	}
+}
+
+func policyRules(cfg *Config) map[string][]string {
+	rules := make(map[string][]string)
+	add := func(prefix, kind string, rule interface{}) {
+		data, _ := yaml.Marshal(rule)
+		rules[prefix] = append(rules[prefix], kind+":"+string(data))
+	}
+	if cfg.Tracing.Enabled {
+		for _, rt := range cfg.Tracing.Routes {
+			add(rt.Prefix, "tracing", rt)
+		}
+	}
+	for _, e := range cfg.Experiments {
+		add(e.Prefix, "experiment", e)
+	}
+	if cfg.RequestHeaders.Enabled {
+		for _, rt := range cfg.RequestHeaders.Routes {
+			add(rt.Prefix, "request_headers", rt)
+		}
+	}
+	if cfg.ResponseHeaders.Enabled {
+		for _, rt := range cfg.ResponseHeaders.Routes {
+			add(rt.Prefix, "response_headers", rt)
+		}
+	}
+	return rules
+}
+
+func changedPolicyPrefixes(oldCfg, newCfg *Config) []string {
+	if !reflect.DeepEqual(policyGlobals(oldCfg), policyGlobals(newCfg)) {
+		return []string{""}
+	}
+	oldRules, newRules := policyRules(oldCfg), policyRules(newCfg)
+	seen := make(map[string]bool)
+	var prefixes []string
+	for _, rules := range []map[string][]string{oldRules, newRules} {
+		for prefix := range rules {
+			if seen[prefix] {
+				continue
+			}
+			seen[prefix] = true
+			if !reflect.DeepEqual(oldRules[prefix], newRules[prefix]) {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+func rolloutPolicies(oldCfg, newCfg *Config) {
+	if policyRollout == nil || oldCfg == nil {
+		return
+	}
+	middlewares, err := policyMiddlewares(newCfg)
+	if err != nil {
+		log.Printf("Keeping previous middleware chain: %v", err)
+		return
+	}
+	policyRollout.Update(middlewares, changedPolicyPrefixes(oldCfg, newCfg), features.RolloutConfig{
+		Percent:      newCfg.MiddlewareRollout.Percent,
+		Probation:    durationOr(newCfg.MiddlewareRollout.Probation, 0),
+		MaxErrorRate: newCfg.MiddlewareRollout.MaxErrorRate,
+	})
+}
+
+func middlewareRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ok bool
+	switch r.URL.Query().Get("action") {
+	case "promote":
+		ok = policyRollout.Promote()
+	case "rollback":
+		ok = policyRollout.Rollback()
+	default:
+		http.Error(w, "action must be promote or rollback", http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(w, "No middleware rollout in progress", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mirrorRecentSize     = 50
+	defaultMirrorMaxBody = 1 << 20
+)
+
+type mirrorResult struct {
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Size      int64   `json:"size"`
+	Hash      string  `json:"body_sha256,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type mirrorDivergence struct {
+	Time      time.Time    `json:"time"`
+	RequestID string       `json:"request_id,omitempty"`
+	Method    string       `json:"method"`
+	Path      string       `json:"path"`
+	Reason    string       `json:"reason"`
+	Primary   mirrorResult `json:"primary"`
+	Mirror    mirrorResult `json:"mirror"`
+}
+
+type mirror struct {
+	target  *url.URL
+	percent float64
+	compare bool
+	maxBody int64
+	client  *http.Client
+
+	mu               sync.Mutex
+	mirrored         uint64
+	failed           uint64
+	compared         uint64
+	matched          uint64
+	diverged         map[string]uint64
+	primaryLatencyMs float64
+	mirrorLatencyMs  float64
+	recent           []mirrorDivergence
+}
+
+type mirrorCall struct {
+	m       *mirror
+	id      string
+	method  string
+	path    string
+	hasher  hash.Hash
+	hashed  int64
+	done    chan mirrorResult
+	primary http.ResponseWriter
+}
+
+var activeMirror *mirror
+
+func newMirror(target *url.URL, percent float64, compare bool, maxBody int64, timeout time.Duration) *mirror {
+	if maxBody <= 0 {
+		maxBody = defaultMirrorMaxBody
+	}
+	return &mirror{
+		target:  target,
+		percent: percent,
+		compare: compare,
+		maxBody: maxBody,
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		diverged: make(map[string]uint64),
+	}
+}
+
+func (m *mirror) start(r *http.Request) (*http.Request, *mirrorCall) {
+	if rand.Float64()*100 >= m.percent {
+		return r, nil
+	}
+
+	body, ok := peekBody(r, m.maxBody)
+	if !ok {
+		return r, nil
+	}
+
+	target := *m.target
+	target.Path = strings.TrimSuffix(m.target.Path, "/") + "/" + strings.TrimPrefix(r.URL.Path, "/")
+	target.RawQuery = r.URL.RawQuery
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.client.Timeout)
+	req, err := http.NewRequestWithContext(ctx, r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return r, nil
+	}
+	req.Header = r.Header.Clone()
+	req.Host = r.Host
+
+	id, _ := r.Context().Value("RequestID").(string)
+	if id != "" {
+		req.Header.Set("X-Mirror-Of", id)
+	}
+
+	call := &mirrorCall{
+		m:      m,
+		id:     id,
+		method: r.Method,
+		path:   r.URL.Path,
+		done:   make(chan mirrorResult, 1),
+	}
+	if m.compare {
+		call.hasher = sha256.New()
+	}
+
+	go func() {
+		defer cancel()
+		start := time.Now()
+		var result mirrorResult
+		defer func() {
+			m.mu.Lock()
+			m.mirrored++
+			m.mirrorLatencyMs += result.LatencyMs
+			if result.Error != "" {
+				m.failed++
+			}
+			m.mu.Unlock()
+			call.done <- result
+		}()
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			result.Error = err.Error()
+			result.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+			return
+		}
+		var h hash.Hash
+		var sink io.Writer = io.Discard
+		if m.compare {
+			h = sha256.New()
+			sink = h
+		}
+		hashed, _ := io.Copy(sink, io.LimitReader(resp.Body, m.maxBody))
+		rest, _ := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		result.Status = resp.StatusCode
+		result.Size = hashed + rest
+		result.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+		if h != nil {
+			result.Hash = hex.EncodeToString(h.Sum(nil))
+		}
+	}()
+	return r, call
+}
+
+func (c *mirrorCall) tee(w http.ResponseWriter) http.ResponseWriter {
+	if c.hasher == nil {
+		return w
+	}
+	c.primary = w
+	return c
+}
+
+func (c *mirrorCall) Header() http.Header {
+	return c.primary.Header()
+}
+
+func (c *mirrorCall) WriteHeader(code int) {
+	c.primary.WriteHeader(code)
+}
+
+func (c *mirrorCall) Write(b []byte) (int, error) {
+	if remaining := c.m.maxBody - c.hashed; remaining > 0 {
+		chunk := b
+		if int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		c.hasher.Write(chunk)
+		c.hashed += int64(len(chunk))
+	}
+	return c.primary.Write(b)
+}
+
+func (c *mirrorCall) finish(status int, latency time.Duration, size int64) {
+	if c.hasher == nil {
+		return
+	}
+	primary := mirrorResult{
+		Status:    status,
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+		Size:      size,
+		Hash:      hex.EncodeToString(c.hasher.Sum(nil)),
+	}
+
+	go func() {
+		shadow := <-c.done
+		reason := ""
+		switch {
+		case shadow.Error != "":
+			reason = "error"
+		case shadow.Status != primary.Status:
+			reason = "status"
+		case shadow.Size != primary.Size:
+			reason = "size"
+		case shadow.Hash != primary.Hash:
+			reason = "body"
+		}
+		c.m.record(c, primary, shadow, reason)
+	}()
+}
+
+func (m *mirror) record(c *mirrorCall, primary, shadow mirrorResult, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.compared++
+	m.primaryLatencyMs += primary.LatencyMs
+	if reason == "" {
+		m.matched++
+		return
+	}
+	m.diverged[reason]++
+
+	m.recent = append(m.recent, mirrorDivergence{
+		Time:      time.Now(),
+		RequestID: c.id,
+		Method:    c.method,
+		Path:      c.path,
+		Reason:    reason,
+		Primary:   primary,
+		Mirror:    shadow,
+	})
+	if len(m.recent) > mirrorRecentSize {
+		m.recent = m.recent[len(m.recent)-mirrorRecentSize:]
+	}
+	log.Printf("Mirror divergence (%s) for %s %s: primary %d, mirror %d", reason, c.method, c.path, primary.Status, shadow.Status)
+}
+
+func mirrorReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := activeMirror
+	if m == nil {
+		http.Error(w, "Mirroring is not enabled", http.StatusNotFound)
+		return
+	}
+
+	m.mu.Lock()
+	report := map[string]interface{}{
+		"target":      m.target.String(),
+		"mirrored":    m.mirrored,
+		"errors":      m.failed,
+		"compared":    m.compared,
+		"matched":     m.matched,
+		"divergences": m.diverged,
+		"recent":      append([]mirrorDivergence{}, m.recent...),
+	}
+	if m.mirrored > 0 {
+		report["avg_mirror_latency_ms"] = m.mirrorLatencyMs / float64(m.mirrored)
+	}
+	if m.compared > 0 {
+		report["avg_primary_latency_ms"] = m.primaryLatencyMs / float64(m.compared)
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
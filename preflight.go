@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const preflightTimeout = 3 * time.Second
+
+type preflightCheck struct {
+	name    string
+	network bool
+	run     func() error
+}
+
+func preflightChecks(cfg *Config) []preflightCheck {
+	var checks []preflightCheck
+
+	seen := make(map[string]bool)
+	addBackend := func(raw string) {
+		if raw == "" || seen[raw] {
+			return
+		}
+		seen[raw] = true
+		checks = append(checks, preflightCheck{
+			name:    "backend " + raw,
+			network: true,
+			run:     func() error { return resolveBackend(raw) },
+		})
+	}
+	for _, b := range cfg.Backends {
+		addBackend(b.URL)
+	}
+	for _, cl := range cfg.Clusters {
+		for _, b := range cl.Backends {
+			addBackend(b.URL)
+		}
+	}
+	for _, rc := range cfg.Routes {
+		for _, b := range rc.Backends {
+			addBackend(b.URL)
+		}
+	}
+	addBackend(cfg.Fallback.URL)
+	addBackend(cfg.Canary.URL)
+
+	if cfg.SSL.Enabled {
+		checks = append(checks, preflightCheck{
+			name: "ssl certificate",
+			run: func() error {
+				_, err := tls.LoadX509KeyPair(cfg.SSL.CertFile, cfg.SSL.KeyFile)
+				return err
+			},
+		})
+	}
+
+	if cfg.Storage.Type == "redis" {
+		addr := cfg.Storage.Address
+		if addr == "" {
+			addr = "localhost:6379"
+		}
+		checks = append(checks, preflightCheck{
+			name:    "redis " + addr,
+			network: true,
+			run: func() error {
+				conn, err := net.DialTimeout("tcp", addr, preflightTimeout)
+				if err != nil {
+					return err
+				}
+				return conn.Close()
+			},
+		})
+	}
+	return checks
+}
+
+func resolveBackend(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+	_, err = net.DefaultResolver.LookupHost(ctx, host)
+	return err
+}
+
+func runPreflight(cfg *Config) []error {
+	return runChecks(preflightChecks(cfg))
+}
+
+func runChecks(checks []preflightCheck) []error {
+	errs := make([]error, len(checks))
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c preflightCheck) {
+			defer wg.Done()
+			if err := c.run(); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", c.name, err)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
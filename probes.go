@@ -0,0 +1,94 @@
+package main
+
+import (
+	"advanced-lb/health"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	shuttingDown  int32
+	healthChecker *health.Checker
+	configErr     error
+	configErrMu   sync.RWMutex
+)
+
+func setConfigError(err error) {
+	configErrMu.Lock()
+	configErr = err
+	configErrMu.Unlock()
+}
+
+func configError() error {
+	configErrMu.RLock()
+	defer configErrMu.RUnlock()
+	return configErr
+}
+
+type drainingListener struct {
+	net.Listener
+}
+
+func (l drainingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if atomic.LoadInt32(&shuttingDown) == 0 {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+func startupComplete() bool {
+	return healthChecker != nil && healthChecker.Completed()
+}
+
+func hasAvailableBackend() bool {
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			if b.IsAlive() && !b.IsDraining() && !b.IsWarming() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func probeResponse(w http.ResponseWriter, ok bool, reason string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(reason))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	probeResponse(w, true, "")
+}
+
+func startupzHandler(w http.ResponseWriter, r *http.Request) {
+	probeResponse(w, startupComplete(), "initial health checks have not completed")
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case atomic.LoadInt32(&shuttingDown) == 1:
+		probeResponse(w, false, "shutting down")
+	case !startupComplete():
+		probeResponse(w, false, "initial health checks have not completed")
+	case configError() != nil:
+		probeResponse(w, false, "config failed to load: "+configError().Error())
+	case !hasAvailableBackend():
+		probeResponse(w, false, "no available backends")
+	default:
+		probeResponse(w, true, "")
+	}
+}
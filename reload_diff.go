@@ -0,0 +1,157 @@
+package main
+
+import (
+	"advanced-lb/balancer"
+	"advanced-lb/health"
+	"log"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+func samePoolSettings(a, b *Config) bool {
+	return a.Algorithm == b.Algorithm &&
+		a.Zone == b.Zone &&
+		a.DecisionBudget == b.DecisionBudget &&
+		a.SlowStart == b.SlowStart &&
+		a.Health.InitialDelay == b.Health.InitialDelay &&
+		reflect.DeepEqual(a.AlgorithmOptions, b.AlgorithmOptions) &&
+		reflect.DeepEqual(a.QLearning, b.QLearning) &&
+		reflect.DeepEqual(a.URIHash, b.URIHash) &&
+		reflect.DeepEqual(a.Hash, b.Hash) &&
+		reflect.DeepEqual(a.Subset, b.Subset) &&
+		reflect.DeepEqual(clusterDefs(a), clusterDefs(b)) &&
+		a.ClusterFailover == b.ClusterFailover &&
+		reflect.DeepEqual(a.CircuitBreaker, b.CircuitBreaker) &&
+		reflect.DeepEqual(a.Transport, b.Transport)
+}
+
+func backendKey(b BackendConfig) string {
+	b.Weight = nil
+	data, err := yaml.Marshal(b)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func backendKeys(cfg *Config) map[string]string {
+	keys := make(map[string]string)
+	add := func(cluster string, defs []BackendConfig) {
+		for _, b := range defs {
+			u := normalizeURL(b.URL)
+			key := cluster + "\n" + backendKey(b)
+			if prev, ok := keys[u]; ok && prev != key {
+				key = ""
+			}
+			keys[u] = key
+		}
+	}
+	add("", cfg.Backends)
+	for _, cl := range cfg.Clusters {
+		add(cl.Name, cl.Backends)
+	}
+	for _, rt := range cfg.Routes {
+		add("", rt.Backends)
+	}
+	for _, rt := range ingressRoutes {
+		add("", rt.Backends)
+	}
+	return keys
+}
+
+func changedBackends(oldCfg, newCfg *Config) (map[string]bool, bool) {
+	if oldCfg == nil || !samePoolSettings(oldCfg, newCfg) {
+		return nil, false
+	}
+	oldKeys := backendKeys(oldCfg)
+	changed := make(map[string]bool)
+	for u, key := range backendKeys(newCfg) {
+		if prev, ok := oldKeys[u]; ok && (prev != key || key == "") {
+			changed[u] = true
+		}
+	}
+	return changed, true
+}
+
+type backendPlan struct {
+	reuse   map[string]*balancer.Backend
+	weights map[string]int
+}
+
+func newBackendPlan(lb balancer.LoadBalancer, changed map[string]bool) *backendPlan {
+	plan := &backendPlan{
+		reuse:   make(map[string]*balancer.Backend),
+		weights: make(map[string]int),
+	}
+	for _, b := range lb.GetBackends() {
+		if u := b.URL.String(); !changed[u] {
+			plan.reuse[u] = b
+		}
+	}
+	return plan
+}
+
+func (p *backendPlan) weight(b *balancer.Backend) int {
+	if w, ok := p.weights[b.URL.String()]; ok {
+		return w
+	}
+	return b.Weight()
+}
+
+func reconcileLB(lb balancer.LoadBalancer, desired []*balancer.Backend, plan *backendPlan) (added, removed, updated int) {
+	current := make(map[string]*balancer.Backend)
+	for _, b := range lb.GetBackends() {
+		current[b.URL.String()] = b
+	}
+
+	keep := make(map[string]bool)
+	for _, d := range desired {
+		u := d.URL.String()
+		keep[u] = true
+		old, ok := current[u]
+		switch {
+		case ok && old == d:
+			if w := plan.weight(d); old.Weight() != w {
+				lb.SetWeight(d.URL, w)
+				updated++
+			}
+		case ok:
+			lb.RemoveBackend(old.URL)
+			lb.AddBackend(d)
+			updated++
+		default:
+			lb.AddBackend(d)
+			added++
+		}
+	}
+	for u, b := range current {
+		if !keep[u] {
+			lb.RemoveBackend(b.URL)
+			removed++
+		}
+	}
+	return added, removed, updated
+}
+
+func reloadDefaultLB(oldCfg, newCfg *Config) (balancer.LoadBalancer, map[string]bool) {
+	changed, ok := changedBackends(oldCfg, newCfg)
+	if !ok || globalLB == nil {
+		log.Println("Pool settings changed, rebuilding all backend pools")
+		return initLB(newCfg), nil
+	}
+	plan := newBackendPlan(globalLB, changed)
+	added, removed, updated := reconcileLB(globalLB, defaultBackends(newCfg, plan), plan)
+	log.Printf("Default pool reconciled: %d added, %d removed, %d updated", added, removed, updated)
+	return globalLB, changed
+}
+
+func pruneHealthHistory() {
+	keep := make(map[string]bool)
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			keep[b.URL.String()] = true
+		}
+	}
+	health.PruneHistory(keep)
+}
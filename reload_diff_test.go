@@ -0,0 +1,117 @@
+package main
+
+import (
+	"advanced-lb/balancer"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func reloadTestConfig(backends ...BackendConfig) *Config {
+	return &Config{Algorithm: "weighted-round-robin", Backends: backends}
+}
+
+func backendsByURL(lb balancer.LoadBalancer) map[string]*balancer.Backend {
+	out := make(map[string]*balancer.Backend)
+	for _, b := range lb.GetBackends() {
+		out[b.URL.String()] = b
+	}
+	return out
+}
+
+func TestChangedBackends(t *testing.T) {
+	oldCfg := reloadTestConfig(
+		BackendConfig{URL: "http://a.test"},
+		BackendConfig{URL: "http://b.test"},
+	)
+	newCfg := reloadTestConfig(
+		BackendConfig{URL: "http://a.test", Weight: intPtr(5)},
+		BackendConfig{URL: "http://b.test", Zone: "eu"},
+		BackendConfig{URL: "http://c.test"},
+	)
+
+	changed, ok := changedBackends(oldCfg, newCfg)
+	if !ok {
+		t.Fatal("pool settings reported as changed")
+	}
+	if changed["http://a.test"] || !changed["http://b.test"] || changed["http://c.test"] {
+		t.Fatalf("changed = %v, want only http://b.test", changed)
+	}
+
+	newCfg.Algorithm = "round-robin"
+	if _, ok := changedBackends(oldCfg, newCfg); ok {
+		t.Fatal("algorithm change did not force a rebuild")
+	}
+}
+
+func TestReloadReusesUnchangedBackends(t *testing.T) {
+	oldCfg := reloadTestConfig(
+		BackendConfig{URL: "http://a.test"},
+		BackendConfig{URL: "http://b.test"},
+		BackendConfig{URL: "http://gone.test"},
+	)
+	newCfg := reloadTestConfig(
+		BackendConfig{URL: "http://a.test", Weight: intPtr(3)},
+		BackendConfig{URL: "http://b.test", Zone: "eu"},
+		BackendConfig{URL: "http://c.test"},
+	)
+	globalLB = initLB(oldCfg)
+	before := backendsByURL(globalLB)
+
+	lb, changed := reloadDefaultLB(oldCfg, newCfg)
+	if lb != globalLB || changed == nil {
+		t.Fatal("default pool was rebuilt instead of reconciled")
+	}
+	after := backendsByURL(lb)
+
+	if len(after) != 3 || after["http://gone.test"] != nil || after["http://c.test"] == nil {
+		t.Fatalf("backends after reload = %v", after)
+	}
+	if a := after["http://a.test"]; a != before["http://a.test"] || a.Weight() != 3 {
+		t.Fatalf("unchanged backend was not reused with its new weight: %p (was %p), weight %d", a, before["http://a.test"], a.Weight())
+	}
+	if b := after["http://b.test"]; b == before["http://b.test"] || b.Zone != "eu" {
+		t.Fatal("changed backend was not rebuilt")
+	}
+}
+
+func TestReconcileWhileServing(t *testing.T) {
+	cfgs := []*Config{
+		reloadTestConfig(BackendConfig{URL: "http://a.test"}, BackendConfig{URL: "http://b.test"}),
+		reloadTestConfig(BackendConfig{URL: "http://a.test", Weight: intPtr(4)}, BackendConfig{URL: "http://c.test", Zone: "eu"}),
+	}
+	globalLB = initLB(cfgs[0])
+	lb := globalLB
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/", nil)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if lb.NextBackend(r) == nil {
+					t.Error("NextBackend returned nil during reconcile")
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		if next, _ := reloadDefaultLB(cfgs[i%2], cfgs[(i+1)%2]); next != lb {
+			t.Fatal("default pool was rebuilt instead of reconciled")
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type requestPredicate struct {
+	kind   string
+	name   string
+	equals string
+	regex  *regexp.Regexp
+}
+
+func newRequestPredicates(rc RouteConfig) ([]requestPredicate, error) {
+	var preds []requestPredicate
+	add := func(kind string, defs []MatchConfig) error {
+		for _, d := range defs {
+			p := requestPredicate{kind: kind, name: d.Name, equals: d.Equals}
+			if kind == "header" {
+				p.name = http.CanonicalHeaderKey(d.Name)
+			}
+			if d.Regex != "" {
+				re, err := regexp.Compile(d.Regex)
+				if err != nil {
+					return fmt.Errorf("%s %s: %v", kind, d.Name, err)
+				}
+				p.regex = re
+			}
+			preds = append(preds, p)
+		}
+		return nil
+	}
+	if err := add("header", rc.Headers); err != nil {
+		return nil, err
+	}
+	if err := add("cookie", rc.Cookies); err != nil {
+		return nil, err
+	}
+	return preds, nil
+}
+
+func (p requestPredicate) String() string {
+	switch {
+	case p.regex != nil:
+		return p.kind + ":" + p.name + "~" + p.regex.String()
+	case p.equals != "":
+		return p.kind + ":" + p.name + "=" + p.equals
+	default:
+		return p.kind + ":" + p.name
+	}
+}
+
+func (p requestPredicate) values(r *http.Request) []string {
+	if p.kind == "cookie" {
+		c, err := r.Cookie(p.name)
+		if err != nil {
+			return nil
+		}
+		return []string{c.Value}
+	}
+	return r.Header.Values(p.name)
+}
+
+func (p requestPredicate) match(r *http.Request) bool {
+	values := p.values(r)
+	if p.equals == "" && p.regex == nil {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if p.equals != "" && v != p.equals {
+			continue
+		}
+		if p.regex != nil && !p.regex.MatchString(v) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func matchPredicates(preds []requestPredicate, r *http.Request) bool {
+	for _, p := range preds {
+		if !p.match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func predicatesString(preds []requestPredicate) string {
+	parts := make([]string, 0, len(preds))
+	for _, p := range preds {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type urlRewrite struct {
+	stripPrefix string
+	addPrefix   string
+	regex       *regexp.Regexp
+	replacement string
+	host        string
+}
+
+func newURLRewrite(rc RouteConfig) (*urlRewrite, error) {
+	rw := rc.Rewrite
+	if !rw.StripPrefix && rw.AddPrefix == "" && rw.Regex == "" && rw.Host == "" {
+		return nil, nil
+	}
+	out := &urlRewrite{
+		addPrefix:   rw.AddPrefix,
+		replacement: rw.Replacement,
+		host:        rw.Host,
+	}
+	if rw.StripPrefix {
+		out.stripPrefix = strings.TrimSuffix(strings.TrimSuffix(rc.Path, "*"), "/")
+	}
+	if rw.Regex != "" {
+		re, err := regexp.Compile(rw.Regex)
+		if err != nil {
+			return nil, err
+		}
+		out.regex = re
+	}
+	return out, nil
+}
+
+func (rw *urlRewrite) apply(r *http.Request) *http.Request {
+	out := r.Clone(r.Context())
+	path := out.URL.Path
+
+	if rw.stripPrefix != "" && strings.HasPrefix(path, rw.stripPrefix) {
+		path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, rw.stripPrefix), "/")
+		out.Header.Set("X-Forwarded-Prefix", rw.stripPrefix)
+	}
+	if rw.regex != nil {
+		path = rw.regex.ReplaceAllString(path, rw.replacement)
+	}
+	if rw.addPrefix != "" {
+		path = strings.TrimSuffix(rw.addPrefix, "/") + "/" + strings.TrimPrefix(path, "/")
+	}
+	if path != out.URL.Path {
+		out.URL.Path = path
+		out.URL.RawPath = ""
+	}
+
+	switch rw.host {
+	case "":
+	case "backend":
+		out.Host = ""
+	default:
+		out.Host = rw.host
+	}
+	return out
+}
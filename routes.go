@@ -0,0 +1,232 @@
+package main
+
+import (
+	"advanced-lb/balancer"
+	"advanced-lb/features"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type route struct {
+	host        string
+	prefix      string
+	wildcard    bool
+	body        *bodyMatcher
+	predicates  []requestPredicate
+	rewrite     *urlRewrite
+	auth        *features.UpstreamAuth
+	algorithm   string
+	lb          balancer.LoadBalancer
+	maxResponse int64
+}
+
+var (
+	routes        []*route
+	ingressRoutes []RouteConfig
+)
+
+func initRoutes(cfg *Config, defaultLB balancer.LoadBalancer, changed map[string]bool) []*route {
+	defs := make([]RouteConfig, 0, len(cfg.Routes)+len(ingressRoutes))
+	defs = append(defs, cfg.Routes...)
+	defs = append(defs, ingressRoutes...)
+
+	rs := make([]*route, 0, len(defs))
+	for _, rc := range defs {
+		predicates, err := newRequestPredicates(rc)
+		if err != nil {
+			log.Printf("Skipping route %s%s: %v", rc.Host, rc.Path, err)
+			continue
+		}
+		rewrite, err := newURLRewrite(rc)
+		if err != nil {
+			log.Printf("Skipping route %s%s: invalid rewrite: %v", rc.Host, rc.Path, err)
+			continue
+		}
+		var auth *features.UpstreamAuth
+		if rc.UpstreamAuth.Type != "" {
+			if auth, err = newUpstreamAuth(rc.UpstreamAuth); err != nil {
+				log.Printf("Skipping route %s%s: invalid upstream_auth: %v", rc.Host, rc.Path, err)
+				continue
+			}
+		}
+		algorithm := rc.Algorithm
+		if algorithm == "" {
+			algorithm = cfg.Algorithm
+		}
+
+		rt := &route{
+			host:        strings.ToLower(rc.Host),
+			prefix:      strings.TrimSuffix(rc.Path, "*"),
+			wildcard:    strings.HasSuffix(rc.Path, "*"),
+			body:        newBodyMatcher(rc),
+			predicates:  predicates,
+			rewrite:     rewrite,
+			auth:        auth,
+			algorithm:   algorithm,
+			maxResponse: rc.MaxResponseSize,
+		}
+		old := findRoute(rt.namespace())
+		reuse := changed != nil && old != nil && old.algorithm == algorithm
+		var plan *backendPlan
+		if reuse {
+			plan = newBackendPlan(old.lb, changed)
+		}
+
+		var backends []*balancer.Backend
+		if len(rc.Backends) > 0 {
+			backends = initBackends(cfg, rc.Backends, plan)
+		} else {
+			backends = append([]*balancer.Backend{}, defaultLB.GetBackends()...)
+		}
+		backends = balancer.SelectByLabels(backends, rc.Labels)
+		if len(backends) == 0 {
+			log.Printf("Route %s%s has no backends matching labels %v", rc.Host, rc.Path, rc.Labels)
+		}
+
+		if reuse {
+			reconcileLB(old.lb, backends, plan)
+			rt.lb = old.lb
+		} else {
+			rt.lb = newLB(cfg, algorithm, backends)
+			for cluster, ql := range balancer.QLearners(rt.lb) {
+				inheritQState(learnerNamespace(rt.namespace(), cluster), ql)
+			}
+		}
+		rs = append(rs, rt)
+	}
+
+	sort.SliceStable(rs, func(i, j int) bool {
+		a, b := rs[i], rs[j]
+		if (a.host != "") != (b.host != "") {
+			return a.host != ""
+		}
+		if len(a.prefix) != len(b.prefix) {
+			return len(a.prefix) > len(b.prefix)
+		}
+		return a.conditions() > b.conditions()
+	})
+	return rs
+}
+
+func (rt *route) matchPath(path string) bool {
+	if rt.wildcard && !strings.HasSuffix(rt.prefix, "/") {
+		return strings.HasPrefix(path, rt.prefix)
+	}
+	base := strings.TrimSuffix(rt.prefix, "/")
+	return path == base || strings.HasPrefix(path, base+"/")
+}
+
+func (rt *route) upstreamRequest(r *http.Request) *http.Request {
+	if rt == nil {
+		return r
+	}
+	if rt.rewrite != nil {
+		r = rt.rewrite.apply(r)
+	} else if rt.auth != nil {
+		r = r.Clone(r.Context())
+	}
+	if rt.auth != nil {
+		rt.auth.Apply(r)
+	}
+	return r
+}
+
+func (rt *route) conditions() int {
+	n := len(rt.predicates)
+	if rt.body != nil {
+		n++
+	}
+	return n
+}
+
+func (rt *route) namespace() string {
+	ns := "route:" + rt.host + rt.prefix
+	if len(rt.predicates) > 0 {
+		ns += "?" + predicatesString(rt.predicates)
+	}
+	if rt.body != nil {
+		ns += "#" + rt.body.String()
+	}
+	return ns
+}
+
+func findRoute(namespace string) *route {
+	for _, old := range routes {
+		if old.namespace() == namespace {
+			return old
+		}
+	}
+	return nil
+}
+
+func inheritQState(namespace string, ql *balancer.QLearning) {
+	for _, old := range routes {
+		for cluster, oldQL := range balancer.QLearners(old.lb) {
+			if learnerNamespace(old.namespace(), cluster) != namespace {
+				continue
+			}
+			if data, err := oldQL.MarshalState(); err == nil {
+				ql.UnmarshalState(data)
+			}
+			return
+		}
+	}
+
+	if err := loadQTable(namespace, ql); err == nil {
+		log.Printf("Q-table loaded for %s", namespace)
+	}
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func (rt *route) matchRequest(host string, r *http.Request) bool {
+	if rt.host != "" && rt.host != host {
+		return false
+	}
+	return rt.matchPath(r.URL.Path) && matchPredicates(rt.predicates, r)
+}
+
+func bodyPeekLimit(rs []*route, r *http.Request) int64 {
+	host := requestHost(r)
+	var limit int64
+	for _, rt := range rs {
+		if rt.body != nil && rt.body.maxBytes > limit && rt.body.needsBody(r) && rt.matchRequest(host, r) {
+			limit = rt.body.maxBytes
+		}
+	}
+	return limit
+}
+
+func routeLB(rs []*route, r *http.Request, body *bufferedBody, defaultLB balancer.LoadBalancer) (balancer.LoadBalancer, *route) {
+	host := requestHost(r)
+	for _, rt := range rs {
+		if !rt.matchRequest(host, r) {
+			continue
+		}
+		if rt.body != nil && !rt.body.match(r, body) {
+			continue
+		}
+		return rt.lb, rt
+	}
+	return defaultLB, nil
+}
+
+func allLBs() []balancer.LoadBalancer {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	lbs := []balancer.LoadBalancer{globalLB}
+	for _, rt := range routes {
+		lbs = append(lbs, rt.lb)
+	}
+	return lbs
+}
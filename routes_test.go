@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteMatchPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		request string
+		want    bool
+	}{
+		{"/api", "/api", true},
+		{"/api", "/api/users", true},
+		{"/api", "/apix", false},
+		{"/api", "/api-v2/users", false},
+		{"/api/", "/api", true},
+		{"/api/", "/api/users", true},
+		{"/api/", "/apix", false},
+		{"/api/*", "/api", true},
+		{"/api/*", "/api/users", true},
+		{"/api/*", "/apix", false},
+		{"/api*", "/apix", true},
+		{"/api*", "/api/users", true},
+		{"/api*", "/ap", false},
+		{"/", "/anything", true},
+		{"*", "/anything", true},
+	}
+	for _, tt := range tests {
+		rt := &route{prefix: strings.TrimSuffix(tt.path, "*"), wildcard: strings.HasSuffix(tt.path, "*")}
+		if got := rt.matchPath(tt.request); got != tt.want {
+			t.Errorf("route %q matching %q = %v, want %v", tt.path, tt.request, got, tt.want)
+		}
+	}
+}
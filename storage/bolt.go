@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("goadapt")
+
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(key string) ([]byte, error) {
+	var value []byte
+	expired := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(boltBucket).Get([]byte(key))
+		if len(raw) < 8 {
+			return ErrNotFound
+		}
+		expiresAt := int64(binary.BigEndian.Uint64(raw[:8]))
+		if expiresAt > 0 && time.Now().UnixNano() > expiresAt {
+			expired = true
+			return ErrNotFound
+		}
+		value = append([]byte{}, raw[8:]...)
+		return nil
+	})
+
+	if expired {
+		s.Delete(key)
+	}
+	return value, err
+}
+
+func (s *BoltStore) Set(key string, value []byte, ttl time.Duration) error {
+	raw := make([]byte, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(raw[:8], uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(raw[8:], value)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), raw)
+	})
+}
+
+func (s *BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
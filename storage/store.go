@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotFound = errors.New("storage: key not found")
+
+type Store interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	Close() error
+}
+
+type Config struct {
+	Type      string
+	Path      string
+	Address   string
+	Password  string
+	DB        int
+	Prefix    string
+	Bucket    string
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+}
+
+func New(cfg Config) (Store, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "bolt":
+		path := cfg.Path
+		if path == "" {
+			path = "goadapt.db"
+		}
+		return NewBoltStore(path)
+	case "redis":
+		addr := cfg.Address
+		if addr == "" {
+			addr = "localhost:6379"
+		}
+		return NewRedisStore(addr, cfg.Password, cfg.DB, cfg.Prefix)
+	case "s3":
+		return NewS3Store(cfg.Endpoint, cfg.Bucket, cfg.Region, cfg.AccessKey, cfg.SecretKey, cfg.Prefix)
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", cfg.Type)
+	}
+}
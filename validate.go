@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var durationSuffixes = []string{
+	"interval", "timeout", "ttl", "delay", "budget", "retention", "debounce",
+	"slow_start", "ejection_time", "overhead", "window", "period", "probation",
+}
+
+func isDurationKey(key string) bool {
+	for _, suffix := range durationSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkDurations(v reflect.Value, path string) []error {
+	var errs []error
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			errs = append(errs, checkDurations(v.Elem(), path)...)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, checkDurations(v.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			field := v.Field(i)
+			name := key
+			if path != "" {
+				name = path + "." + key
+			}
+			if field.Kind() == reflect.String {
+				if raw := field.String(); raw != "" && isDurationKey(key) {
+					if _, err := time.ParseDuration(raw); err != nil {
+						errs = append(errs, fmt.Errorf("%s: invalid duration %q", name, raw))
+					}
+				}
+				continue
+			}
+			errs = append(errs, checkDurations(field, name)...)
+		}
+	}
+	return errs
+}
+
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := fs.String("config", "config.yaml", "Path to configuration file")
+	skipNetwork := fs.Bool("offline", false, "Skip DNS resolution and network dependency checks")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to load: %v\n", *path, err)
+		return 1
+	}
+
+	var errs []error
+	if err := validateConfig(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, checkDurations(reflect.ValueOf(cfg).Elem(), "")...)
+	var checks []preflightCheck
+	for _, c := range preflightChecks(cfg) {
+		if !*skipNetwork || !c.network {
+			checks = append(checks, c)
+		}
+	}
+	errs = append(errs, runChecks(checks)...)
+
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *path, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d problem(s) found\n", *path, len(errs))
+		return 1
+	}
+	fmt.Printf("%s: configuration is valid\n", *path)
+	return 0
+}
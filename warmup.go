@@ -0,0 +1,168 @@
+package main
+
+import (
+	"advanced-lb/balancer"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type WarmupConfig struct {
+	URLs        []string `yaml:"urls"`
+	Interval    string   `yaml:"interval"`
+	Timeout     string   `yaml:"timeout"`
+	Concurrency int      `yaml:"concurrency"`
+}
+
+type warmer struct {
+	warmed map[*balancer.Backend]bool
+	last   time.Time
+	reload chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newWarmer() *warmer {
+	return &warmer{
+		warmed: make(map[*balancer.Backend]bool),
+		reload: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (w *warmer) Start() error {
+	w.run(false)
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mu.RLock()
+				interval := durationOr(currentCfg.Warmup.Interval, 0)
+				mu.RUnlock()
+				if interval > 0 && time.Since(w.last) >= interval {
+					w.run(false)
+				}
+			case <-w.reload:
+				w.run(true)
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (w *warmer) Reload() error {
+	select {
+	case w.reload <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (w *warmer) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *warmer) run(onlyNew bool) {
+	mu.RLock()
+	cfg := currentCfg.Warmup
+	mu.RUnlock()
+
+	warmed := make(map[*balancer.Backend]bool)
+	var pending []*balancer.Backend
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			if warmed[b] {
+				continue
+			}
+			warmed[b] = true
+			if onlyNew && w.warmed[b] {
+				continue
+			}
+			pending = append(pending, b)
+		}
+	}
+	w.warmed = warmed
+	w.last = time.Now()
+	if len(cfg.URLs) == 0 || len(pending) == 0 {
+		return
+	}
+
+	start := time.Now()
+	timeout := durationOr(cfg.Timeout, 5*time.Second)
+	sem := make(chan struct{}, intOr(cfg.Concurrency, 4))
+	var wg sync.WaitGroup
+	var failed int64
+	for _, b := range pending {
+		for _, path := range cfg.URLs {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(b *balancer.Backend, path string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if err := warmURL(b, path, timeout); err != nil {
+					log.Printf("Warm-up of %s%s failed: %v", b.URL, path, err)
+					atomic.AddInt64(&failed, 1)
+				}
+			}(b, path)
+		}
+	}
+	wg.Wait()
+	log.Printf("Warm-up: fetched %d URLs on %d backends in %v (%d failed)",
+		len(cfg.URLs), len(pending), time.Since(start).Round(time.Millisecond), failed)
+}
+
+func warmURL(b *balancer.Backend, path string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "goadapt-warmup")
+	b.ReverseProxy.Director(req)
+
+	resp, err := b.ReverseProxy.Transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func validateWarmup(cfg *Config) error {
+	for _, u := range cfg.Warmup.URLs {
+		if !strings.HasPrefix(u, "/") {
+			return fmt.Errorf("invalid warmup url %q (must be a path starting with /)", u)
+		}
+	}
+	for _, d := range []string{cfg.Warmup.Interval, cfg.Warmup.Timeout} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid warmup duration %q", d)
+		}
+	}
+	if cfg.Warmup.Concurrency < 0 {
+		return fmt.Errorf("invalid warmup.concurrency: %d", cfg.Warmup.Concurrency)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type WeightProfile struct {
+	Name    string         `yaml:"name"`
+	Days    []string       `yaml:"days"`
+	Start   string         `yaml:"start"`
+	End     string         `yaml:"end"`
+	Weights map[string]int `yaml:"weights"`
+}
+
+type weightScheduler struct {
+	active    string
+	from      map[string]int
+	to        map[string]int
+	rampStart time.Time
+	reload    chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newWeightScheduler() *weightScheduler {
+	return &weightScheduler{
+		reload: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *weightScheduler) Start() error {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		s.step(time.Now(), true)
+		for {
+			select {
+			case <-ticker.C:
+				s.step(time.Now(), false)
+			case <-s.reload:
+				s.step(time.Now(), true)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *weightScheduler) Reload() error {
+	select {
+	case s.reload <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *weightScheduler) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *weightScheduler) step(now time.Time, force bool) {
+	mu.RLock()
+	cfg := currentCfg
+	mu.RUnlock()
+
+	if len(cfg.WeightProfiles.Profiles) == 0 && s.active == "" && s.to == nil {
+		return
+	}
+	name, weights := activeWeightProfile(cfg, now)
+	if name != s.active || force {
+		target := configuredWeights(cfg)
+		for raw, w := range weights {
+			target[normalizeURL(raw)] = w
+		}
+		current := make(map[string]int)
+		for _, lb := range allLBs() {
+			for _, b := range lb.GetBackends() {
+				current[b.URL.String()] = b.Weight()
+			}
+		}
+
+		if name != s.active {
+			if name == "" {
+				log.Printf("Weight profile %s ended, restoring configured weights", s.active)
+			} else {
+				log.Printf("Weight profile %s is now active", name)
+			}
+		}
+		s.active = name
+		s.from, s.to = current, target
+		s.rampStart = now
+		if force {
+			s.rampStart = time.Time{}
+		}
+	}
+	if s.to == nil {
+		return
+	}
+
+	progress := 1.0
+	if slowStart := durationOr(cfg.SlowStart, 0); slowStart > 0 && !s.rampStart.IsZero() {
+		progress = math.Min(float64(now.Sub(s.rampStart))/float64(slowStart), 1)
+	}
+	for _, lb := range allLBs() {
+		for _, b := range lb.GetBackends() {
+			key := b.URL.String()
+			to, ok := s.to[key]
+			if !ok {
+				continue
+			}
+			from, ok := s.from[key]
+			if !ok {
+				from = to
+			}
+			w := from + int(math.Round(float64(to-from)*progress))
+			if b.Weight() != w {
+				lb.SetWeight(b.URL, w)
+			}
+		}
+	}
+	if progress >= 1 {
+		s.to = nil
+	}
+}
+
+func activeWeightProfile(cfg *Config, now time.Time) (string, map[string]int) {
+	if cfg.WeightProfiles.Timezone != "" {
+		if loc, err := time.LoadLocation(cfg.WeightProfiles.Timezone); err == nil {
+			now = now.In(loc)
+		}
+	}
+	minute := now.Hour()*60 + now.Minute()
+	for _, p := range cfg.WeightProfiles.Profiles {
+		if len(p.Days) > 0 {
+			today := false
+			for _, d := range p.Days {
+				if weekdays[strings.ToLower(d)] == now.Weekday() {
+					today = true
+					break
+				}
+			}
+			if !today {
+				continue
+			}
+		}
+		start, _ := parseClock(p.Start)
+		end, _ := parseClock(p.End)
+		if start <= end && minute >= start && minute < end ||
+			start > end && (minute >= start || minute < end) {
+			return p.Name, p.Weights
+		}
+	}
+	return "", nil
+}
+
+func configuredWeights(cfg *Config) map[string]int {
+	weights := make(map[string]int)
+	add := func(backends []BackendConfig) {
+		for _, b := range backends {
+			w := 1
+			if b.Weight != nil {
+				w = *b.Weight
+			}
+			weights[normalizeURL(b.URL)] = w
+		}
+	}
+	add(cfg.Backends)
+	for _, cl := range cfg.Clusters {
+		add(cl.Backends)
+	}
+	for _, rc := range cfg.Routes {
+		add(rc.Backends)
+	}
+	return weights
+}
+
+func normalizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.String()
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateWeightProfiles(cfg *Config) error {
+	if cfg.WeightProfiles.Timezone != "" {
+		if _, err := time.LoadLocation(cfg.WeightProfiles.Timezone); err != nil {
+			return fmt.Errorf("invalid weight_profiles.timezone: %v", err)
+		}
+	}
+	for _, p := range cfg.WeightProfiles.Profiles {
+		if p.Name == "" {
+			return fmt.Errorf("weight profile is missing a name")
+		}
+		if _, err := parseClock(p.Start); err != nil {
+			return fmt.Errorf("weight profile %s: %v", p.Name, err)
+		}
+		if _, err := parseClock(p.End); err != nil {
+			return fmt.Errorf("weight profile %s: %v", p.Name, err)
+		}
+		for _, d := range p.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("weight profile %s: invalid day %q", p.Name, d)
+			}
+		}
+		for u, w := range p.Weights {
+			if w < 0 {
+				return fmt.Errorf("weight profile %s: invalid weight %d for %s", p.Name, w, u)
+			}
+		}
+	}
+	return nil
+}